@@ -0,0 +1,136 @@
+// Package benchdata generates terminal output that looks like what real
+// programs send, for benchmarking the parser and state. The output is the
+// same on every run.
+package benchdata
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+)
+
+const (
+	Rows = 24
+	Cols = 80
+)
+
+var words = []string{
+	"the", "terminal", "func", "return", "err", "nil", "state", "buffer",
+	"if", "for", "range", "package", "import", "struct", "int", "string",
+	"a", "of", "to", "in", "is", "it", "that", "with", "as", "on", "be",
+	"parser", "screen", "cursor", "row", "col", "2021-03-04T10:22:31Z",
+	"INFO", "request", "completed", "duration=12.5ms", "status=200",
+}
+
+func line(r *rand.Rand, max int) []byte {
+	var buf bytes.Buffer
+
+	for {
+		w := words[r.Intn(len(words))]
+		if buf.Len()+len(w)+1 > max {
+			break
+		}
+
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+
+		buf.WriteString(w)
+
+		if r.Intn(8) == 0 {
+			break
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// Cat is plain text scrolling past, like cat of a large file or tailing a
+// log.
+func Cat(size int) []byte {
+	r := rand.New(rand.NewSource(1))
+
+	var buf bytes.Buffer
+
+	for buf.Len() < size {
+		buf.Write(line(r, Cols*3/2))
+		buf.WriteString("\r\n")
+	}
+
+	return buf.Bytes()
+}
+
+// Vim is an editor scrolling through a file with syntax highlighting, a
+// line number column and a status line.
+func Vim(size int) []byte {
+	r := rand.New(rand.NewSource(2))
+
+	var buf bytes.Buffer
+
+	colors := []int{130, 28, 33, 166, 244}
+
+	for lineNo := 1; buf.Len() < size; lineNo++ {
+		buf.WriteString("\x1b[?25l")
+		buf.WriteString(fmt.Sprintf("\x1b[1;%dr\x1b[%d;1H\n\x1b[r", Rows-1, Rows-1))
+		buf.WriteString(fmt.Sprintf("\x1b[%d;1H\x1b[33m%4d \x1b[m", Rows-1, lineNo))
+
+		for _, w := range bytes.Fields(line(r, Cols-6)) {
+			if r.Intn(3) == 0 {
+				buf.WriteString(fmt.Sprintf("\x1b[38;5;%dm%s\x1b[m ", colors[r.Intn(len(colors))], w))
+			} else {
+				buf.Write(w)
+				buf.WriteByte(' ')
+			}
+		}
+
+		buf.WriteString("\x1b[K")
+		buf.WriteString(fmt.Sprintf("\x1b[%d;1H\x1b[1;7m main.go\x1b[K\x1b[%d;%dH%d,1\x1b[m", Rows, Rows, Cols-18, lineNo))
+		buf.WriteString(fmt.Sprintf("\x1b[%d;6H\x1b[?25h", Rows-1))
+	}
+
+	return buf.Bytes()
+}
+
+// Htop is a full screen process monitor redrawing with lots of colors and
+// cursor movement.
+func Htop(size int) []byte {
+	r := rand.New(rand.NewSource(3))
+
+	var buf bytes.Buffer
+
+	for buf.Len() < size {
+		buf.WriteString("\x1b[?25l\x1b[H")
+
+		for cpu := 0; cpu < 4; cpu++ {
+			used := r.Intn(40)
+			buf.WriteString(fmt.Sprintf("\x1b[%d;3H\x1b[36m%d\x1b[39m\x1b[1m[\x1b[32m", cpu+1, cpu))
+			buf.Write(bytes.Repeat([]byte("|"), used))
+			buf.WriteString(fmt.Sprintf("\x1b[31m%s\x1b[39;49m%*s\x1b[1m%4.1f%%]\x1b[m", "||", 40-used, "", float64(used)*2.5))
+		}
+
+		buf.WriteString("\x1b[6;1H\x1b[30;42m  PID USER      PRI  NI  VIRT   RES   SHR S CPU% MEM%   TIME+  Command\x1b[K\x1b[m")
+
+		for row := 7; row <= Rows; row++ {
+			buf.WriteString(fmt.Sprintf("\x1b[%d;1H", row))
+
+			if row == 7 {
+				buf.WriteString("\x1b[30;46m")
+			}
+
+			buf.WriteString(fmt.Sprintf("%5d \x1b[38;2;200;200;200m%-9s\x1b[m %3d %3d %5dM %5dM %5dM \x1b[1;32mR\x1b[m %4.1f %4.1f %2d:%02d.%02d ",
+				r.Intn(99999), "evan", 20, 0, r.Intn(9000), r.Intn(900), r.Intn(90), r.Float64()*100, r.Float64()*10, r.Intn(60), r.Intn(60), r.Intn(100)))
+			buf.WriteString(fmt.Sprintf("\x1b[36m%s\x1b[m\x1b[K", line(r, 12)))
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// Corpora are the generated outputs by name, each about +size+ bytes.
+func Corpora(size int) map[string][]byte {
+	return map[string][]byte{
+		"cat":  Cat(size),
+		"vim":  Vim(size),
+		"htop": Htop(size),
+	}
+}
@@ -0,0 +1,225 @@
+// Package snapshot is the byte encoding shared by the State and Screen
+// snapshots. A snapshot starts with a magic string and a version, followed
+// by values written one after another without names, so they have to be
+// read back in the same order. Anything added later goes at the end,
+// behind a check of the version.
+package snapshot
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrCorrupt is returned when a snapshot ends early or holds a value
+// that can't be right.
+var ErrCorrupt = errors.New("corrupt snapshot")
+
+// Encoder builds a snapshot. The zero value writes values without a magic
+// or version.
+type Encoder struct {
+	buf []byte
+}
+
+// NewEncoder starts a snapshot with the given magic and version.
+func NewEncoder(magic string, version int) *Encoder {
+	e := &Encoder{}
+	e.buf = append(e.buf, magic...)
+	e.Int(version)
+
+	return e
+}
+
+// Data returns the snapshot so far.
+func (e *Encoder) Data() []byte {
+	return e.buf
+}
+
+func (e *Encoder) Int(v int) {
+	var tmp [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, tmp[:binary.PutVarint(tmp[:], int64(v))]...)
+}
+
+func (e *Encoder) Uint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+func (e *Encoder) Bool(v bool) {
+	if v {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *Encoder) Byte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+// Bytes writes a length and then the bytes.
+func (e *Encoder) Bytes(b []byte) {
+	e.Uint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *Encoder) String(s string) {
+	e.Uint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// Decoder reads a snapshot. The first error is kept, and every read after
+// it returns a zero value, so the error only has to be checked at the end.
+type Decoder struct {
+	// The version the snapshot was written with.
+	Version int
+
+	buf []byte
+	err error
+}
+
+// NewDecoder checks the magic and version at the start of +data+, which
+// must be between 1 and +maxVersion+.
+func NewDecoder(data []byte, magic string, maxVersion int) (*Decoder, error) {
+	if len(data) < len(magic) || string(data[:len(magic)]) != magic {
+		return nil, fmt.Errorf("%w: not a %s snapshot", ErrCorrupt, magic)
+	}
+
+	d := &Decoder{buf: data[len(magic):]}
+
+	d.Version = d.Int()
+
+	switch {
+	case d.err != nil:
+		return nil, d.err
+	case d.Version < 1 || d.Version > maxVersion:
+		return nil, fmt.Errorf("unsupported %s snapshot version: %d", magic, d.Version)
+	}
+
+	return d, nil
+}
+
+// Open returns a Decoder for +data+ without a magic or version, such as
+// a value stored by itself inside a snapshot.
+func Open(data []byte) *Decoder {
+	return &Decoder{buf: data}
+}
+
+// Err returns the first error, including ErrCorrupt if there are bytes
+// left over.
+func (d *Decoder) Err() error {
+	if d.err == nil && len(d.buf) > 0 {
+		return fmt.Errorf("%w: %d bytes left over", ErrCorrupt, len(d.buf))
+	}
+
+	return d.err
+}
+
+// Failed returns true once a read has failed. Unlike Err, it doesn't
+// count what hasn't been read yet.
+func (d *Decoder) Failed() bool {
+	return d.err != nil
+}
+
+// More returns true if there is anything left to read.
+func (d *Decoder) More() bool {
+	return d.err == nil && len(d.buf) > 0
+}
+
+// Fail records an error found in a value, such as one out of range.
+func (d *Decoder) Fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: "+format, append([]interface{}{ErrCorrupt}, args...)...)
+	}
+}
+
+func (d *Decoder) Int() int {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.Fail("bad integer")
+		return 0
+	}
+
+	d.buf = d.buf[n:]
+
+	return int(v)
+}
+
+func (d *Decoder) Uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.Fail("bad integer")
+		return 0
+	}
+
+	d.buf = d.buf[n:]
+
+	return v
+}
+
+func (d *Decoder) Bool() bool {
+	switch d.Byte() {
+	case 0:
+		return false
+	case 1:
+		return true
+	default:
+		d.Fail("bad bool")
+		return false
+	}
+}
+
+func (d *Decoder) Byte() byte {
+	if d.err != nil {
+		return 0
+	}
+
+	if len(d.buf) == 0 {
+		d.Fail("unexpected end")
+		return 0
+	}
+
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+
+	return b
+}
+
+// Len reads the length of a list. Every element takes at least a byte, so
+// a length longer than what's left is corrupt, which keeps a bad snapshot
+// from asking for a huge allocation.
+func (d *Decoder) Len() int {
+	n := d.Uint()
+
+	if n > uint64(len(d.buf)) {
+		d.Fail("length %d past the end", n)
+		return 0
+	}
+
+	return int(n)
+}
+
+func (d *Decoder) Bytes() []byte {
+	n := d.Len()
+	if d.err != nil {
+		return nil
+	}
+
+	b := make([]byte, n)
+	copy(b, d.buf)
+	d.buf = d.buf[n:]
+
+	return b
+}
+
+func (d *Decoder) String() string {
+	return string(d.Bytes())
+}
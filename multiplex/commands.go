@@ -12,8 +12,9 @@ const DefaultCommandBufferSize = 64 * 1024
 
 func (m *Multiplexer) NewCommandBuffer() *CommandBuffer {
 	return &CommandBuffer{
-		m:   m,
-		buf: bytes.NewBuffer(make([]byte, 0, DefaultCommandBufferSize)),
+		m:       m,
+		buf:     bytes.NewBuffer(make([]byte, 0, DefaultCommandBufferSize)),
+		palette: state.DefaultPalette,
 	}
 }
 
@@ -23,26 +24,25 @@ type CommandBuffer struct {
 
 	setPos bool
 	cursor state.Pos
-	pen    *screen.ScreenPen
-}
 
-func (cb *CommandBuffer) samePen(pen *screen.ScreenPen) bool {
-	if cb.pen == nil {
-		if pen == nil {
-			return true
-		}
+	// The pen the terminal is drawing with, nil if it isn't known.
+	pen *screen.ScreenPen
 
-		return false
-	}
+	// The palette of the term's State, which index colors are drawn with.
+	palette state.Palette
+}
 
-	if pen == nil {
-		return false
-	}
+// setPalette changes the palette cells are drawn with to +p+.
+func (cb *CommandBuffer) setPalette(p state.Palette) {
+	cb.palette = p
 
-	return cb.pen.Attrs() == pen.Attrs() &&
-		cb.pen.FGColor() == pen.FGColor() &&
-		cb.pen.BGColor() == pen.BGColor() &&
-		cb.pen.Font() == pen.Font()
+	// The colors of the pen the terminal has may mean something else now
+	cb.pen = nil
+}
+
+// samePen returns true if the terminal is already drawing with +pen+.
+func (cb *CommandBuffer) samePen(pen *screen.ScreenPen) bool {
+	return cb.pen != nil && (cb.pen == pen || cb.pen.PenState == pen.PenState)
 }
 
 func (cb *CommandBuffer) SetCell(p state.Pos, val rune, pen *screen.ScreenPen) error {
@@ -54,18 +54,12 @@ func (cb *CommandBuffer) SetCell(p state.Pos, val rune, pen *screen.ScreenPen) e
 		cb.setPos = true
 	}
 
-	if pen != nil {
-		if !cb.samePen(pen) {
-			switch c := pen.FGColor().(type) {
-			case state.IndexColor:
-				cb.m.ti.TParmf(cb.buf, cb.m.ti.SetFg, c.Index)
-				// cb.m.ti.TPuts(&cb.buf, cb.m.ti.TColor(c.Index, -1))
-			case state.DefaultColor:
-				cb.m.ti.TParmf(cb.buf, cb.m.ti.AttrOff)
-				// cb.m.ti.TPuts(&cb.buf, cb.m.ti.AttrOff)
-			}
-		}
+	if pen == nil {
+		pen = &plainPen
+	}
 
+	if !cb.samePen(pen) {
+		cb.switchPen(cb.pen, pen)
 		cb.pen = pen
 	}
 
@@ -0,0 +1,182 @@
+package multiplex
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/lab47/vterm/parser"
+	"github.com/lab47/vterm/pkg/terminfo"
+	"github.com/lab47/vterm/screen"
+	"github.com/lab47/vterm/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+// penFor returns the pen a cell is drawn with after the SGRs with the
+// parameters in +sgr+.
+func penFor(t *testing.T, sgr ...string) *screen.ScreenPen {
+	scr, err := screen.NewScreen(1, 1, &integrationOutput{})
+	require.NoError(t, err)
+
+	st, err := state.NewState(1, 1, scr)
+	require.NoError(t, err)
+
+	p, err := parser.NewParser(nil, st)
+	require.NoError(t, err)
+
+	for _, params := range sgr {
+		_, err = p.Write([]byte("\x1b[" + params + "m"))
+		require.NoError(t, err)
+	}
+
+	_, err = p.Write([]byte("x"))
+	require.NoError(t, err)
+
+	require.NoError(t, p.Flush())
+
+	return scr.GetCell(0, 0).Pen()
+}
+
+func TestCommandBuffer(t *testing.T) {
+	n := neko.Modern(t)
+
+	// Enough of xterm's terminfo to draw pens with
+	xterm := func() *terminfo.Terminfo {
+		return &terminfo.Terminfo{
+			Colors:        256,
+			SetCursor:     "\x1b[%i%p1%d;%p2%dH",
+			AttrOff:       "\x1b[0m",
+			Bold:          "\x1b[1m",
+			Dim:           "\x1b[2m",
+			Italic:        "\x1b[3m",
+			Underline:     "\x1b[4m",
+			Blink:         "\x1b[5m",
+			Reverse:       "\x1b[7m",
+			Invisible:     "\x1b[8m",
+			StrikeThrough: "\x1b[9m",
+			SetFg:         "\x1b[38;5;%p1%dm",
+			SetBg:         "\x1b[48;5;%p1%dm",
+		}
+	}
+
+	render := func(ti *terminfo.Terminfo, pens ...*screen.ScreenPen) string {
+		var out bytes.Buffer
+
+		m := &Multiplexer{out: &out, ti: ti}
+		cb := m.NewCommandBuffer()
+
+		for i, pen := range pens {
+			require.NoError(t, cb.SetCell(state.Pos{Col: i}, 'x', pen))
+		}
+
+		require.NoError(t, cb.Flush())
+
+		return out.String()
+	}
+
+	n.It("sends only what changes between cells", func(t *testing.T) {
+		bold := penFor(t, "1")
+
+		out := render(xterm(),
+			bold,
+			bold,
+			penFor(t, "1", "3"),
+			penFor(t, "1", "3", "38;5;100"),
+			penFor(t, "1", "3", "38;5;100", "101"),
+			nil,
+			penFor(t, "2", "5", "7", "8", "9"),
+		)
+
+		assert.Equal(t, "\x1b[1;1H"+
+			"\x1b[0m\x1b[1mx"+
+			"x"+
+			"\x1b[3mx"+
+			"\x1b[38;5;100mx"+
+			"\x1b[48;5;9mx"+
+			"\x1b[0mx"+
+			"\x1b[2m\x1b[5m\x1b[7m\x1b[8m\x1b[9mx", out)
+	})
+
+	n.It("downsamples colors the terminal doesn't have", func(t *testing.T) {
+		red := penFor(t, "38;2;255;0;0")
+		gray := penFor(t, "48;2;100;100;100")
+		pink := penFor(t, "38;5;200")
+
+		assert.Equal(t, "\x1b[1;1H\x1b[0m\x1b[38;5;196mx\x1b[0m\x1b[48;5;241mx\x1b[0m\x1b[38;5;200mx",
+			render(xterm(), red, gray, pink))
+
+		ti := xterm()
+		ti.Colors = 16
+
+		assert.Equal(t, "\x1b[1;1H\x1b[0m\x1b[38;5;9mx\x1b[0m\x1b[48;5;8mx\x1b[0m\x1b[38;5;13mx",
+			render(ti, red, gray, pink))
+
+		ti.Colors = 8
+
+		assert.Equal(t, "\x1b[1;1H\x1b[0m\x1b[38;5;1mx\x1b[0m\x1b[48;5;0mx\x1b[0m\x1b[38;5;5mx",
+			render(ti, red, gray, pink))
+
+		ti = xterm()
+		ti.SetFgRGB = "\x1b[38;2;%p1%d;%p2%d;%p3%dm"
+		ti.SetBgRGB = "\x1b[48;2;%p1%d;%p2%d;%p3%dm"
+
+		assert.Equal(t, "\x1b[1;1H\x1b[0m\x1b[38;2;255;0;0mx\x1b[0m\x1b[48;2;100;100;100mx",
+			render(ti, red, gray))
+	})
+
+	n.It("draws underline styles and colors with Smulx and Setulc", func(t *testing.T) {
+		ti := xterm()
+		ti.SetULStyle = "\x1b[4:%p1%dm"
+		ti.SetULColor = "\x1b[58:2::%p1%{65536}%/%d:%p1%{256}%/%{255}%&%d:%p1%{255}%&%dm"
+
+		out := render(ti,
+			penFor(t, "4:3", "58:2::1:2:3"),
+			penFor(t, "4:3", "58:5:9"),
+			penFor(t, "24"),
+		)
+
+		assert.Equal(t, "\x1b[1;1H"+
+			"\x1b[0m\x1b[4:3m\x1b[58:2::1:2:3mx"+
+			"\x1b[58:5:9mx"+
+			"\x1b[4:0m\x1b[59mx", out)
+	})
+
+	n.It("draws colors the program changed as they are now", func(t *testing.T) {
+		pal := state.DefaultPalette
+		pal.Colors[1] = state.RGBColor{Red: 1, Green: 2, Blue: 3}
+		pal.Colors[200] = state.DefaultPalette.Colors[9]
+
+		ti := xterm()
+		ti.Colors = 16
+		ti.SetFgRGB = "\x1b[38;2;%p1%d;%p2%d;%p3%dm"
+
+		var out bytes.Buffer
+
+		m := &Multiplexer{out: &out, ti: ti}
+		cb := m.NewCommandBuffer()
+		cb.setPalette(pal)
+
+		for i, pen := range []*screen.ScreenPen{penFor(t, "31"), penFor(t, "32"), penFor(t, "48;5;200")} {
+			require.NoError(t, cb.SetCell(state.Pos{Col: i}, 'x', pen))
+		}
+
+		require.NoError(t, cb.Flush())
+
+		assert.Equal(t, "\x1b[1;1H"+
+			"\x1b[0m\x1b[38;2;1;2;3mx"+
+			"\x1b[38;5;2mx"+
+			"\x1b[0m\x1b[48;5;9mx", out.String())
+	})
+
+	n.It("falls back on a plain underline", func(t *testing.T) {
+		out := render(xterm(),
+			penFor(t, "4:3", "58:5:9"),
+			penFor(t, "24"),
+		)
+
+		assert.Equal(t, "\x1b[1;1H\x1b[0m\x1b[4mx\x1b[0mx", out)
+	})
+
+	n.Meow()
+}
@@ -3,4 +3,23 @@ package multiplex
 type Config struct {
 	Shell []string
 	Env   []string
+
+	// Notify says if the notifications the terms post are passed on to
+	// the host terminal.
+	Notify NotifyMode
 }
+
+// NotifyMode says when notifications are passed on to the host terminal.
+type NotifyMode int
+
+const (
+	// NotifyAuto passes them on if the host terminal is one known to show
+	// OSC 9 notifications. Others may print them as text, or ignore them.
+	NotifyAuto NotifyMode = iota
+
+	// NotifyAlways passes them on with OSC 9 whatever the host terminal.
+	NotifyAlways
+
+	// NotifyNever drops them.
+	NotifyNever
+)
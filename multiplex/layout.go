@@ -2,6 +2,7 @@ package multiplex
 
 import (
 	"io"
+	"sync"
 
 	"github.com/lab47/vterm/state"
 )
@@ -32,6 +33,8 @@ type Layout struct {
 
 	top *LayoutRow
 
+	// Guards focusTerm, which the terms check from their own goroutines
+	focusMu    sync.Mutex
 	focusTerm  *Term
 	focusInput io.Writer
 
@@ -72,6 +75,27 @@ func (l *Layout) Start() error {
 	return nil
 }
 
+// setFocus makes +t+ the term that receives input, writing it to +w+.
+func (l *Layout) setFocus(t *Term, w io.Writer) {
+	l.focusMu.Lock()
+	l.focusTerm = t
+	l.focusMu.Unlock()
+
+	l.focusInput = w
+
+	t.clearAlerts()
+
+	l.m.setCursorStyle(t.CursorStyle())
+}
+
+// focused returns the term that receives input.
+func (l *Layout) focused() *Term {
+	l.focusMu.Lock()
+	defer l.focusMu.Unlock()
+
+	return l.focusTerm
+}
+
 func (l *Layout) Write(b []byte) (int, error) {
 	return l.focusInput.Write(b)
 }
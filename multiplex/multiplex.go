@@ -4,6 +4,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"strings"
 	"sync"
 	"time"
 	"unicode/utf8"
@@ -38,7 +39,11 @@ type Multiplexer struct {
 
 	layout *Layout
 
-	curPos state.Pos
+	curPos   state.Pos
+	curStyle state.CursorStyle
+
+	// Set when notifications are passed on to the host, see Config.Notify
+	notifyHost bool
 
 	inputData time.Time
 }
@@ -67,6 +72,13 @@ func (m *Multiplexer) Init() error {
 
 	m.ti = ti
 
+	switch m.Config.Notify {
+	case NotifyAuto:
+		m.notifyHost = hostNotifies(os.Getenv("TERM"), os.Getenv("TERM_PROGRAM"))
+	case NotifyAlways:
+		m.notifyHost = true
+	}
+
 	rows, cols, err := pty.Getsize(os.Stdin)
 	if err != nil {
 		return err
@@ -75,6 +87,8 @@ func (m *Multiplexer) Init() error {
 	m.rows = rows
 	m.cols = cols
 
+	m.curStyle = state.CursorStyle{Visible: true}
+
 	m.buf = make([]byte, 32)
 
 	st, err := terminal.MakeRaw(m.resetFd)
@@ -164,7 +178,121 @@ func (m *Multiplexer) moveCursor(p state.Pos) error {
 	return nil
 }
 
+// setCursorStyle updates the host's cursor to match +style+, using the
+// Ss/Se terminfo extensions for the shape when the host supports them.
+func (m *Multiplexer) setCursorStyle(style state.CursorStyle) error {
+	m.outMu.Lock()
+	defer m.outMu.Unlock()
+
+	if style.Visible != m.curStyle.Visible {
+		if style.Visible {
+			m.ti.TPuts(m.out, m.ti.ShowCursor)
+		} else {
+			m.ti.TPuts(m.out, m.ti.HideCursor)
+		}
+	}
+
+	if (style.Shape != m.curStyle.Shape || style.Blink != m.curStyle.Blink) &&
+		m.ti.SetCursorStyle != "" {
+		if style.Shape == state.CursorShapeDefault {
+			m.ti.TPuts(m.out, m.ti.ResetCursor)
+		} else {
+			m.ti.TParmf(m.out, m.ti.SetCursorStyle, decscusr(style))
+		}
+	}
+
+	m.curStyle.Shape = style.Shape
+	m.curStyle.Blink = style.Blink
+	m.curStyle.Visible = style.Visible
+
+	return nil
+}
+
+// decscusr returns the DECSCUSR parameter that selects +style+
+func decscusr(style state.CursorStyle) int {
+	var ps int
+
+	switch style.Shape {
+	case state.CursorShapeBlock:
+		ps = 1
+	case state.CursorShapeUnderline:
+		ps = 3
+	case state.CursorShapeBar:
+		ps = 5
+	default:
+		return 0
+	}
+
+	// The steady variant follows each blinking one
+	if !style.Blink {
+		ps++
+	}
+
+	return ps
+}
+
+func (m *Multiplexer) bell() error {
+	m.outMu.Lock()
+	defer m.outMu.Unlock()
+
+	m.ti.TPuts(m.out, m.ti.Bell)
+
+	return nil
+}
+
+// hostNotifies returns true if the terminal identified by +term+ and
+// +program+, the TERM and TERM_PROGRAM it sets, shows OSC 9 notifications.
+// There's no terminfo capability for it, nor a way to ask.
+func hostNotifies(term, program string) bool {
+	switch program {
+	case "iTerm.app", "WezTerm", "ghostty":
+		return true
+	}
+
+	switch term {
+	case "xterm-kitty", "xterm-ghostty", "wezterm":
+		return true
+	}
+
+	return false
+}
+
+// notify forwards a notification from one of the terms to the host
+// terminal using OSC 9, which is the most widely understood form, if
+// the host shows them.
+func (m *Multiplexer) notify(n state.Notification) error {
+	if !m.notifyHost {
+		return nil
+	}
+
+	msg := n.Body
+	if n.Title != "" {
+		if msg == "" {
+			msg = n.Title
+		} else {
+			msg = n.Title + ": " + msg
+		}
+	}
+
+	// Don't let a term smuggle escape sequences out to the host.
+	msg = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || (r >= 0x80 && r < 0xa0) {
+			return -1
+		}
+
+		return r
+	}, msg)
+
+	m.outMu.Lock()
+	defer m.outMu.Unlock()
+
+	_, err := io.WriteString(m.out, "\x1b]9;"+msg+"\x1b\\")
+	return err
+}
+
 func (m *Multiplexer) Cleanup() {
+	m.setCursorStyle(state.CursorStyle{Visible: true})
+
 	m.ti.TPuts(m.out, m.ti.TParm(mouseMode, 0))
 	m.ti.TPuts(m.out, m.ti.AttrOff)
 	// m.ti.TPuts(m.out, m.ti.Clear)
@@ -0,0 +1,55 @@
+package multiplex
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/lab47/vterm/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestNotify(t *testing.T) {
+	n := neko.Modern(t)
+
+	n.It("passes notifications on to hosts that show them", func(t *testing.T) {
+		var out bytes.Buffer
+
+		m := &Multiplexer{out: &out}
+
+		require.NoError(t, m.notify(state.Notification{Title: "make", Body: "done"}))
+		assert.Equal(t, "", out.String())
+
+		m.notifyHost = true
+
+		require.NoError(t, m.notify(state.Notification{Title: "make", Body: "done\x1b[2J"}))
+		assert.Equal(t, "\x1b]9;make: done[2J\x1b\\", out.String())
+	})
+
+	n.It("knows which hosts show OSC 9 notifications", func(t *testing.T) {
+		assert.True(t, hostNotifies("xterm-256color", "iTerm.app"))
+		assert.True(t, hostNotifies("xterm-kitty", ""))
+		assert.False(t, hostNotifies("xterm-256color", ""))
+		assert.False(t, hostNotifies("screen", "Apple_Terminal"))
+	})
+
+	n.Meow()
+}
+
+func TestOperations(t *testing.T) {
+	n := neko.Modern(t)
+
+	n.It("only treats this machine's names as local", func(t *testing.T) {
+		name, err := os.Hostname()
+		require.NoError(t, err)
+
+		assert.True(t, isLocalHost(""))
+		assert.True(t, isLocalHost("localhost"))
+		assert.True(t, isLocalHost(name))
+		assert.False(t, isLocalHost(name+".elsewhere.example"))
+	})
+
+	n.Meow()
+}
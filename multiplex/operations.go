@@ -3,6 +3,7 @@ package multiplex
 import (
 	"os"
 	"os/exec"
+	"strings"
 )
 
 type Operations struct {
@@ -14,9 +15,33 @@ func (o *Operations) spawnTerm() (*Term, error) {
 	cmd := exec.Command(shell[0], shell[1:]...)
 	cmd.Env = append(os.Environ(), o.l.m.Config.Env...)
 
+	// Start new terms where the user currently is rather than where the
+	// multiplexer was started. The reported directory might be on another
+	// host (eg. inside ssh), so only use it if it's on this one and exists.
+	if t := o.l.focused(); t != nil {
+		if host, dir := t.WorkingDirectory(); dir != "" && isLocalHost(host) {
+			if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+				cmd.Dir = dir
+			}
+		}
+	}
+
 	return NewTerm(o.l.m, cmd)
 }
 
+// isLocalHost returns true if +host+, from an OSC 7 working directory, is
+// this machine. Shells leave it empty or put localhost when they don't
+// know better.
+func isLocalHost(host string) bool {
+	if host == "" || strings.EqualFold(host, "localhost") {
+		return true
+	}
+
+	name, err := os.Hostname()
+
+	return err == nil && strings.EqualFold(host, name)
+}
+
 func (o *Operations) Split() error {
 	cl := o.l.currentRow
 	term := cl.Term
@@ -62,8 +87,7 @@ func (o *Operations) Split() error {
 		return err
 	}
 
-	o.l.focusTerm = term
-	o.l.focusInput = w
+	o.l.setFocus(term, w)
 
 	o.l.currentRow = row2
 
@@ -115,8 +139,7 @@ func (o *Operations) SplitHoriz() error {
 		return err
 	}
 
-	o.l.focusTerm = term
-	o.l.focusInput = w
+	o.l.setFocus(term, w)
 
 	o.l.currentRow = row2
 
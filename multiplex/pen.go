@@ -0,0 +1,213 @@
+package multiplex
+
+import (
+	"github.com/lab47/vterm/screen"
+	"github.com/lab47/vterm/state"
+)
+
+// plainPen is drawn with when a cell has no pen: no attributes and the
+// default colors.
+var plainPen screen.ScreenPen
+
+// penAttrs are the attributes there's a terminfo capability to draw. The
+// others, such as fonts, framing and overlines, aren't drawn at all.
+const penAttrs = state.PenBold | state.PenFaint | state.PenItalic | state.PenBlink |
+	state.PenReverse | state.PenConceal | state.PenStrikeThrough
+
+// switchPen moves the terminal from drawing with +from+ to drawing with
+// +to+, sending only what changes between them. terminfo can't turn off
+// attributes one at a time or go back to the default colors though, so
+// losing any of those starts over from AttrOff. A nil +from+ means what
+// the terminal is drawing with isn't known.
+func (cb *CommandBuffer) switchPen(from, to *screen.ScreenPen) {
+	ti := cb.m.ti
+
+	if from == nil || cb.needsReset(from, to) {
+		ti.TPuts(cb.buf, ti.AttrOff)
+		from = &plainPen
+	}
+
+	add := to.Attrs() &^ from.Attrs()
+
+	if add&state.PenBold != 0 {
+		ti.TPuts(cb.buf, ti.Bold)
+	}
+
+	if add&state.PenFaint != 0 {
+		ti.TPuts(cb.buf, ti.Dim)
+	}
+
+	if add&state.PenItalic != 0 {
+		ti.TPuts(cb.buf, ti.Italic)
+	}
+
+	if add&state.PenBlink != 0 {
+		ti.TPuts(cb.buf, ti.Blink)
+	}
+
+	if add&state.PenReverse != 0 {
+		ti.TPuts(cb.buf, ti.Reverse)
+	}
+
+	if add&state.PenConceal != 0 {
+		ti.TPuts(cb.buf, ti.Invisible)
+	}
+
+	if add&state.PenStrikeThrough != 0 {
+		ti.TPuts(cb.buf, ti.StrikeThrough)
+	}
+
+	if from.Underline() != to.Underline() {
+		switch {
+		case ti.SetULStyle != "":
+			ti.TParmf(cb.buf, ti.SetULStyle, int(to.Underline()))
+		case from.Underline() == state.UnderlineNone:
+			ti.TPuts(cb.buf, ti.Underline)
+		}
+	}
+
+	if !sameColor(from.FGColor(), to.FGColor()) {
+		cb.setColor(to.FGColor(), false)
+	}
+
+	if !sameColor(from.BGColor(), to.BGColor()) {
+		cb.setColor(to.BGColor(), true)
+	}
+
+	if ti.SetULColor != "" && !sameColor(from.UnderlineColor(), to.UnderlineColor()) {
+		cb.setUnderlineColor(to.UnderlineColor())
+	}
+}
+
+// needsReset returns true if going from +from+ to +to+ means turning off
+// something that can only be turned off with AttrOff.
+func (cb *CommandBuffer) needsReset(from, to *screen.ScreenPen) bool {
+	if from.Attrs()&penAttrs&^to.Attrs() != 0 {
+		return true
+	}
+
+	if cb.m.ti.SetULStyle == "" &&
+		from.Underline() != state.UnderlineNone && to.Underline() == state.UnderlineNone {
+		return true
+	}
+
+	return !isDefault(from.FGColor()) && isDefault(to.FGColor()) ||
+		!isDefault(from.BGColor()) && isDefault(to.BGColor())
+}
+
+// setColor sets the foreground, or background if +bg+ is true, to +c+.
+// Colors the terminal doesn't have are swapped for the nearest one it does.
+func (cb *CommandBuffer) setColor(c state.Color, bg bool) {
+	ti := cb.m.ti
+
+	switch c := c.(type) {
+	case state.IndexColor:
+		// The terminal has its own palette, so colors the program changed
+		// are sent as the colors they are now.
+		if rgb, ok := cb.changedColor(c); ok {
+			cb.setColor(rgb, bg)
+			return
+		}
+
+		cb.setIndexColor(c.Index, bg)
+	case state.RGBColor:
+		rgb := ti.SetFgRGB
+		if bg {
+			rgb = ti.SetBgRGB
+		}
+
+		// Without RGB, the nearest color is looked up in the palette the
+		// terminal is assumed to have.
+		switch {
+		case rgb != "":
+			ti.TParmf(cb.buf, rgb, int(c.Red), int(c.Green), int(c.Blue))
+		case ti.Colors >= 256:
+			cb.setIndexColor(state.DefaultPalette.Nearest256(c).Index, bg)
+		default:
+			cb.setIndexColor(state.DefaultPalette.Nearest16(c).Index, bg)
+		}
+	}
+}
+
+// setIndexColor sets the foreground or background to the palette color
+// +idx+, or the closest the terminal has to it.
+func (cb *CommandBuffer) setIndexColor(idx int, bg bool) {
+	ti := cb.m.ti
+
+	if idx < 0 || idx > 255 {
+		return
+	}
+
+	if idx >= ti.Colors && idx >= 16 {
+		idx = state.DefaultPalette.Nearest16(cb.palette.Colors[idx]).Index
+	}
+
+	// Terminals with 8 colors draw the bright ones as the normal ones,
+	// as TColor does.
+	if idx >= ti.Colors && ti.Colors == 8 {
+		idx -= 8
+	}
+
+	if idx >= ti.Colors {
+		return
+	}
+
+	if bg {
+		ti.TParmf(cb.buf, ti.SetBg, idx)
+	} else {
+		ti.TParmf(cb.buf, ti.SetFg, idx)
+	}
+}
+
+// changedColor returns the color +c+ is in the term's palette, if the
+// program changed it from the one the terminal is assumed to have.
+func (cb *CommandBuffer) changedColor(c state.IndexColor) (state.RGBColor, bool) {
+	if c.Index < 0 || c.Index > 255 {
+		return state.RGBColor{}, false
+	}
+
+	rgb := cb.palette.Colors[c.Index]
+
+	return rgb, rgb != state.DefaultPalette.Colors[c.Index]
+}
+
+// setUnderlineColor sets the color of underlines with Setulc.
+func (cb *CommandBuffer) setUnderlineColor(c state.Color) {
+	ti := cb.m.ti
+
+	switch c := c.(type) {
+	case state.RGBColor:
+		// Setulc takes the color packed into one number, 0xRRGGBB
+		ti.TParmf(cb.buf, ti.SetULColor, int(c.Red)<<16|int(c.Green)<<8|int(c.Blue))
+	case state.IndexColor:
+		if rgb, ok := cb.changedColor(c); ok {
+			cb.setUnderlineColor(rgb)
+			return
+		}
+
+		// There's no capability for an indexed underline color, but
+		// terminals with Setulc understand the SGR for it.
+		ti.TParmf(cb.buf, "\x1b[58:5:%p1%dm", c.Index)
+	default:
+		cb.buf.WriteString("\x1b[59m")
+	}
+}
+
+func isDefault(c state.Color) bool {
+	switch c.(type) {
+	case nil, state.DefaultColor:
+		return true
+	default:
+		return false
+	}
+}
+
+// sameColor returns true if +a+ and +b+ are drawn the same. A pen that
+// hasn't had its colors set has nil ones, which are the defaults.
+func sameColor(a, b state.Color) bool {
+	if isDefault(a) || isDefault(b) {
+		return isDefault(a) && isDefault(b)
+	}
+
+	return a == b
+}
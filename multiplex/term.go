@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -38,7 +39,16 @@ type Term struct {
 	damageLock    sync.Mutex
 	pendingDamage []state.Rect
 
-	cursorPos state.Pos
+	cursorPos   state.Pos
+	cursorStyle state.CursorStyle
+
+	// The directory last reported by the program running in the term
+	cwd state.WorkingDirectoryChange
+
+	// Set when the term changes or asks for attention while it's not the
+	// focused one, cleared when it gets focus.
+	activity  bool
+	attention bool
 
 	used []int
 
@@ -54,6 +64,8 @@ func NewTerm(m *Multiplexer, cmd *exec.Cmd) (*Term, error) {
 		cmdbuf:    m.NewCommandBuffer(),
 		cmd:       cmd,
 		newDamage: make(chan state.Rect),
+
+		cursorStyle: state.CursorStyle{Visible: true},
 	}
 
 	return widget, nil
@@ -178,6 +190,7 @@ func (w *Term) begin() error {
 	}
 
 	st.Id = fmt.Sprintf("sub%d", w.id)
+	w.cmdbuf.setPalette(st.Palette())
 
 	parser, err := parser.NewParser(w.f, st)
 	if err != nil {
@@ -243,6 +256,10 @@ func (w *Term) DamageDone(r state.Rect, cr screen.CellReader) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	if !w.focused() {
+		w.activity = true
+	}
+
 	// w.newDamage <- r
 
 	// return nil
@@ -283,6 +300,10 @@ func (w *Term) applyDamage(r state.Rect, cr screen.CellReader) error {
 		// used := w.used[row]
 		max := -1
 
+		// We can't ask the host for double size text inside a pane, so
+		// spread the characters out instead.
+		wide := cr.LineSize(row).DoubleWidth()
+
 		for col := r.Start.Col; col <= r.End.Col; col++ {
 			cell := cr.GetCell(row, col)
 			if cell == nil {
@@ -304,6 +325,18 @@ func (w *Term) applyDamage(r state.Rect, cr screen.CellReader) error {
 			abRow := row + w.roffset
 			abCol := col + w.coffset
 
+			if wide {
+				if col*2 >= w.cols {
+					continue
+				}
+
+				abCol = col*2 + w.coffset
+
+				if col*2+1 < w.cols {
+					w.cmdbuf.SetCell(state.Pos{Row: abRow, Col: abCol + 1}, ' ', cell.Pen())
+				}
+			}
+
 			/*
 				if abRow == 0 && abCol > 40 && abCol < 46 {
 					s := string(val)
@@ -335,9 +368,76 @@ func (w *Term) moveCursor(p state.Pos) error {
 }
 
 func (w *Term) SetTermProp(attr state.TermAttr, val interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	switch attr {
+	case state.TermAttrOSC:
+		osc, _ := val.(string)
+		w.setColors(osc)
+		return nil
+	case state.TermAttrWorkingDirectory:
+		w.cwd, _ = val.(state.WorkingDirectoryChange)
+	case state.TermAttrCursorShape:
+		w.cursorStyle.Shape, _ = val.(state.CursorShape)
+	case state.TermAttrBlink:
+		w.cursorStyle.Blink, _ = val.(bool)
+	case state.TermAttrVisible:
+		w.cursorStyle.Visible, _ = val.(bool)
+	default:
+		return nil
+	}
+
+	if attr != state.TermAttrWorkingDirectory && w.focused() {
+		return w.m.setCursorStyle(w.cursorStyle)
+	}
+
 	return nil
 }
 
+// setColors applies an OSC 4 or 104 from the program to the palette of
+// its State, which the term's cells are drawn with.
+func (w *Term) setColors(osc string) {
+	cmd, data := osc, ""
+	if i := strings.IndexByte(osc, ';'); i != -1 {
+		cmd, data = osc[:i], osc[i+1:]
+	}
+
+	p := w.state.Palette()
+
+	switch cmd {
+	case "4":
+		if !p.SetColors(data) {
+			return
+		}
+	case "104":
+		p.ResetColors(&state.DefaultPalette, data)
+	default:
+		return
+	}
+
+	w.state.SetPalette(p)
+	w.cmdbuf.setPalette(p)
+}
+
+// CursorStyle returns how the program running in the term wants the
+// cursor drawn.
+func (w *Term) CursorStyle() state.CursorStyle {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.cursorStyle
+}
+
+// WorkingDirectory returns the host and directory the program running in
+// the term last reported via OSC 7. Both are empty if it never did.
+func (w *Term) WorkingDirectory() (host, path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.cwd.Host, w.cwd.Path
+}
+
 func (w *Term) Output(data []byte) error {
 	_, err := w.f.Write(data)
 	return err
@@ -346,3 +446,54 @@ func (w *Term) Output(data []byte) error {
 func (w *Term) StringEvent(kind string, data []byte) error {
 	return nil
 }
+
+// focused returns true if the term is the one currently receiving input.
+func (w *Term) focused() bool {
+	return w.m.layout == nil || w.m.layout.focused() == w
+}
+
+func (w *Term) Bell() error {
+	w.mu.Lock()
+	if !w.focused() {
+		w.attention = true
+	}
+	w.mu.Unlock()
+
+	return w.m.bell()
+}
+
+func (w *Term) Notify(n state.Notification) error {
+	w.mu.Lock()
+	if !w.focused() {
+		w.attention = true
+	}
+	w.mu.Unlock()
+
+	return w.m.notify(n)
+}
+
+// Activity reports whether the term has updated its screen since it
+// last had focus.
+func (w *Term) Activity() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.activity
+}
+
+// Attention reports whether the term has rung the bell or posted a
+// notification since it last had focus.
+func (w *Term) Attention() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.attention
+}
+
+func (w *Term) clearAlerts() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.activity = false
+	w.attention = false
+}
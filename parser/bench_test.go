@@ -0,0 +1,56 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/lab47/vterm/internal/benchdata"
+)
+
+// recycleEvents hands pooled events back like State does, so the
+// benchmarks measure the parser's steady state.
+type recycleEvents struct{}
+
+func (recycleEvents) HandleEvent(ev Event) error {
+	switch ev := ev.(type) {
+	case *TextEvent:
+		ev.Recycle()
+	case *CSIEvent:
+		ev.Recycle()
+	}
+
+	return nil
+}
+
+func BenchmarkParser(b *testing.B) {
+	corpora := benchdata.Corpora(1 << 20)
+
+	for _, name := range []string{"cat", "vim", "htop"} {
+		data := corpora[name]
+
+		b.Run(name, func(b *testing.B) {
+			pr, err := NewParser(nil, recycleEvents{})
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				// Written in chunks like Drive reads them
+				for start := 0; start < len(data); start += 4096 {
+					end := start + 4096
+					if end > len(data) {
+						end = len(data)
+					}
+
+					_, err := pr.Write(data[start:end])
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
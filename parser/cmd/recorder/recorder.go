@@ -1,74 +1,108 @@
+// Command recorder runs a command in a pty and records a trace of the
+// session, which can be replayed with the trace package.
+//
+//	recorder <trace file> <command> [args...]
 package main
 
 import (
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
-	"sync"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/creack/pty"
-	"github.com/davecgh/go-spew/spew"
-	"github.com/lab47/vterm/parser"
+	"github.com/lab47/vterm/trace"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
-type recordHandler struct {
-	f *os.File
+// inputRecorder records the bytes copied to the program as input.
+type inputRecorder struct {
+	tw *trace.Writer
 }
 
-func (r *recordHandler) HandleEvent(ev parser.Event) error {
-	spew.Fdump(r.f, ev)
-	return nil
+func (r *inputRecorder) Write(p []byte) (int, error) {
+	err := r.tw.Input(p)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
 }
 
 func record() error {
+	if len(os.Args) < 3 {
+		return fmt.Errorf("usage: recorder <trace file> <command> [args...]")
+	}
+
 	f, err := os.Create(os.Args[1])
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	defer f.Close()
 
-	r, w := io.Pipe()
+	fd := int(os.Stdout.Fd())
 
-	parser, err := parser.NewParser(r, &recordHandler{f})
+	cols, rows, err := terminal.GetSize(fd)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	var wg sync.WaitGroup
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		parser.Drive()
-	}()
-
-	defer wg.Wait()
+	tw, err := trace.NewWriter(f, trace.Header{
+		Rows:    rows,
+		Cols:    cols,
+		Term:    os.Getenv("TERM"),
+		Command: strings.Join(os.Args[2:], " "),
+	})
+	if err != nil {
+		return err
+	}
 
-	defer w.Close()
+	cmd := exec.Command(os.Args[2], os.Args[3:]...)
 
-	ts, err := terminal.MakeRaw(int(os.Stdout.Fd()))
+	out, err := pty.StartWithSize(cmd, &pty.Winsize{Rows: uint16(rows), Cols: uint16(cols)})
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	defer terminal.Restore(int(os.Stdout.Fd()), ts)
+	defer out.Close()
 
-	cmd := exec.Command(os.Args[2], os.Args[3:]...)
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+
+	go func() {
+		for range winch {
+			cols, rows, err := terminal.GetSize(fd)
+			if err != nil {
+				continue
+			}
+
+			pty.Setsize(out, &pty.Winsize{Rows: uint16(rows), Cols: uint16(cols)})
+			tw.Resize(rows, cols)
+		}
+	}()
 
-	out, err := pty.Start(cmd)
+	ts, err := terminal.MakeRaw(fd)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	go io.Copy(out, os.Stdin)
-	io.Copy(io.MultiWriter(os.Stdout, w), out)
+	defer terminal.Restore(fd, ts)
+
+	go io.Copy(out, io.TeeReader(os.Stdin, &inputRecorder{tw}))
+	io.Copy(io.MultiWriter(os.Stdout, tw), out)
 
 	return cmd.Wait()
 }
 
 func main() {
-	record()
+	err := record()
+	if err != nil {
+		log.Fatal(err)
+	}
 }
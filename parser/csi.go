@@ -16,53 +16,56 @@ func INTERMED(i, b byte) CSICommand {
 }
 
 var (
-	MOUSE    CSICommand = 0x3c
-	ICH      CSICommand = 0x40
-	CUU      CSICommand = 0x41
-	CUD      CSICommand = 0x42
-	CUF      CSICommand = 0x43
-	CUB      CSICommand = 0x44
-	CNL      CSICommand = 0x45
-	CPL      CSICommand = 0x46
-	CHA      CSICommand = 0x47
-	CUP      CSICommand = 0x48
-	CHT      CSICommand = 0x49
-	ED       CSICommand = 0x4a
-	DECSED   CSICommand = LEADER('?', 0x4a)
-	EL       CSICommand = 0x4b
-	DECSEL   CSICommand = LEADER('?', 0x4b)
-	IL       CSICommand = 0x4c
-	DL       CSICommand = 0x4d
-	DCH      CSICommand = 0x50
-	SU       CSICommand = 0x53
-	SD       CSICommand = 0x54
-	ECH      CSICommand = 0x58
-	CBT      CSICommand = 0x5a
-	HPA      CSICommand = 0x60
-	HPR      CSICommand = 0x61
-	REP      CSICommand = 0x62
-	DA       CSICommand = 0x63
-	DA_LT    CSICommand = LEADER('>', 0x63)
-	VPA      CSICommand = 0x64
-	VPR      CSICommand = 0x65
-	HVP      CSICommand = 0x66
-	TBC      CSICommand = 0x67
-	SM       CSICommand = 0x68
-	SM_Q     CSICommand = LEADER('?', 0x68)
-	HPB      CSICommand = 0x6a
-	VPB      CSICommand = 0x6b
-	RM       CSICommand = 0x6c
-	RM_Q     CSICommand = LEADER('?', 0x6c)
-	SGR      CSICommand = 0x6d
-	DSR      CSICommand = 0x6e
-	DSR_Q    CSICommand = LEADER('?', 0x6e)
-	DECSTR   CSICommand = LEADER('!', 0x70)
-	DECSCUSR CSICommand = INTERMED(' ', 0x71)
-	DECSCA   CSICommand = INTERMED('"', 0x71)
-	DECSTBM  CSICommand = 0x72
-	DECSLRM  CSICommand = 0x73
-	DECIC    CSICommand = INTERMED('\'', 0x7D)
-	DECDC    CSICommand = INTERMED('\'', 0x7E)
+	MOUSE     CSICommand = 0x3c
+	ICH       CSICommand = 0x40
+	CUU       CSICommand = 0x41
+	CUD       CSICommand = 0x42
+	CUF       CSICommand = 0x43
+	CUB       CSICommand = 0x44
+	CNL       CSICommand = 0x45
+	CPL       CSICommand = 0x46
+	CHA       CSICommand = 0x47
+	CUP       CSICommand = 0x48
+	CHT       CSICommand = 0x49
+	ED        CSICommand = 0x4a
+	DECSED    CSICommand = LEADER('?', 0x4a)
+	EL        CSICommand = 0x4b
+	DECSEL    CSICommand = LEADER('?', 0x4b)
+	IL        CSICommand = 0x4c
+	DL        CSICommand = 0x4d
+	DCH       CSICommand = 0x50
+	SU        CSICommand = 0x53
+	SD        CSICommand = 0x54
+	ECH       CSICommand = 0x58
+	CBT       CSICommand = 0x5a
+	HPA       CSICommand = 0x60
+	HPR       CSICommand = 0x61
+	REP       CSICommand = 0x62
+	DA        CSICommand = 0x63
+	DA_LT     CSICommand = LEADER('>', 0x63)
+	DA_EQ     CSICommand = LEADER('=', 0x63)
+	VPA       CSICommand = 0x64
+	VPR       CSICommand = 0x65
+	HVP       CSICommand = 0x66
+	TBC       CSICommand = 0x67
+	SM        CSICommand = 0x68
+	SM_Q      CSICommand = LEADER('?', 0x68)
+	HPB       CSICommand = 0x6a
+	VPB       CSICommand = 0x6b
+	RM        CSICommand = 0x6c
+	RM_Q      CSICommand = LEADER('?', 0x6c)
+	SGR       CSICommand = 0x6d
+	DSR       CSICommand = 0x6e
+	DSR_Q     CSICommand = LEADER('?', 0x6e)
+	DECSTR    CSICommand = INTERMED('!', 0x70)
+	XTVERSION CSICommand = LEADER('>', 0x71)
+	DECSCUSR  CSICommand = INTERMED(' ', 0x71)
+	DECSCA    CSICommand = INTERMED('"', 0x71)
+	DECSTBM   CSICommand = 0x72
+	DECSLRM   CSICommand = 0x73
+	XTWINOPS  CSICommand = 0x74
+	DECIC     CSICommand = INTERMED('\'', 0x7D)
+	DECDC     CSICommand = INTERMED('\'', 0x7E)
 )
 
 func (c CSICommand) String() string {
@@ -101,6 +104,7 @@ var CSICodes = map[CSICommand]CSICode{
 	0x62:                 {"REP", "ECMA-48 8.3.103"},
 	0x63:                 {"DA", "ECMA-48 8.3.24"},
 	LEADER('>', 0x63):    {"DA-LT", "DEC secondary Device Attributes"},
+	LEADER('=', 0x63):    {"DA-EQ", "DEC tertiary Device Attributes"},
 	0x64:                 {"VPA", "ECMA-48 8.3.158"},
 	0x65:                 {"VPR", "ECMA-48 8.3.160"},
 	0x66:                 {"HVP", "ECMA-48 8.3.63"},
@@ -114,11 +118,13 @@ var CSICodes = map[CSICommand]CSICode{
 	0x6d:                 {"SGR", "ECMA-48 8.3.117"},
 	0x6e:                 {"DSR", "ECMA-48 8.3.35"},
 	LEADER('?', 0x6e):    {"DSR-Q", "DECDSR"},
-	LEADER('!', 0x70):    {"DECSTR", "DEC soft terminal reset"},
+	INTERMED('!', 0x70):  {"DECSTR", "DEC soft terminal reset"},
+	LEADER('>', 0x71):    {"XTVERSION", "XTerm report version"},
 	INTERMED(' ', 0x71):  {"DECSCUSR", "DEC set cursor shape"},
 	INTERMED('"', 0x71):  {"DECSCA", "DEC select character protection attribute"},
 	0x72:                 {"DECSTBM", "DEC custom"},
 	0x73:                 {"DECSLRM", "DEC custom"},
+	0x74:                 {"XTWINOPS", "XTerm window operations"},
 	INTERMED('\'', 0x7D): {"DECIC", "DEC Scroll Screen Up"},
 	INTERMED('\'', 0x7E): {"DECDC", "DEC Scroll Screen Down"},
 }
@@ -0,0 +1,31 @@
+package parser
+
+// DCSCommand identifies a device control string by its leader,
+// intermediates and final byte, encoded the same way as CSICommand.
+type DCSCommand int
+
+var (
+	DECUDK    DCSCommand = 0x7c
+	DECSIXEL  DCSCommand = 0x71
+	TMUX      DCSCommand = 0x74
+	DECRQSS   DCSCommand = DCSCommand(INTERMED('$', 0x71))
+	XTGETTCAP DCSCommand = DCSCommand(INTERMED('+', 0x71))
+	XTSETTCAP DCSCommand = DCSCommand(INTERMED('+', 0x70))
+)
+
+func (c DCSCommand) String() string {
+	if code, ok := DCSCodes[c]; ok {
+		return code.Name
+	}
+
+	return "UNKNOWN"
+}
+
+var DCSCodes = map[DCSCommand]CSICode{
+	DECUDK:    {"DECUDK", "DEC User Defined Keys"},
+	DECSIXEL:  {"DECSIXEL", "DEC Sixel Graphics"},
+	TMUX:      {"TMUX", "tmux passthrough, the payload starts with mux;"},
+	DECRQSS:   {"DECRQSS", "DEC Request Selection or Setting"},
+	XTGETTCAP: {"XTGETTCAP", "XTerm Request Termcap/Terminfo String"},
+	XTSETTCAP: {"XTSETTCAP", "XTerm Set Termcap/Terminfo Data"},
+}
@@ -0,0 +1,187 @@
+package parser
+
+import "unicode/utf8"
+
+// Decoder turns the text the application sends into UTF-8. The Parser
+// passes it the bytes that aren't part of a control or escape sequence one
+// at a time, and it holds on to incomplete characters itself. Controls are
+// always recognized by the Parser, so a Decoder only sees text.
+type Decoder interface {
+	// Decode appends the UTF-8 encoding of anything completed by b to dst.
+	Decode(dst []byte, b byte) []byte
+
+	// Flush appends a replacement for any incomplete character to dst. It
+	// is called when the text is interrupted by a control, and by
+	// Parser.Flush.
+	Flush(dst []byte) []byte
+}
+
+// C1Decoder can optionally be implemented by a Decoder for an 8-bit
+// encoding where 0x80 to 0x9f are C1 controls, as in the ISO 8859
+// charsets. Those bytes are then handled as controls rather than passed to
+// Decode.
+type C1Decoder interface {
+	C1Controls() bool
+}
+
+// SetDecoder sets how text is decoded. The default is NewUTF8Decoder.
+func (p *Parser) SetDecoder(d Decoder) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.setDecoder(d)
+}
+
+func (p *Parser) setDecoder(d Decoder) {
+	p.decoder = d
+	p.u8, p.utf8 = d.(*utf8Decoder)
+
+	switch d.(type) {
+	case *utf8Decoder, *ByteDecoder:
+		p.ascii = true
+	default:
+		p.ascii = false
+	}
+
+	p.c1 = false
+	if c1, ok := d.(C1Decoder); ok {
+		p.c1 = c1.C1Controls()
+	}
+}
+
+const replacementChar = "\uFFFD"
+
+// NewUTF8Decoder returns a Decoder for UTF-8. Ill-formed sequences are
+// replaced with U+FFFD, one for each maximal subpart as recommended by
+// the Unicode standard (section 3.9), so "\xe2\x9d" followed by "A" is
+// decoded as "�A" and "\xff\xfe" as "��".
+func NewUTF8Decoder() Decoder {
+	return &utf8Decoder{}
+}
+
+type utf8Decoder struct {
+	buf  [utf8.UTFMax]byte
+	n    int // bytes collected in buf
+	need int // bytes in the whole sequence
+
+	// The range of the next byte that continues the sequence
+	lo, hi byte
+}
+
+func (d *utf8Decoder) Decode(dst []byte, b byte) []byte {
+	if d.n > 0 {
+		if b >= d.lo && b <= d.hi {
+			d.buf[d.n] = b
+			d.n++
+
+			if d.n == d.need {
+				dst = append(dst, d.buf[:d.n]...)
+				d.n = 0
+			}
+
+			d.lo, d.hi = 0x80, 0xbf
+
+			return dst
+		}
+
+		// The sequence so far is a maximal subpart, and b starts over
+		dst = append(dst, replacementChar...)
+		d.n = 0
+	}
+
+	// The well-formed sequences, from table 3-7 of the Unicode standard
+	switch {
+	case b < utf8.RuneSelf:
+		return append(dst, b)
+	case b >= 0xc2 && b <= 0xdf:
+		d.start(b, 2, 0x80, 0xbf)
+	case b == 0xe0:
+		d.start(b, 3, 0xa0, 0xbf)
+	case b >= 0xe1 && b <= 0xec, b == 0xee, b == 0xef:
+		d.start(b, 3, 0x80, 0xbf)
+	case b == 0xed:
+		// Excludes the surrogates
+		d.start(b, 3, 0x80, 0x9f)
+	case b == 0xf0:
+		d.start(b, 4, 0x90, 0xbf)
+	case b >= 0xf1 && b <= 0xf3:
+		d.start(b, 4, 0x80, 0xbf)
+	case b == 0xf4:
+		d.start(b, 4, 0x80, 0x8f)
+	default:
+		// A continuation byte on its own, or a byte that never appears
+		return append(dst, replacementChar...)
+	}
+
+	return dst
+}
+
+func (d *utf8Decoder) start(b byte, need int, lo, hi byte) {
+	d.buf[0] = b
+	d.n = 1
+	d.need = need
+	d.lo, d.hi = lo, hi
+}
+
+func (d *utf8Decoder) Flush(dst []byte) []byte {
+	if d.n == 0 {
+		return dst
+	}
+
+	d.n = 0
+
+	return append(dst, replacementChar...)
+}
+
+// ByteDecoder is a Decoder for a single byte encoding.
+type ByteDecoder struct {
+	// The characters for bytes 0x80 to 0xff. Bytes below that are ASCII.
+	High [128]rune
+
+	// Set if 0x80 to 0x9f are C1 controls.
+	C1 bool
+}
+
+func (d *ByteDecoder) Decode(dst []byte, b byte) []byte {
+	if b < utf8.RuneSelf {
+		return append(dst, b)
+	}
+
+	var buf [utf8.UTFMax]byte
+
+	n := utf8.EncodeRune(buf[:], d.High[b-0x80])
+
+	return append(dst, buf[:n]...)
+}
+
+func (d *ByteDecoder) Flush(dst []byte) []byte {
+	return dst
+}
+
+func (d *ByteDecoder) C1Controls() bool {
+	return d.C1
+}
+
+// Latin1 decodes ISO 8859-1, where 0x80 to 0x9f are C1 controls.
+var Latin1 = &ByteDecoder{C1: true}
+
+func init() {
+	for i := range Latin1.High {
+		Latin1.High[i] = rune(0x80 + i)
+	}
+}
+
+// CP437 decodes the character set of the original IBM PC, as used by DOS
+// and many BBSes. It has no C1 controls.
+var CP437 = &ByteDecoder{
+	High: [128]rune{
+		'Ç', 'ü', 'é', 'â', 'ä', 'à', 'å', 'ç', 'ê', 'ë', 'è', 'ï', 'î', 'ì', 'Ä', 'Å',
+		'É', 'æ', 'Æ', 'ô', 'ö', 'ò', 'û', 'ù', 'ÿ', 'Ö', 'Ü', '¢', '£', '¥', '₧', 'ƒ',
+		'á', 'í', 'ó', 'ú', 'ñ', 'Ñ', 'ª', 'º', '¿', '⌐', '¬', '½', '¼', '¡', '«', '»',
+		'░', '▒', '▓', '│', '┤', '╡', '╢', '╖', '╕', '╣', '║', '╗', '╝', '╜', '╛', '┐',
+		'└', '┴', '┬', '├', '─', '┼', '╞', '╟', '╚', '╔', '╩', '╦', '╠', '═', '╬', '╧',
+		'╨', '╤', '╥', '╙', '╘', '╒', '╓', '╫', '╪', '┘', '┌', '█', '▄', '▌', '▐', '▀',
+		'α', 'ß', 'Γ', 'π', 'Σ', 'σ', 'µ', 'τ', 'Φ', 'Θ', 'Ω', 'δ', '∞', 'φ', 'ε', '∩',
+		'≡', '±', '≥', '≤', '⌠', '⌡', '÷', '≈', '°', '∙', '·', '√', 'ⁿ', '²', '■', '\u00a0',
+	},
+}
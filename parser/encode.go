@@ -0,0 +1,273 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Encoder writes events back out as the bytes that make them up, so a
+// stream can be parsed, filtered and passed on. The output is canonical:
+// parsing it gives back the same events, and encoding those again gives
+// the same bytes. Control strings are always ended with ST.
+//
+// An Encoder is also an EventHandler, so a Parser can feed it directly.
+type Encoder struct {
+	w   io.Writer
+	buf []byte
+
+	eightBit bool
+	utf8     bool
+}
+
+var _ EventHandler = &Encoder{}
+
+// NewEncoder returns an Encoder writing to +w+, using 7-bit controls and
+// UTF-8.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		w:    w,
+		utf8: true,
+	}
+}
+
+// SetEightBit selects 8-bit C1 controls, such as 0x9b for CSI, rather than
+// their 7-bit ESC forms.
+func (e *Encoder) SetEightBit(enabled bool) {
+	e.eightBit = enabled
+}
+
+// SetUTF8 controls how the output is encoded, which should match the
+// Parser reading it. With it off, 8-bit controls are written as raw bytes
+// and text as Latin-1, with anything Latin-1 can't hold written as '?'.
+func (e *Encoder) SetUTF8(enabled bool) {
+	e.utf8 = enabled
+}
+
+// HandleEvent writes the event, the same as Encode.
+func (e *Encoder) HandleEvent(ev Event) error {
+	return e.Encode(ev)
+}
+
+// Encode writes the bytes for the event.
+func (e *Encoder) Encode(ev Event) error {
+	var err error
+
+	e.buf, err = e.Append(e.buf[:0], ev)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.w.Write(e.buf)
+	return err
+}
+
+// Append appends the bytes for the event to +dst+. ResizeEvent and
+// StringOverflowEvent have no encoding and return an error.
+func (e *Encoder) Append(dst []byte, gev Event) ([]byte, error) {
+	switch ev := gev.(type) {
+	case *TextEvent:
+		return e.appendText(dst, ev.Text), nil
+	case ControlEvent:
+		return e.appendControl(dst, byte(ev)), nil
+	case *CSIEvent:
+		if !validSequence(ev.Leader, ev.Intermed, ev.Command) {
+			return dst, fmt.Errorf("invalid CSI sequence: %s", ev)
+		}
+
+		dst = e.appendControl(dst, 0x9b)
+		dst = appendParams(dst, ev.Leader, ev.Args, ev.Sub, ev.Intermed)
+
+		return append(dst, ev.Command), nil
+	case *EscapeEvent:
+		if len(ev.Data) == 0 {
+			return dst, fmt.Errorf("empty escape sequence")
+		}
+
+		dst = append(dst, ESC)
+		return append(dst, ev.Data...), nil
+	case *OSCEvent:
+		dst = e.appendControl(dst, 0x9d)
+		dst = strconv.AppendInt(dst, int64(ev.Command), 10)
+
+		if ev.Data != "" {
+			dst = append(dst, ';')
+			dst = append(dst, ev.Data...)
+		}
+
+		return e.appendControl(dst, ST), nil
+	case *StringEvent:
+		intro, ok := stringIntroducers[ev.Kind]
+		if !ok {
+			return dst, fmt.Errorf("unknown control string kind: %s", ev.Kind)
+		}
+
+		dst = e.appendControl(dst, intro)
+		dst = appendStringData(dst, ev.Kind, ev.Data)
+
+		return e.appendControl(dst, ST), nil
+	case *DCSEvent:
+		if !validSequence(ev.Leader, ev.Intermed, ev.Command) {
+			return dst, fmt.Errorf("invalid DCS sequence: %s", ev)
+		}
+
+		dst = e.appendDCSHeader(dst, ev)
+		dst = appendStringData(dst, "DCS", ev.Data)
+
+		return e.appendControl(dst, ST), nil
+	case *StringChunkEvent:
+		return e.appendChunk(dst, ev)
+	default:
+		return dst, fmt.Errorf("unable to encode event type: %T", ev)
+	}
+}
+
+func (e *Encoder) appendText(dst []byte, text []byte) []byte {
+	if e.utf8 {
+		return append(dst, text...)
+	}
+
+	for len(text) > 0 {
+		r, sz := utf8.DecodeRune(text)
+		text = text[sz:]
+
+		if r > 0xff {
+			r = '?'
+		}
+
+		dst = append(dst, byte(r))
+	}
+
+	return dst
+}
+
+// appendControl appends a C0 or C1 control, using the 7-bit form of C1
+// controls unless 8-bit ones were asked for.
+func (e *Encoder) appendControl(dst []byte, b byte) []byte {
+	switch {
+	case !isC1(rune(b)):
+		return append(dst, b)
+	case !e.eightBit:
+		return append(dst, ESC, b-0x40)
+	case e.utf8:
+		return append(dst, 0xc2, b)
+	default:
+		return append(dst, b)
+	}
+}
+
+func (e *Encoder) appendDCSHeader(dst []byte, ev *DCSEvent) []byte {
+	dst = e.appendControl(dst, 0x90)
+	dst = appendParams(dst, ev.Leader, ev.Args, 0, ev.Intermed)
+
+	return append(dst, ev.Command)
+}
+
+func (e *Encoder) appendChunk(dst []byte, ev *StringChunkEvent) ([]byte, error) {
+	if ev.First {
+		if ev.Kind == "DCS" {
+			if ev.DCS == nil {
+				return dst, fmt.Errorf("DCS chunk without a header")
+			}
+
+			dst = e.appendDCSHeader(dst, ev.DCS)
+		} else {
+			intro, ok := stringIntroducers[ev.Kind]
+			if !ok {
+				return dst, fmt.Errorf("unknown control string kind: %s", ev.Kind)
+			}
+
+			dst = e.appendControl(dst, intro)
+		}
+	}
+
+	if ev.Canceled {
+		return append(dst, CAN), nil
+	}
+
+	dst = appendStringData(dst, ev.Kind, ev.Data)
+
+	if ev.Last {
+		dst = e.appendControl(dst, ST)
+	}
+
+	return dst, nil
+}
+
+// The C1 controls that start each kind of control string.
+var stringIntroducers = map[string]byte{
+	"DCS": 0x90,
+	"SOS": 0x98,
+	"OSC": 0x9d,
+	"PM":  0x9e,
+	"APC": 0x9f,
+}
+
+// appendParams appends the parameter part of a CSI or DCS. A missing
+// parameter (-1) is left empty, and if the last one is missing an extra
+// separator is added, since the parser drops an empty parameter at the end.
+// The parameters with their bit set in +sub+ are sub-parameters, which
+// come after a ':'.
+func appendParams(dst, leader []byte, args []int, sub uint32, intermed []byte) []byte {
+	dst = append(dst, leader...)
+
+	for i, arg := range args {
+		switch {
+		case i > 0 && i < maxParams && sub&(1<<uint(i)) != 0:
+			dst = append(dst, ':')
+		case i > 0:
+			dst = append(dst, ';')
+		}
+
+		if arg >= 0 {
+			dst = strconv.AppendInt(dst, int64(arg), 10)
+		}
+	}
+
+	if len(args) > 0 && args[len(args)-1] < 0 {
+		dst = append(dst, ';')
+	}
+
+	return append(dst, intermed...)
+}
+
+// appendStringData appends the body of a control string. ESC is doubled
+// in a DCS, which the parser reads back as a single ESC.
+func appendStringData(dst []byte, kind string, data []byte) []byte {
+	if kind != "DCS" {
+		return append(dst, data...)
+	}
+
+	for _, b := range data {
+		if b == ESC {
+			dst = append(dst, ESC)
+		}
+
+		dst = append(dst, b)
+	}
+
+	return dst
+}
+
+// validSequence checks that the parts of a CSI or DCS can be parsed back
+// the same way.
+func validSequence(leader, intermed []byte, command byte) bool {
+	if len(leader) > 1 || command < 0x40 || command > 0x7e {
+		return false
+	}
+
+	for _, b := range leader {
+		if b < 0x3c || b > 0x3f {
+			return false
+		}
+	}
+
+	for _, b := range intermed {
+		if b < 0x20 || b > 0x2f {
+			return false
+		}
+	}
+
+	return true
+}
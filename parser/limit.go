@@ -0,0 +1,125 @@
+package parser
+
+// DefaultStringLimits are the most bytes a Parser keeps for each kind of
+// control string before throwing it away, see SetStringLimit.
+var DefaultStringLimits = map[string]int{
+	"OSC": 1 << 20,
+	"DCS": 1 << 20,
+	"APC": 1 << 20,
+	"PM":  64 << 10,
+	"SOS": 64 << 10,
+}
+
+// StringChunkSize is the most bytes passed in one StringChunkEvent.
+const StringChunkSize = 4096
+
+// SetStringLimit sets the most bytes kept for a kind of control string
+// ("OSC", "DCS", "APC", "PM" or "SOS"). A string that grows past it is
+// discarded, and a StringOverflowEvent is emitted in its place. A limit of
+// 0 removes it, which lets a program use up all our memory.
+func (p *Parser) SetStringLimit(kind string, max int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.limits[kind] = max
+}
+
+// SetStringStreaming controls whether a kind of control string is passed
+// to the handler as a series of StringChunkEvents while it arrives, rather
+// than being collected into a single event once it ends. Streamed strings
+// are never held whole, so the limit doesn't apply to them. This suits
+// large payloads like sixel images, kitty graphics or OSC 52.
+func (p *Parser) SetStringStreaming(kind string, enabled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.streaming[kind] = enabled
+}
+
+// StringOverflowEvent is emitted when a control string grew past its limit.
+// The string is skipped up to its terminator and no other event is
+// emitted for it.
+type StringOverflowEvent struct {
+	Kind  string
+	Limit int
+}
+
+// StringChunkEvent is part of a control string that is being streamed.
+// Chunks are emitted once StringChunkSize bytes have been collected, and
+// at the end of each Write.
+type StringChunkEvent struct {
+	Kind string
+
+	// For a DCS, the parameters and final byte of the string. Its Data is
+	// always empty, the payload is in the chunks.
+	DCS *DCSEvent
+
+	Data []byte
+
+	// First is set on the first chunk of the string and Last on the final
+	// one, which may be both. If the string was cut off by CAN, SUB or an
+	// escape sequence, the final chunk has Canceled set and no data.
+	First, Last bool
+	Canceled    bool
+}
+
+// leavesString returns true if +t+ cuts off the control string being
+// collected, rather than ending it or carrying on with it.
+func (p *Parser) leavesString(t transition) bool {
+	switch {
+	case t.next == stKeep, t.action == actStringEnd:
+		return false
+	case p.state == stStringEscape:
+		// Only ST and another ESC don't cancel the string
+		return true
+	}
+
+	switch t.next {
+	case stStringEscape, stDCSParam, stDCSIntermediate, stDCSPassthrough, stDCSIgnore:
+		return false
+	}
+
+	// Any other state is outside the string, or starts a new one
+	return true
+}
+
+// cancelString drops a streamed string that was cut off, telling the
+// handler if it has already been passed part of it.
+func (p *Parser) cancelString() error {
+	if p.strChunked {
+		return p.emitChunk(true, true)
+	}
+
+	p.str = p.str[:0]
+	p.strStream = false
+
+	return nil
+}
+
+// emitChunk passes the collected part of a streamed string to the handler.
+func (p *Parser) emitChunk(last, canceled bool) error {
+	ev := &StringChunkEvent{
+		Kind:     p.strKind,
+		First:    !p.strChunked,
+		Last:     last,
+		Canceled: canceled,
+	}
+
+	if !canceled {
+		ev.Data = make([]byte, len(p.str))
+		copy(ev.Data, p.str)
+	}
+
+	if p.strKind == "DCS" {
+		ev.DCS = p.dcsEvent(nil)
+	}
+
+	p.str = p.str[:0]
+	p.strChunked = !last
+
+	if last {
+		p.strStream = false
+	}
+
+	return p.emit(ev)
+}
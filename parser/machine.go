@@ -0,0 +1,250 @@
+package parser
+
+// The parser follows the DEC ANSI parser state machine described at
+// https://vt100.net/emu/dec_ansi_parser, with a few changes to keep the
+// behavior the rest of vterm relies on:
+//
+//   * C0 controls inside OSC and DCS strings are executed rather than
+//     ignored, and BEL ends them like xterm does.
+//   * An ESC inside a string that isn't part of ST cancels the string
+//     and starts a new escape sequence.
+//   * ESC followed by 0x40 to 0x5f is executed as the matching C1 control.
+//   * NUL and DEL are ignored everywhere, and CAN and SUB abort the current
+//     sequence without printing anything.
+//   * ':' in CSI parameters separates them like ';', but marks the
+//     parameter after it as a sub-parameter, see CSIEvent.Sub.
+//   * ESC ESC inside a DCS is a literal ESC, as used by tmux to pass
+//     sequences through to the outer terminal.
+//   * SOS, PM and APC strings are kept and emitted rather than ignored.
+
+type pstate uint8
+
+const (
+	stGround pstate = iota
+	stEscape
+	stEscapeIntermediate
+	stCSIEntry
+	stCSIParam
+	stCSIIntermediate
+	stCSIIgnore
+	stDCSEntry
+	stDCSParam
+	stDCSIntermediate
+	stDCSPassthrough
+	stDCSIgnore
+	stOSCString
+	stSOSPMAPCString
+
+	// An ESC was seen inside a string, which is either the start of ST
+	// or cancels the string.
+	stStringEscape
+
+	numStates
+
+	// Used as the next state for actions that stay in the current state
+	// without running its entry action again.
+	stKeep pstate = 0xff
+)
+
+type paction uint8
+
+const (
+	actNone        paction = iota
+	actIgnore              // drop the byte
+	actPrint               // add the byte to the pending text
+	actExecute             // emit a ControlEvent
+	actCollect             // collect an intermediate byte
+	actLeader              // collect a CSI private marker
+	actParam               // add to the CSI parameters
+	actEscDispatch         // emit an EscapeEvent
+	actCSIDispatch         // emit a CSIEvent
+	actPut                 // add the byte to the control string
+	actStringEnd           // emit the control string
+	actStringESC           // an ESC inside a string that isn't ST
+	actC1                  // execute the C1 control for an ESC Fe sequence
+	actSOS                 // start a SOS, PM or APC string
+	actESCSOS              // same, from an ESC Fe sequence
+	actDCSHook             // the final byte of a DCS, the payload follows
+)
+
+// The states that are inside a control string, which ST, BEL or ESC end.
+var stringStates = []pstate{
+	stDCSEntry,
+	stDCSParam,
+	stDCSIntermediate,
+	stDCSPassthrough,
+	stDCSIgnore,
+	stOSCString,
+	stSOSPMAPCString,
+}
+
+type transition struct {
+	action paction
+	next   pstate
+}
+
+type stateTable [numStates][256]transition
+
+// Tables for when the input is UTF-8 and for when it's 8-bit. They only
+// differ in how 0x80 to 0x9f are handled.
+var (
+	utf8Table  = buildTable(true)
+	eightTable = buildTable(false)
+)
+
+func (t *stateTable) set(st pstate, lo, hi byte, action paction, next pstate) {
+	for b := int(lo); b <= int(hi); b++ {
+		t[st][b] = transition{action, next}
+	}
+}
+
+func (t *stateTable) setAll(lo, hi byte, action paction, next pstate) {
+	for st := pstate(0); st < numStates; st++ {
+		t.set(st, lo, hi, action, next)
+	}
+}
+
+// setC0 sets the C0 controls that aren't handled by the anywhere
+// transitions.
+func (t *stateTable) setC0(st pstate, action paction) {
+	t.set(st, 0x00, 0x17, action, stKeep)
+	t.set(st, 0x19, 0x19, action, stKeep)
+	t.set(st, 0x1c, 0x1f, action, stKeep)
+	t.set(st, 0x00, 0x00, actIgnore, stKeep)
+}
+
+func buildTable(utf8 bool) *stateTable {
+	var t stateTable
+
+	// Bytes not mentioned below are ignored
+	t.setAll(0x00, 0xff, actIgnore, stKeep)
+
+	// stGround
+	t.setC0(stGround, actExecute)
+	t.set(stGround, 0x20, 0x7e, actPrint, stKeep)
+	t.set(stGround, 0xa0, 0xff, actPrint, stKeep)
+
+	// stEscape
+	t.setC0(stEscape, actExecute)
+	t.set(stEscape, 0x20, 0x2f, actCollect, stEscapeIntermediate)
+	t.set(stEscape, 0x30, 0x3f, actEscDispatch, stGround)
+	t.set(stEscape, 0x40, 0x5f, actC1, stGround)
+	t.set(stEscape, 0x60, 0x7e, actEscDispatch, stGround)
+	t.set(stEscape, 'P', 'P', actNone, stDCSEntry)
+	t.set(stEscape, '[', '[', actNone, stCSIEntry)
+	t.set(stEscape, ']', ']', actNone, stOSCString)
+	t.set(stEscape, 'X', 'X', actESCSOS, stSOSPMAPCString)
+	t.set(stEscape, '^', '^', actESCSOS, stSOSPMAPCString)
+	t.set(stEscape, '_', '_', actESCSOS, stSOSPMAPCString)
+
+	// stEscapeIntermediate
+	t.setC0(stEscapeIntermediate, actExecute)
+	t.set(stEscapeIntermediate, 0x20, 0x2f, actCollect, stKeep)
+	t.set(stEscapeIntermediate, 0x30, 0x7e, actEscDispatch, stGround)
+
+	// stCSIEntry
+	t.setC0(stCSIEntry, actExecute)
+	t.set(stCSIEntry, 0x20, 0x2f, actCollect, stCSIIntermediate)
+	t.set(stCSIEntry, 0x30, 0x3b, actParam, stCSIParam)
+	t.set(stCSIEntry, 0x3c, 0x3f, actLeader, stCSIParam)
+	t.set(stCSIEntry, 0x40, 0x7e, actCSIDispatch, stGround)
+
+	// stCSIParam
+	t.setC0(stCSIParam, actExecute)
+	t.set(stCSIParam, 0x20, 0x2f, actCollect, stCSIIntermediate)
+	t.set(stCSIParam, 0x30, 0x3b, actParam, stKeep)
+	t.set(stCSIParam, 0x3c, 0x3f, actIgnore, stCSIIgnore)
+	t.set(stCSIParam, 0x40, 0x7e, actCSIDispatch, stGround)
+
+	// stCSIIntermediate
+	t.setC0(stCSIIntermediate, actExecute)
+	t.set(stCSIIntermediate, 0x20, 0x2f, actCollect, stKeep)
+	t.set(stCSIIntermediate, 0x30, 0x3f, actIgnore, stCSIIgnore)
+	t.set(stCSIIntermediate, 0x40, 0x7e, actCSIDispatch, stGround)
+
+	// stCSIIgnore
+	t.setC0(stCSIIgnore, actExecute)
+	t.set(stCSIIgnore, 0x40, 0x7e, actIgnore, stGround)
+
+	// stDCSEntry
+	t.setC0(stDCSEntry, actExecute)
+	t.set(stDCSEntry, 0x20, 0x2f, actCollect, stDCSIntermediate)
+	t.set(stDCSEntry, 0x30, 0x3b, actParam, stDCSParam)
+	t.set(stDCSEntry, 0x3c, 0x3f, actLeader, stDCSParam)
+	t.set(stDCSEntry, 0x40, 0x7e, actDCSHook, stDCSPassthrough)
+
+	// stDCSParam
+	t.setC0(stDCSParam, actExecute)
+	t.set(stDCSParam, 0x20, 0x2f, actCollect, stDCSIntermediate)
+	t.set(stDCSParam, 0x30, 0x3b, actParam, stKeep)
+	t.set(stDCSParam, 0x3c, 0x3f, actIgnore, stDCSIgnore)
+	t.set(stDCSParam, 0x40, 0x7e, actDCSHook, stDCSPassthrough)
+
+	// stDCSIntermediate
+	t.setC0(stDCSIntermediate, actExecute)
+	t.set(stDCSIntermediate, 0x20, 0x2f, actCollect, stKeep)
+	t.set(stDCSIntermediate, 0x30, 0x3f, actIgnore, stDCSIgnore)
+	t.set(stDCSIntermediate, 0x40, 0x7e, actDCSHook, stDCSPassthrough)
+
+	// stDCSPassthrough
+	t.setC0(stDCSPassthrough, actExecute)
+	t.set(stDCSPassthrough, 0x20, 0x7e, actPut, stKeep)
+	t.set(stDCSPassthrough, 0xa0, 0xff, actPut, stKeep)
+
+	// stDCSIgnore is all ignored
+
+	// stOSCString
+	t.setC0(stOSCString, actExecute)
+	t.set(stOSCString, 0x20, 0x7e, actPut, stKeep)
+	t.set(stOSCString, 0xa0, 0xff, actPut, stKeep)
+
+	// stSOSPMAPCString, C0 controls are ignored
+	t.set(stSOSPMAPCString, 0x20, 0x7e, actPut, stKeep)
+	t.set(stSOSPMAPCString, 0xa0, 0xff, actPut, stKeep)
+
+	for _, st := range stringStates {
+		t.set(st, BEL, BEL, actStringEnd, stGround)
+	}
+
+	// stStringEscape works like stEscape, other than for ST and ESC
+	t[stStringEscape] = t[stEscape]
+	t.set(stStringEscape, '\\', '\\', actStringEnd, stGround)
+
+	// Anywhere
+	t.setAll(CAN, CAN, actIgnore, stGround)
+	t.setAll(SUB, SUB, actIgnore, stGround)
+	t.setAll(ESC, ESC, actNone, stEscape)
+
+	for _, st := range stringStates {
+		t.set(st, ESC, ESC, actNone, stStringEscape)
+	}
+
+	t.set(stStringEscape, ESC, ESC, actStringESC, stKeep)
+
+	if utf8 {
+		// These are continuation bytes, C1 controls have to be UTF-8
+		// encoded and are handled before the table is consulted.
+		t.set(stGround, 0x80, 0x9f, actPrint, stKeep)
+		t.set(stDCSPassthrough, 0x80, 0x9f, actPut, stKeep)
+		t.set(stOSCString, 0x80, 0x9f, actPut, stKeep)
+		t.set(stSOSPMAPCString, 0x80, 0x9f, actPut, stKeep)
+	} else {
+		t.setC1()
+	}
+
+	return &t
+}
+
+// setC1 sets the anywhere transitions for 8-bit C1 controls.
+func (t *stateTable) setC1() {
+	t.setAll(0x80, 0x9f, actExecute, stGround)
+	t.setAll(0x90, 0x90, actNone, stDCSEntry)
+	t.setAll(0x9b, 0x9b, actNone, stCSIEntry)
+	t.setAll(0x9d, 0x9d, actNone, stOSCString)
+	t.setAll(0x98, 0x98, actSOS, stSOSPMAPCString)
+	t.setAll(0x9e, 0x9f, actSOS, stSOSPMAPCString)
+
+	for _, st := range stringStates {
+		t.set(st, ST, ST, actStringEnd, stGround)
+	}
+}
@@ -1,7 +1,6 @@
 package parser
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"fmt"
@@ -15,19 +14,52 @@ type EventHandler interface {
 	HandleEvent(Event) error
 }
 
+// WriteHandler can optionally be implemented by an EventHandler to be told
+// when it has been passed all the events of a Write, Flush or Resize, for
+// work that only needs doing once per batch of input.
+type WriteHandler interface {
+	WriteDone() error
+}
+
 type Parser struct {
 	Debug bool
 
-	br      *bufio.Reader
-	plain   bytes.Buffer
+	plain   []byte
 	handler EventHandler
 
-	r        io.Reader
-	newData  chan []byte
-	injected chan Event
-
-	curData []byte
-	pos     int
+	r io.Reader
+
+	// Held while bytes are run through the state machine, so that events
+	// from Write and Resize don't overlap.
+	mu sync.Mutex
+
+	state     pstate
+	intermed  []byte
+	csi       *CSIEvent
+	arg       int
+	sub       bool
+	str       []byte
+	strKind   string
+	pendingC2 bool
+
+	// See SetStringLimit and SetStringStreaming
+	limits      map[string]int
+	streaming   map[string]bool
+	strOverflow bool
+	strStream   bool
+	strChunked  bool
+
+	// Decodes the text, see SetDecoder. utf8 is set when it's the UTF-8
+	// decoder, which means C1 controls are UTF-8 encoded, and c1 when
+	// 0x80 to 0x9f are C1 controls.
+	decoder Decoder
+	utf8    bool
+	c1      bool
+
+	// Set when the decoder passes ASCII through unchanged, and u8 when it's
+	// the UTF-8 decoder.
+	ascii bool
+	u8    *utf8Decoder
 }
 
 func NewParser(r io.Reader, h EventHandler) (*Parser, error) {
@@ -36,14 +68,34 @@ func NewParser(r io.Reader, h EventHandler) (*Parser, error) {
 	parser := &Parser{
 		r: r,
 		// br:      br,
-		handler:  h,
-		newData:  make(chan []byte, 3),
-		injected: make(chan Event),
+		handler: h,
+
+		limits:    make(map[string]int),
+		streaming: make(map[string]bool),
 	}
 
+	for kind, max := range DefaultStringLimits {
+		parser.limits[kind] = max
+	}
+
+	parser.setDecoder(NewUTF8Decoder())
+
 	return parser, nil
 }
 
+// SetUTF8 controls how bytes above 0x7f are read. UTF-8 is on by default,
+// and C1 controls have to be sent as their 2 byte UTF-8 encodings. With it
+// off, raw 8-bit C1 controls such as 0x9b (CSI) are recognized, as used by
+// legacy hosts and serial consoles, and the rest is read as Latin-1. It's
+// a shortcut for SetDecoder.
+func (p *Parser) SetUTF8(enabled bool) {
+	if enabled {
+		p.SetDecoder(NewUTF8Decoder())
+	} else {
+		p.SetDecoder(Latin1)
+	}
+}
+
 type Event interface{}
 
 type ResizeEvent struct {
@@ -51,20 +103,25 @@ type ResizeEvent struct {
 	Confirm    chan error
 }
 
+// Resize passes a ResizeEvent to the handler, in between the events of
+// any bytes being written.
 func (p *Parser) Resize(ctx context.Context, rows, cols int) error {
-	c := make(chan error)
-	p.injected <- ResizeEvent{
-		Rows:    rows,
-		Cols:    cols,
-		Confirm: c,
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case err := <-c:
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	err := p.emit(ResizeEvent{
+		Rows: rows,
+		Cols: cols,
+	})
+	if err != nil {
 		return err
 	}
+
+	return p.writeDone()
 }
 
 const (
@@ -75,108 +132,405 @@ const (
 	ESC = 0x1b
 	BEL = 0x7
 	C0  = 0x20
+	C1  = 0x80
+	ST  = 0x9c
 )
 
+func isC1(r rune) bool {
+	return r >= C1 && r < 0xa0
+}
+
+func isPrintableASCII(b byte) bool {
+	return b >= C0 && b < DEL
+}
+
+// asciiText returns true if printable ASCII can be added to the text as
+// is, without going through the decoder.
+func (p *Parser) asciiText() bool {
+	return p.ascii && !p.pendingC2 && (p.u8 == nil || p.u8.n == 0)
+}
+
+// Write runs the bytes through the parser, passing the events they make up
+// to the handler before returning. Sequences that are cut off at the end of
+// +data+ are kept and finished by the next call. It never returns a short
+// count, only errors from the handler.
+func (p *Parser) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	err := p.feed(data)
+	if err != nil {
+		return 0, err
+	}
+
+	if p.strStream && len(p.str) > 0 {
+		err = p.emitChunk(false, false)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = p.flushText(false)
+	if err != nil {
+		return 0, err
+	}
+
+	err = p.writeDone()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+// Flush emits any text being held back while waiting for the rest of a
+// UTF-8 sequence.
+func (p *Parser) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	err := p.flushText(true)
+	if err != nil {
+		return err
+	}
+
+	return p.writeDone()
+}
+
+// writeDone tells the handler the events so far have all been passed to it,
+// if it wants to know.
+func (p *Parser) writeDone() error {
+	if h, ok := p.handler.(WriteHandler); ok {
+		return h.WriteDone()
+	}
+
+	return nil
+}
+
+// Drive reads from the reader given to NewParser and writes it to the
+// parser until the reader returns an error, which is returned. If ctx is
+// canceled, Drive returns once the current read finishes, so close the
+// reader to stop it sooner.
 func (p *Parser) Drive(ctx context.Context) error {
-	go p.readInput(ctx)
+	buf := make([]byte, 4096)
 
 	for {
-		b, err := p.readByte(ctx)
-		if err != nil {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
 
-		switch b {
-		case NUL, DEL:
-			continue
-		case CAN, SUB:
-			continue
-		case ESC:
-			err := p.readEsc(ctx)
-			if err != nil {
-				return err
-			}
-			continue
-		default:
-			if b < C0 {
-				err := p.readControl(b)
-				if err != nil {
-					return err
-				}
-
-				continue
+		n, err := p.r.Read(buf)
+		if n > 0 {
+			_, werr := p.Write(buf[:n])
+			if werr != nil {
+				return werr
 			}
 		}
 
-		err = p.unreadByte()
 		if err != nil {
+			ferr := p.Flush()
+			if ferr != nil {
+				return ferr
+			}
+
 			return err
 		}
+	}
+}
 
-	normal:
-		for {
-			b, err := p.readByte(ctx)
-			if err != nil {
-				if p.plain.Len() > 0 {
-					p.readSpan()
-				}
-
-				return err
+// feed runs each byte of data through the state machine.
+func (p *Parser) feed(data []byte) error {
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+
+		if p.state == stGround && isPrintableASCII(b) && p.asciiText() {
+			// Take the whole run of printable ASCII at once, which is
+			// most of what programs send.
+			end := i + 1
+			for end < len(data) && isPrintableASCII(data[end]) {
+				end++
 			}
 
-			switch b {
-			case NUL, DEL, CAN, SUB:
-				continue normal
-			case ESC:
-				err = p.unreadByte()
-				if err != nil {
-					return err
-				}
+			p.plain = append(p.plain, data[i:end]...)
+			i = end - 1
 
-				err := p.readSpan()
-				if err != nil {
-					return err
-				}
+			continue
+		}
 
-				break normal
-			default:
-				if b < C0 {
-					err = p.unreadByte()
-					if err != nil {
-						return err
-					}
+		if p.state == stCSIParam && b >= '0' && b <= '9' {
+			// The other common case, skip the table
+			p.addDigit(b)
+			continue
+		}
+
+		if p.utf8 {
+			if p.pendingC2 {
+				p.pendingC2 = false
 
-					err := p.readSpan()
+				if isC1(rune(b)) {
+					// A UTF-8 encoded C1 control
+					err := p.advance(eightTable, b)
 					if err != nil {
 						return err
 					}
 
-					break normal
+					continue
+				}
+
+				err := p.advance(utf8Table, 0xc2)
+				if err != nil {
+					return err
 				}
 			}
 
-			err = p.unreadByte()
+			if b == 0xc2 {
+				p.pendingC2 = true
+				continue
+			}
+
+			err := p.advance(utf8Table, b)
 			if err != nil {
 				return err
 			}
+		} else {
+			table := utf8Table
+			if p.c1 {
+				table = eightTable
+			}
 
-			r, _, err := p.readRune(ctx)
+			err := p.advance(table, b)
 			if err != nil {
 				return err
 			}
+		}
+	}
+
+	return nil
+}
+
+func (p *Parser) advance(table *stateTable, b byte) error {
+	t := table[p.state][b]
+
+	var err error
+
+	if p.strStream && p.leavesString(t) {
+		// Leaving a string that is being streamed without finishing it
+		err = p.cancelString()
+	}
+
+	perr := p.perform(t.action, b)
+	if err == nil {
+		err = perr
+	}
+
+	// Move on even when the handler fails, since the sequence has been
+	// dispatched and the state it was collected in is gone.
+	if t.next != stKeep {
+		p.enter(t.next)
+	}
+
+	return err
+}
+
+// enter switches to +st+, running its entry action.
+func (p *Parser) enter(st pstate) {
+	p.state = st
+
+	switch st {
+	case stEscape, stStringEscape:
+		p.intermed = p.intermed[:0]
+	case stCSIEntry:
+		p.startSequence()
+	case stDCSEntry:
+		p.startSequence()
+		p.startString("DCS")
+	case stOSCString:
+		p.startString("OSC")
+	case stDCSIgnore:
+		p.startString("")
+	}
+}
+
+// The largest value for a single CSI parameter and the most parameters kept
+// for a sequence. Anything beyond them is dropped.
+const (
+	maxParamValue = 65535
+	maxParams     = 32 // the bits of CSIEvent.Sub
+)
+
+func (p *Parser) perform(action paction, b byte) error {
+	switch action {
+	case actPrint:
+		p.plain = p.decoder.Decode(p.plain, b)
+	case actExecute:
+		return p.emit(ControlEvent(b))
+	case actC1:
+		return p.emit(ControlEvent(b + 0x40))
+	case actSOS:
+		p.startString(stringKinds[b])
+	case actESCSOS:
+		p.startString(stringKinds[b+0x40])
+	case actDCSHook:
+		if p.arg != -1 {
+			p.pushArg()
+		}
+
+		p.csi.Command = b
+	case actCollect:
+		if p.state == stEscape || p.state == stStringEscape || p.state == stEscapeIntermediate {
+			p.intermed = append(p.intermed, b)
+		} else {
+			p.csi.Intermed = append(p.csi.Intermed, b)
+		}
+	case actLeader:
+		p.csi.Leader = append(p.csi.Leader, b)
+	case actParam:
+		switch {
+		case b >= '0' && b <= '9':
+			p.addDigit(b)
+		case b == ';' || b == ':':
+			p.pushArg()
+			p.arg = -1
+			p.sub = b == ':'
+		}
+	case actEscDispatch:
+		data := make([]byte, len(p.intermed)+1)
+		copy(data, p.intermed)
+		data[len(p.intermed)] = b
+
+		return p.emit(&EscapeEvent{data})
+	case actCSIDispatch:
+		if p.arg != -1 {
+			p.pushArg()
+		}
+
+		ev := p.csi
+		p.csi = nil
 
-			_, err = p.plain.WriteRune(r)
+		ev.Command = b
+
+		return p.emit(ev)
+	case actPut:
+		return p.put(b)
+	case actStringEnd:
+		switch {
+		case p.strKind == "", p.strOverflow:
+			return nil
+		case p.strStream:
+			return p.emitChunk(true, false)
+		}
+
+		data := make([]byte, len(p.str))
+		copy(data, p.str)
+
+		return p.emit(p.stringEvent(p.strKind, data))
+	case actStringESC:
+		if p.strKind == "DCS" && p.csi.Command != 0 {
+			// An escaped ESC in the payload
+			p.state = stDCSPassthrough
+			return p.put(ESC)
+		}
+
+		if p.strStream {
+			err := p.cancelString()
 			if err != nil {
 				return err
 			}
+		}
 
-			// optimization because it's really common to get here and have just one single character
-			// in the buffer we need to emit immediately.
-			if p.buffered() == 0 {
-				p.readSpan()
-			}
+		p.enter(stEscape)
+	}
+
+	return nil
+}
+
+// startSequence clears the parameters of a CSI or DCS.
+func (p *Parser) startSequence() {
+	if p.csi == nil {
+		p.csi = csiEvents.Get().(*CSIEvent)
+	}
+
+	p.csi.Command = 0
+	p.csi.Leader = p.csi.Leader[:0]
+	p.csi.Args = p.csi.Args[:0]
+	p.csi.Intermed = p.csi.Intermed[:0]
+	p.csi.Sub = 0
+	p.arg = -1
+	p.sub = false
+}
+
+func (p *Parser) addDigit(b byte) {
+	if p.arg == -1 {
+		p.arg = 0
+	}
+
+	if p.arg <= maxParamValue {
+		p.arg = p.arg*10 + int(b-'0')
+	}
+}
+
+func (p *Parser) pushArg() {
+	if p.arg > maxParamValue {
+		p.arg = maxParamValue
+	}
+
+	if len(p.csi.Args) < maxParams {
+		if p.sub {
+			p.csi.Sub |= 1 << uint(len(p.csi.Args))
+		}
+
+		p.csi.Args = append(p.csi.Args, p.arg)
+	}
+}
+
+func (p *Parser) startString(kind string) {
+	p.strKind = kind
+	p.str = p.str[:0]
+	p.strOverflow = false
+	p.strStream = kind != "" && p.streaming[kind]
+	p.strChunked = false
+}
+
+// put adds a byte to the current control string, passing it on if the
+// string is being streamed or throwing it away if it's grown too long.
+func (p *Parser) put(b byte) error {
+	if p.strKind == "" || p.strOverflow {
+		return nil
+	}
+
+	p.str = append(p.str, b)
+
+	if p.strStream {
+		if len(p.str) >= StringChunkSize {
+			return p.emitChunk(false, false)
 		}
+
+		return nil
+	}
+
+	if max := p.limits[p.strKind]; max > 0 && len(p.str) > max {
+		p.strOverflow = true
+
+		// Don't hang on to the memory while the rest is skipped
+		p.str = nil
+
+		return p.emit(&StringOverflowEvent{
+			Kind:  p.strKind,
+			Limit: max,
+		})
 	}
+
+	return nil
+}
+
+// emit passes ev to the handler, after any text that came before it.
+func (p *Parser) emit(ev Event) error {
+	err := p.flushText(true)
+	if err != nil {
+		return err
+	}
+
+	return p.handler.HandleEvent(ev)
 }
 
 var textPool sync.Pool
@@ -189,6 +543,9 @@ func init() {
 	}
 }
 
+// TextEvent is text to be displayed. The Parser's decoder has already
+// converted it to UTF-8 and replaced anything ill-formed, so it's always
+// valid.
 type TextEvent struct {
 	Text []byte
 }
@@ -197,27 +554,21 @@ func (ev *TextEvent) Recycle() {
 	textPool.Put(ev)
 }
 
-func (p *Parser) readSpan() error {
-	if p.plain.Len() == 0 {
-		return nil
+// flushText emits the pending text. If +all+ is set, a character the
+// decoder has only seen part of is replaced, otherwise it's held back
+// until the rest of it arrives. The text is always valid UTF-8.
+func (p *Parser) flushText(all bool) error {
+	if all {
+		p.plain = p.decoder.Flush(p.plain)
 	}
 
-	ev := textPool.Get().(*TextEvent)
-
-	var buf []byte
-
-	if cap(ev.Text) > p.plain.Len() {
-		buf = ev.Text[:p.plain.Len()]
-	} else {
-		buf = make([]byte, p.plain.Len())
+	if len(p.plain) == 0 {
+		return nil
 	}
 
-	ev.Text = buf
-
-	_, err := p.plain.Read(buf)
-	if err != nil {
-		return err
-	}
+	// Swap buffers with the event rather than copying the text
+	ev := textPool.Get().(*TextEvent)
+	ev.Text, p.plain = p.plain, ev.Text[:0]
 
 	return p.handler.HandleEvent(ev)
 }
@@ -228,135 +579,119 @@ func (c ControlEvent) String() string {
 	return fmt.Sprintf("CTL: %#v (0x%x)", string(c), byte(c))
 }
 
-func (p *Parser) readControl(b byte) error {
-	return p.handler.HandleEvent(ControlEvent(b))
+type EscapeEvent struct {
+	Data []byte
 }
 
-func isIntermed(b byte) bool {
-	return b >= 0x20 && b <= 0x2f
+type OSCEvent struct {
+	Command int
+	Data    string
 }
 
-type EscapeEvent struct {
+// StringEvent is a control string that isn't otherwise parsed. Kind is
+// one of "OSC" (when the command isn't numeric), "APC", "PM" or "SOS".
+type StringEvent struct {
+	Kind string
 	Data []byte
 }
 
-func (p *Parser) readEsc(ctx context.Context) error {
-	var intermed []byte
-top:
-	for {
-		b, err := p.readByte(ctx)
-		if err != nil {
-			return err
-		}
+// The kinds of the strings started by SOS, PM and APC.
+var stringKinds = map[byte]string{
+	0x98: "SOS",
+	0x9e: "PM",
+	0x9f: "APC",
+}
 
-		switch b {
-		case NUL, DEL:
-			continue
-		case ESC:
-			intermed = nil
-			continue
-		case CAN, SUB:
-			return nil
-		default:
-			if b < C0 {
-				p.readControl(b)
-				continue top
-			}
+// DCSEvent is a device control string. The parameters, intermediates and
+// final byte are split out like a CSIEvent, and the rest of the string is
+// in Data.
+type DCSEvent struct {
+	Command  byte
+	Leader   []byte
+	Args     []int
+	Intermed []byte
+	Data     []byte
+}
+
+// DCSCommand returns the command, encoded the same way as CSICommand.
+func (d *DCSEvent) DCSCommand() DCSCommand {
+	idx := DCSCommand(d.Command)
+	if len(d.Leader) == 1 {
+		idx = DCSCommand(LEADER(d.Leader[0], d.Command))
+	}
+
+	if len(d.Intermed) == 1 {
+		idx = DCSCommand(INTERMED(d.Intermed[0], d.Command))
+	}
+
+	return idx
+}
+
+// Body returns the string as it was sent, without the DCS and ST.
+func (d *DCSEvent) Body() []byte {
+	var buf bytes.Buffer
+
+	buf.Write(d.Leader)
+
+	for i, arg := range d.Args {
+		if i > 0 {
+			buf.WriteByte(';')
 		}
 
-		switch b {
-		case 0x50: // DCS
-			return p.readString(ctx, "DCS")
-		case 0x5b: // CSI
-			return p.readCSI(ctx)
-		case 0x5d: // OSC
-			return p.readString(ctx, "OSC")
-		default:
-			if isIntermed(b) {
-				intermed = append(intermed, b)
-			} else if len(intermed) == 0 && b >= 0x40 && b < 0x60 {
-				return p.readControl(b + 0x40)
-			} else if b >= 0x30 && b < 0x7f {
-				intermed = append(intermed, b)
-				return p.handler.HandleEvent(&EscapeEvent{intermed})
-			} else {
-				L.Debug("Unhandled byte in escape", "byte", b)
-			}
+		if arg != -1 {
+			buf.WriteString(strconv.Itoa(arg))
 		}
 	}
+
+	buf.Write(d.Intermed)
+	buf.WriteByte(d.Command)
+	buf.Write(d.Data)
+
+	return buf.Bytes()
 }
 
-type OSCEvent struct {
-	Command int
-	Data    string
+func (d *DCSEvent) String() string {
+	cmd := d.DCSCommand()
+
+	return fmt.Sprintf("DCS: %s (0x%x) Leader=%#v Args=%#v Intermed=%#v Data=%q", cmd.String(), d.Command, d.Leader, d.Args, d.Intermed, d.Data)
 }
 
-type StringEvent struct {
-	Kind string
-	Data []byte
+// dcsEvent returns the DCS being parsed, with +data+ as the payload.
+func (p *Parser) dcsEvent(data []byte) *DCSEvent {
+	return &DCSEvent{
+		Command:  p.csi.Command,
+		Leader:   append([]byte(nil), p.csi.Leader...),
+		Args:     append([]int(nil), p.csi.Args...),
+		Intermed: append([]byte(nil), p.csi.Intermed...),
+		Data:     data,
+	}
 }
 
-func (p *Parser) emitStringEvent(kind string, data []byte) error {
-	if kind == "OSC" {
+// stringEvent builds the event for a finished control string.
+func (p *Parser) stringEvent(kind string, data []byte) Event {
+	switch kind {
+	case "DCS":
+		return p.dcsEvent(data)
+	case "OSC":
 		str := string(data)
 		if sc := strings.IndexByte(str, ';'); sc != -1 {
 			if cmd, err := strconv.Atoi(str[:sc]); err == nil {
-				return p.handler.HandleEvent(&OSCEvent{
+				return &OSCEvent{
 					Command: cmd,
 					Data:    str[sc+1:],
-				})
+				}
+			}
+		} else if cmd, err := strconv.Atoi(str); err == nil {
+			// Commands like OSC 112 (reset cursor color) have no data
+			return &OSCEvent{
+				Command: cmd,
 			}
 		}
 	}
 
-	return p.handler.HandleEvent(&StringEvent{
+	return &StringEvent{
 		Kind: kind,
 		Data: data,
-	})
-}
-
-func (p *Parser) readString(ctx context.Context, kind string) error {
-	var data []byte
-
-top:
-	for {
-		b, err := p.readByte(ctx)
-		if err != nil {
-			return err
-		}
-
-		switch b {
-		case NUL, DEL:
-			continue top
-		case CAN, SUB:
-			return nil
-		case ESC:
-			b, err := p.readByte(ctx)
-			if err != nil {
-				return err
-			}
-
-			if b == 0x5c {
-				return p.emitStringEvent(kind, data)
-			}
-
-			err = p.unreadByte()
-			if err != nil {
-				return err
-			}
-
-			return p.readEsc(ctx)
-		default:
-			switch {
-			case b == 0x7:
-				return p.emitStringEvent(kind, data)
-			case b < C0:
-				p.readControl(b)
-				continue top
-			default:
-				data = append(data, b)
-			}
-		}
 	}
 }
 
@@ -377,6 +712,11 @@ type CSIEvent struct {
 	Leader   []byte
 	Args     []int
 	Intermed []byte
+
+	// Sub has bit i set when Args[i] is a sub-parameter of the argument
+	// before it, which is when they were separated by ':' rather than ';',
+	// as in SGR 4:3.
+	Sub uint32
 }
 
 func (c *CSIEvent) CSICommand() CSICommand {
@@ -392,6 +732,12 @@ func (c *CSIEvent) CSICommand() CSICommand {
 	return idx
 }
 
+// IsSub returns true if Args[i] is a sub-parameter of the argument before
+// it.
+func (c *CSIEvent) IsSub(i int) bool {
+	return i > 0 && i < maxParams && c.Sub&(1<<uint(i)) != 0
+}
+
 func (c *CSIEvent) Recycle() {
 	csiEvents.Put(c)
 }
@@ -399,108 +745,5 @@ func (c *CSIEvent) Recycle() {
 func (c *CSIEvent) String() string {
 	cmd := c.CSICommand()
 
-	return fmt.Sprintf("CSI: %s (0x%x) Leader=%#v Args=%#v Intermed=%#v", cmd.String(), c.Command, c.Leader, c.Args, c.Intermed)
-}
-
-func (p *Parser) readCSI(ctx context.Context) error {
-	const (
-		LEADER   = 1
-		ARG      = 2
-		INTERMED = 3
-	)
-
-	ev := csiEvents.Get().(*CSIEvent)
-
-	var (
-		leader   []byte = ev.Leader[:0]
-		state    int    = LEADER
-		arg      int    = -1
-		args     []int  = ev.Args[:0]
-		intermed []byte = ev.Intermed[:0]
-	)
-
-top:
-	for {
-		b, err := p.readByte(ctx)
-		if err != nil {
-			ev.Command = b
-			ev.Leader = leader
-			ev.Args = args
-			ev.Intermed = intermed
-
-			p.handler.HandleEvent(ev)
-			return err
-		}
-
-		switch b {
-		case NUL, DEL:
-			continue
-		case CAN, SUB:
-			return nil
-		case ESC:
-			return p.readEsc(ctx)
-		default:
-			if b < C0 {
-				p.readControl(b)
-				continue top
-			}
-		}
-
-		switch state {
-		case LEADER:
-			if b >= 0x3c && b <= 0x3f {
-				leader = append(leader, b)
-				continue
-			} else {
-				state = ARG
-			}
-
-			fallthrough
-		case ARG:
-			if b >= '0' && b <= '9' {
-				if arg == -1 {
-					arg = 0
-				}
-
-				arg *= 10
-				arg += int(b - '0')
-				continue top
-			}
-
-			if b == ':' {
-				b = ';'
-			}
-
-			if b == ';' {
-				args = append(args, arg)
-				arg = -1
-				continue top
-			}
-
-			if arg != -1 {
-				args = append(args, arg)
-			}
-
-			state = INTERMED
-			fallthrough
-		case INTERMED:
-			switch {
-			case isIntermed(b):
-				intermed = append(intermed, b)
-				continue top
-			case b == ESC:
-				return nil
-			case b >= 0x40 && b <= 0x7e:
-				ev.Command = b
-				ev.Leader = leader
-				ev.Args = args
-				ev.Intermed = intermed
-
-				return p.handler.HandleEvent(ev)
-			}
-
-			// Invalid in CSI, cancel it.
-			return nil
-		}
-	}
+	return fmt.Sprintf("CSI: %s (0x%x) Leader=%#v Args=%#v Sub=%#x Intermed=%#v", cmd.String(), c.Command, c.Leader, c.Args, c.Sub, c.Intermed)
 }
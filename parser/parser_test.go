@@ -6,6 +6,7 @@ import (
 	"io"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -21,6 +22,33 @@ func (c *collectEvents) HandleEvent(ev Event) error {
 	return nil
 }
 
+// failCSI fails every CSI sequence, like a handler that doesn't know them.
+type failCSI struct {
+	collectEvents
+}
+
+func (f *failCSI) HandleEvent(ev Event) error {
+	f.collectEvents.HandleEvent(ev)
+
+	if _, ok := ev.(*CSIEvent); ok {
+		return io.ErrUnexpectedEOF
+	}
+
+	return nil
+}
+
+// countWrites records how many events had been passed to it each time it
+// was told a Write was done.
+type countWrites struct {
+	collectEvents
+	Done []int
+}
+
+func (c *countWrites) WriteDone() error {
+	c.Done = append(c.Done, len(c.Events))
+	return nil
+}
+
 func TestParser(t *testing.T) {
 	n := neko.Modern(t)
 
@@ -74,8 +102,8 @@ func TestParser(t *testing.T) {
 	}
 
 	n.It("can parse C1 7-bit", func(t *testing.T) {
-		inputs := bs("\x1b\x43", "\x1b\x5f")
-		ctl := []byte{0x83, 0x9f}
+		inputs := bs("\x1b\x43", "\x1b\x45")
+		ctl := []byte{0x83, 0x85}
 
 		for idx, b := range inputs {
 			var c collectEvents
@@ -265,6 +293,13 @@ func TestParser(t *testing.T) {
 		}
 	}
 
+	csiSub := func(command byte, sub uint32, args ...int) *CSIEvent {
+		ev := csi(command, args...)
+		ev.Sub = sub
+
+		return ev
+	}
+
 	n.It("handles CSI sequences", func(t *testing.T) {
 		tests := []struct {
 			input string
@@ -277,8 +312,9 @@ func TestParser(t *testing.T) {
 			// !CSI 2 args
 			{"\x1b[3;4c", csi(0x63, 3, 4)},
 			// !CSI 1 arg 1 sub
-			// PUSH "\x1b[1:2c"
-			// csi 0x63 1+,2
+			{"\x1b[1:2c", csiSub(0x63, 0x2, 1, 2)},
+			// !CSI subs after a plain arg, and an empty sub
+			{"\x1b[4;58:2::1:2:3m", csiSub(0x6d, 0x7c, 4, 58, 2, -1, 1, 2, 3)},
 
 			// !CSI many digits
 			{"\x1b[678d", csi(0x64, 678)},
@@ -410,6 +446,9 @@ func TestParser(t *testing.T) {
 
 			// !OSC ST (7bit)
 			{"\x1b]1;Hello\x1b\\", &OSCEvent{Command: 1, Data: "Hello"}},
+
+			// !OSC without data
+			{"\x1b]112\x07", &OSCEvent{Command: 112}},
 		}
 
 		for _, test := range tests {
@@ -493,13 +532,28 @@ func TestParser(t *testing.T) {
 	n.It("handles DSC sequences", func(t *testing.T) {
 		tests := []struct {
 			input string
-			event *StringEvent
+			event *DCSEvent
 		}{
 			// !OSC BEL
-			{"\x1bP1;Hello\x07", &StringEvent{Kind: "DCS", Data: []byte("1;Hello")}},
+			{"\x1bP1;Hello\x07", &DCSEvent{Command: 'H', Args: []int{1}, Data: []byte("ello")}},
 
 			// !OSC ST (7bit)
-			{"\x1bP1;Hello\x1b\\", &StringEvent{Kind: "DCS", Data: []byte("1;Hello")}},
+			{"\x1bP1;Hello\x1b\\", &DCSEvent{Command: 'H', Args: []int{1}, Data: []byte("ello")}},
+
+			// DECRQSS
+			{"\x1bP$qm\x1b\\", &DCSEvent{Command: 'q', Intermed: []byte("$"), Data: []byte("m")}},
+
+			// XTGETTCAP
+			{"\x1bP+q544e\x1b\\", &DCSEvent{Command: 'q', Intermed: []byte("+"), Data: []byte("544e")}},
+
+			// Sixel, with a missing parameter
+			{"\x1bP0;;8q#0\x1b\\", &DCSEvent{Command: 'q', Args: []int{0, -1, 8}, Data: []byte("#0")}},
+
+			// A private marker
+			{"\x1bP>|x\x1b\\", &DCSEvent{Command: '|', Leader: []byte(">"), Data: []byte("x")}},
+
+			// tmux passthrough, where ESC ESC is a literal ESC
+			{"\x1bPtmux;\x1b\x1b[1m\x1b\\", &DCSEvent{Command: 't', Data: []byte("mux;\x1b[1m")}},
 		}
 
 		for _, test := range tests {
@@ -513,13 +567,74 @@ func TestParser(t *testing.T) {
 
 			require.Equal(t, 1, len(c.Events))
 
-			ev, ok := c.Events[0].(*StringEvent)
+			ev, ok := c.Events[0].(*DCSEvent)
 			require.True(t, ok)
 
 			assert.Equal(t, test.event, ev)
 		}
 	})
 
+	n.It("splits out the DCS command", func(t *testing.T) {
+		tests := []struct {
+			input string
+			cmd   DCSCommand
+			body  string
+		}{
+			{"\x1bP$qm\x1b\\", DECRQSS, "$qm"},
+			{"\x1bP+q544e\x1b\\", XTGETTCAP, "+q544e"},
+			{"\x1bP0;;8q#0\x1b\\", DECSIXEL, "0;;8q#0"},
+			{"\x1bPtmux;x\x1b\\", TMUX, "tmux;x"},
+		}
+
+		for _, test := range tests {
+			var c collectEvents
+
+			pr, err := NewParser(strings.NewReader(test.input), &c)
+			require.NoError(t, err)
+
+			err = pr.Drive(context.TODO())
+			require.Error(t, err, io.EOF)
+
+			require.Equal(t, 1, len(c.Events))
+
+			ev, ok := c.Events[0].(*DCSEvent)
+			require.True(t, ok)
+
+			assert.Equal(t, test.cmd, ev.DCSCommand())
+			assert.Equal(t, test.body, string(ev.Body()))
+		}
+	})
+
+	n.It("handles APC, PM and SOS strings", func(t *testing.T) {
+		tests := []struct {
+			input string
+			utf8  bool
+			event *StringEvent
+		}{
+			{"\x1b_Gf=24;AAAA\x1b\\", true, &StringEvent{Kind: "APC", Data: []byte("Gf=24;AAAA")}},
+			{"\x1b^private\x1b\\", true, &StringEvent{Kind: "PM", Data: []byte("private")}},
+			{"\x1bXstart\x1b\\", true, &StringEvent{Kind: "SOS", Data: []byte("start")}},
+			{"\u009f\u00e9t\u00e9\u009c", true, &StringEvent{Kind: "APC", Data: []byte("\u00e9t\u00e9")}},
+			{"\x9fapc\x9c", false, &StringEvent{Kind: "APC", Data: []byte("apc")}},
+		}
+
+		for _, test := range tests {
+			var c collectEvents
+
+			pr, err := NewParser(strings.NewReader(test.input), &c)
+			require.NoError(t, err)
+
+			pr.SetUTF8(test.utf8)
+
+			err = pr.Drive(context.TODO())
+			require.Error(t, err, io.EOF)
+
+			require.Equal(t, 1, len(c.Events), "input: %q", test.input)
+
+			assert.Equal(t, test.event, c.Events[0], "input: %q", test.input)
+		}
+	})
+
 	n.It("handles escape cancels DCS, starts escape", func(t *testing.T) {
 		input := []byte("\x1bPSomething\x1b9")
 		var c collectEvents
@@ -573,11 +688,11 @@ func TestParser(t *testing.T) {
 
 		assert.Equal(t, byte(10), byte(cev))
 
-		ev, ok := c.Events[1].(*StringEvent)
+		ev, ok := c.Events[1].(*DCSEvent)
 		require.True(t, ok)
 
-		assert.Equal(t, "DCS", ev.Kind)
-		assert.Equal(t, []byte("Bye"), ev.Data)
+		assert.Equal(t, byte('B'), ev.Command)
+		assert.Equal(t, []byte("ye"), ev.Data)
 	})
 
 	n.It("ignores NUL and DEL", func(t *testing.T) {
@@ -625,5 +740,508 @@ func TestParser(t *testing.T) {
 		assert.Equal(t, []byte(str), te.Text)
 	})
 
+	n.It("recognizes 8-bit C1 controls when not using UTF-8", func(t *testing.T) {
+		input := "a\xe9\x9b5m\x9d0;hi\x9c\x90B\x9c\x85"
+
+		var c collectEvents
+		pr, err := NewParser(strings.NewReader(input), &c)
+		require.NoError(t, err)
+
+		pr.SetUTF8(false)
+
+		err = pr.Drive(context.TODO())
+		require.Error(t, err, io.EOF)
+
+		require.Equal(t, 5, len(c.Events))
+
+		// Latin-1 text comes out as UTF-8
+		assert.Equal(t, &TextEvent{Text: []byte("a\u00e9")}, c.Events[0])
+		assert.Equal(t, csi(0x6d, 5), c.Events[1])
+		assert.Equal(t, &OSCEvent{Command: 0, Data: "hi"}, c.Events[2])
+		assert.Equal(t, &DCSEvent{Command: 'B', Data: []byte{}}, c.Events[3])
+		assert.Equal(t, ControlEvent(0x85), c.Events[4])
+	})
+
+	n.It("recognizes UTF-8 encoded C1 controls", func(t *testing.T) {
+		input := "a\u009b5m\u009d0;hi\u009c"
+
+		var c collectEvents
+		pr, err := NewParser(strings.NewReader(input), &c)
+		require.NoError(t, err)
+
+		err = pr.Drive(context.TODO())
+		require.Error(t, err, io.EOF)
+
+		require.Equal(t, 3, len(c.Events))
+
+		assert.Equal(t, &TextEvent{Text: []byte("a")}, c.Events[0])
+		assert.Equal(t, csi(0x6d, 5), c.Events[1])
+		assert.Equal(t, &OSCEvent{Command: 0, Data: "hi"}, c.Events[2])
+	})
+
+	n.It("handles malformed sequences", func(t *testing.T) {
+		tests := []struct {
+			input  string
+			events []Event
+		}{
+			// A private marker after the parameters makes the CSI invalid
+			{"\x1b[1?2hX", []Event{&TextEvent{Text: []byte("X")}}},
+			// As does a parameter after an intermediate
+			{"\x1b[ 1qX", []Event{&TextEvent{Text: []byte("X")}}},
+			// SUB cancels like CAN
+			{"\x1b[1\x1aX", []Event{&TextEvent{Text: []byte("X")}}},
+			// APC strings don't leak into the text
+			{"\x1b_hidden\x1b\\X", []Event{&StringEvent{Kind: "APC", Data: []byte("hidden")}, &TextEvent{Text: []byte("X")}}},
+			// Nor do C0 controls inside them
+			{"\x1b_a\nb\x1b\\X", []Event{&StringEvent{Kind: "APC", Data: []byte("ab")}, &TextEvent{Text: []byte("X")}}},
+			// ESC inside an escape sequence starts over
+			{"\x1b(\x1b[5m", []Event{csi(0x6d, 5)}},
+			// Parameters are clamped rather than overflowing
+			{"\x1b[99999999999999999999m", []Event{csi(0x6d, 65535)}},
+			// Empty parameters are reported as -1
+			{"\x1b[;5H", []Event{csi(0x48, -1, 5)}},
+		}
+
+		for _, test := range tests {
+			var c collectEvents
+
+			pr, err := NewParser(strings.NewReader(test.input), &c)
+			require.NoError(t, err)
+
+			err = pr.Drive(context.TODO())
+			require.Error(t, err, io.EOF)
+
+			assert.Equal(t, test.events, c.Events, "input: %q", test.input)
+		}
+	})
+
+	n.It("keeps partial sequences between reads", func(t *testing.T) {
+		input := "\xe2\x9d\xaf\x1b[3;4H\x1b]2;hi\x07"
+
+		var c collectEvents
+		pr, err := NewParser(iotest.OneByteReader(strings.NewReader(input)), &c)
+		require.NoError(t, err)
+
+		err = pr.Drive(context.TODO())
+		require.Error(t, err, io.EOF)
+
+		require.Equal(t, 3, len(c.Events))
+
+		assert.Equal(t, &TextEvent{Text: []byte("\xe2\x9d\xaf")}, c.Events[0])
+		assert.Equal(t, csi(0x48, 3, 4), c.Events[1])
+		assert.Equal(t, &OSCEvent{Command: 2, Data: "hi"}, c.Events[2])
+	})
+
+	n.It("parses synchronously with Write", func(t *testing.T) {
+		var c collectEvents
+
+		pr, err := NewParser(nil, &c)
+		require.NoError(t, err)
+
+		n, err := pr.Write([]byte("a\x1b[1"))
+		require.NoError(t, err)
+		assert.Equal(t, 4, n)
+
+		require.Equal(t, 1, len(c.Events))
+		assert.Equal(t, &TextEvent{Text: []byte("a")}, c.Events[0])
+
+		err = pr.Resize(context.TODO(), 10, 20)
+		require.NoError(t, err)
+
+		_, err = pr.Write([]byte("2m\xe2\x9d"))
+		require.NoError(t, err)
+
+		require.Equal(t, 3, len(c.Events))
+		assert.Equal(t, ResizeEvent{Rows: 10, Cols: 20}, c.Events[1])
+		assert.Equal(t, csi(0x6d, 12), c.Events[2])
+
+		// The partial rune is held until the rest of it arrives
+		_, err = pr.Write([]byte("\xaf"))
+		require.NoError(t, err)
+
+		require.Equal(t, 4, len(c.Events))
+		assert.Equal(t, &TextEvent{Text: []byte("\xe2\x9d\xaf")}, c.Events[3])
+	})
+
+	n.It("tells the handler when the events of a write are done", func(t *testing.T) {
+		var c countWrites
+
+		pr, err := NewParser(nil, &c)
+		require.NoError(t, err)
+
+		_, err = pr.Write([]byte("a\x1b[1mb"))
+		require.NoError(t, err)
+
+		assert.Equal(t, []int{3}, c.Done)
+
+		require.NoError(t, pr.Resize(context.TODO(), 10, 20))
+
+		_, err = pr.Write([]byte("\xe2"))
+		require.NoError(t, err)
+
+		require.NoError(t, pr.Flush())
+
+		assert.Equal(t, []int{3, 4, 4, 5}, c.Done)
+	})
+
+	n.It("carries on after the handler fails a sequence", func(t *testing.T) {
+		var f failCSI
+
+		pr, err := NewParser(nil, &f)
+		require.NoError(t, err)
+
+		_, err = pr.Write([]byte("\x1b[79999["))
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+		// Parameters after the failed sequence are plain text
+		_, err = pr.Write([]byte("38;2m\x1b[1m"))
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+		require.Equal(t, 3, len(f.Events))
+		assert.Equal(t, &TextEvent{Text: []byte("38;2m")}, f.Events[1])
+		assert.Equal(t, csi('m', 1), f.Events[2])
+	})
+
+	n.It("stops driving when the context is canceled", func(t *testing.T) {
+		var c collectEvents
+
+		pr, err := NewParser(strings.NewReader("hello"), &c)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err = pr.Drive(ctx)
+		assert.Equal(t, context.Canceled, err)
+
+		assert.Equal(t, 0, len(c.Events))
+	})
+
+	n.It("discards control strings over the limit", func(t *testing.T) {
+		var c collectEvents
+
+		pr, err := NewParser(nil, &c)
+		require.NoError(t, err)
+
+		pr.SetStringLimit("OSC", 8)
+
+		_, err = pr.Write([]byte("\x1b]2;12345678"))
+		require.NoError(t, err)
+
+		require.Equal(t, 1, len(c.Events))
+		assert.Equal(t, &StringOverflowEvent{Kind: "OSC", Limit: 8}, c.Events[0])
+
+		// The rest of the string is skipped
+		_, err = pr.Write([]byte("more\x07a\x1b]2;short\x07"))
+		require.NoError(t, err)
+
+		require.Equal(t, 3, len(c.Events))
+		assert.Equal(t, &TextEvent{Text: []byte("a")}, c.Events[1])
+		assert.Equal(t, &OSCEvent{Command: 2, Data: "short"}, c.Events[2])
+	})
+
+	n.It("streams control strings in chunks", func(t *testing.T) {
+		var c collectEvents
+
+		pr, err := NewParser(nil, &c)
+		require.NoError(t, err)
+
+		pr.SetStringStreaming("DCS", true)
+		pr.SetStringLimit("DCS", 4)
+
+		_, err = pr.Write([]byte("\x1bP0;1q#0;2"))
+		require.NoError(t, err)
+
+		big := bytes.Repeat([]byte("~"), StringChunkSize+10)
+
+		_, err = pr.Write(big)
+		require.NoError(t, err)
+
+		_, err = pr.Write([]byte("-\x1b\\"))
+		require.NoError(t, err)
+
+		require.Equal(t, 4, len(c.Events))
+
+		header := &DCSEvent{Command: 'q', Args: []int{0, 1}}
+
+		assert.Equal(t, &StringChunkEvent{Kind: "DCS", DCS: header, Data: []byte("#0;2"), First: true}, c.Events[0])
+		assert.Equal(t, &StringChunkEvent{Kind: "DCS", DCS: header, Data: big[:StringChunkSize]}, c.Events[1])
+		assert.Equal(t, &StringChunkEvent{Kind: "DCS", DCS: header, Data: big[:10]}, c.Events[2])
+		assert.Equal(t, &StringChunkEvent{Kind: "DCS", DCS: header, Data: []byte("-"), Last: true}, c.Events[3])
+	})
+
+	n.It("tells the handler when a streamed string is canceled", func(t *testing.T) {
+		var c collectEvents
+
+		pr, err := NewParser(nil, &c)
+		require.NoError(t, err)
+
+		pr.SetStringStreaming("APC", true)
+
+		_, err = pr.Write([]byte("\x1b_Gabc"))
+		require.NoError(t, err)
+
+		_, err = pr.Write([]byte("def\x1b[mX"))
+		require.NoError(t, err)
+
+		require.Equal(t, 4, len(c.Events))
+
+		// Whatever was collected since the last chunk is dropped
+		assert.Equal(t, &StringChunkEvent{Kind: "APC", Data: []byte("Gabc"), First: true}, c.Events[0])
+		assert.Equal(t, &StringChunkEvent{Kind: "APC", Last: true, Canceled: true}, c.Events[1])
+		assert.Equal(t, csi(0x6d), c.Events[2])
+		assert.Equal(t, &TextEvent{Text: []byte("X")}, c.Events[3])
+	})
+
+	n.It("drops a streamed string canceled before its first chunk", func(t *testing.T) {
+		for _, input := range []string{"\x1b]52;c;abc\x1b[1mhi", "\x1b]52;c;abc\x18\x1b[1mhi", "\x1b]52;c;abc\x1b\x1b[1mhi"} {
+			var c collectEvents
+
+			pr, err := NewParser(nil, &c)
+			require.NoError(t, err)
+
+			pr.SetStringStreaming("OSC", true)
+
+			_, err = pr.Write([]byte(input))
+			require.NoError(t, err)
+
+			require.NoError(t, pr.Flush())
+
+			require.Equal(t, 2, len(c.Events), "%q", input)
+			assert.Equal(t, csi(0x6d, 1), c.Events[0])
+			assert.Equal(t, &TextEvent{Text: []byte("hi")}, c.Events[1])
+
+			// The next string starts afresh
+			_, err = pr.Write([]byte("\x1b]2;x\x07"))
+			require.NoError(t, err)
+
+			require.Equal(t, 3, len(c.Events))
+			assert.Equal(t, &StringChunkEvent{Kind: "OSC", Data: []byte("2;x"), First: true, Last: true}, c.Events[2])
+		}
+	})
+
+	n.It("replaces ill-formed UTF-8 by maximal subpart", func(t *testing.T) {
+		tests := []struct {
+			input, text string
+		}{
+			// A truncated sequence is one replacement
+			{"a\xe2\x9dA", "a\ufffdA"},
+			{"\xf0\x9f\x98A", "\ufffdA"},
+			// Bytes that can't start a sequence are one each
+			{"\xff\xfe", "\ufffd\ufffd"},
+			{"\x80\xbf", "\ufffd\ufffd"},
+			{"\xc0\xaf", "\ufffd\ufffd"},
+			// Overlong and surrogate forms fail at the second byte
+			{"\xe0\x80\x80", "\ufffd\ufffd\ufffd"},
+			{"\xed\xa0\x80", "\ufffd\ufffd\ufffd"},
+			// As do code points past U+10FFFF
+			{"\xf4\x90\x80\x80", "\ufffd\ufffd\ufffd\ufffd"},
+			// The example from the Unicode standard
+			{"\x61\xf1\x80\x80\xe1\x80\xc2\x62\x80\x63\x80\xbf\x64", "a\ufffd\ufffd\ufffdb\ufffdc\ufffd\ufffdd"},
+			// Valid text is left alone
+			{"\u00e9\u2764\U0001f600", "\u00e9\u2764\U0001f600"},
+		}
+
+		for _, test := range tests {
+			var c collectEvents
+
+			pr, err := NewParser(nil, &c)
+			require.NoError(t, err)
+
+			_, err = pr.Write([]byte(test.input))
+			require.NoError(t, err)
+
+			err = pr.Flush()
+			require.NoError(t, err)
+
+			var text []byte
+			for _, ev := range c.Events {
+				tev, ok := ev.(*TextEvent)
+				require.True(t, ok)
+
+				text = append(text, tev.Text...)
+			}
+
+			assert.Equal(t, test.text, string(text), "input: %q", test.input)
+		}
+	})
+
+	n.It("replaces a sequence cut off by a control", func(t *testing.T) {
+		var c collectEvents
+
+		pr, err := NewParser(nil, &c)
+		require.NoError(t, err)
+
+		_, err = pr.Write([]byte("a\xe2\x9d"))
+		require.NoError(t, err)
+
+		_, err = pr.Write([]byte("\nb"))
+		require.NoError(t, err)
+
+		require.Equal(t, 4, len(c.Events))
+		assert.Equal(t, &TextEvent{Text: []byte("a")}, c.Events[0])
+		assert.Equal(t, &TextEvent{Text: []byte("\ufffd")}, c.Events[1])
+		assert.Equal(t, ControlEvent('\n'), c.Events[2])
+		assert.Equal(t, &TextEvent{Text: []byte("b")}, c.Events[3])
+	})
+
+	n.It("decodes legacy encodings", func(t *testing.T) {
+		var c collectEvents
+
+		pr, err := NewParser(nil, &c)
+		require.NoError(t, err)
+
+		pr.SetDecoder(CP437)
+
+		// CP437 has no C1 controls, 0x9b is a cent sign
+		_, err = pr.Write([]byte("\xc9\xcd\xbb \x9b5\x1b[m"))
+		require.NoError(t, err)
+
+		require.Equal(t, 2, len(c.Events))
+		assert.Equal(t, &TextEvent{Text: []byte("\u2554\u2550\u2557 \u00a25")}, c.Events[0])
+		assert.Equal(t, csi(0x6d), c.Events[1])
+
+		c.Events = nil
+
+		pr.SetDecoder(Latin1)
+
+		_, err = pr.Write([]byte("\xe9\x9b5m"))
+		require.NoError(t, err)
+
+		require.Equal(t, 2, len(c.Events))
+		assert.Equal(t, &TextEvent{Text: []byte("\u00e9")}, c.Events[0])
+		assert.Equal(t, csi(0x6d, 5), c.Events[1])
+	})
+
+	n.It("encodes events canonically", func(t *testing.T) {
+		tests := []struct {
+			event Event
+
+			// The 7-bit and 8-bit UTF-8 forms
+			seven, eight string
+		}{
+			{&TextEvent{Text: []byte("h\u00e9")}, "h\u00e9", "h\u00e9"},
+			{ControlEvent('\n'), "\n", "\n"},
+			{ControlEvent(0x85), "\x1bE", "\xc2\x85"},
+			{csi(0x6d), "\x1b[m", "\xc2\x9bm"},
+			{csi(0x48, 1, -1), "\x1b[1;;H", "\xc2\x9b1;;H"},
+			{csiSub(0x6d, 0x3a, 4, 3, 38, 2, -1, 1), "\x1b[4:3;38:2::1m", "\xc2\x9b4:3;38:2::1m"},
+			{csiL(0x68, []byte("?"), 1049), "\x1b[?1049h", "\xc2\x9b?1049h"},
+			{&EscapeEvent{Data: []byte("#8")}, "\x1b#8", "\x1b#8"},
+			{&OSCEvent{Command: 2, Data: "title"}, "\x1b]2;title\x1b\\", "\xc2\x9d2;title\xc2\x9c"},
+			{&OSCEvent{Command: 112}, "\x1b]112\x1b\\", "\xc2\x9d112\xc2\x9c"},
+			{&StringEvent{Kind: "APC", Data: []byte("Gf=24")}, "\x1b_Gf=24\x1b\\", "\xc2\x9fGf=24\xc2\x9c"},
+			{&DCSEvent{Command: 't', Data: []byte("mux;\x1b[m")}, "\x1bPtmux;\x1b\x1b[m\x1b\\", "\xc2\x90tmux;\x1b\x1b[m\xc2\x9c"},
+		}
+
+		for _, test := range tests {
+			var out bytes.Buffer
+
+			enc := NewEncoder(&out)
+
+			err := enc.Encode(test.event)
+			require.NoError(t, err)
+
+			assert.Equal(t, test.seven, out.String())
+
+			out.Reset()
+			enc.SetEightBit(true)
+
+			err = enc.Encode(test.event)
+			require.NoError(t, err)
+
+			assert.Equal(t, test.eight, out.String())
+		}
+	})
+
+	n.It("round trips through the encoder", func(t *testing.T) {
+		inputs := []string{
+			"plain text\r\n",
+			"\x1b[1;31mred\x1b[0m \x1b[;5H\x1b[2;J\x1b[?25l\x1b[>c\x1b[ q",
+			"\x1b[4:3m\x1b[58:2::10:20:30;1m\x1b[38;5;1m",
+			"\x1b7\x1b8\x1bM\x1b#8\x1b(B\x1b c",
+			"\x1b]0;title\x07\x1b]52;c;aGk=\x1b\\\x1b]112\x07",
+			"\x1bP1$r0;1r\x1b\\\x1bP+q544e\x1b\\\x1bPq#0;2;0;0;0\x1b\\",
+			"\x1b_Gf=100;AAAA\x1b\\\x1b^pm\x1b\\\x1bXsos\x1b\\",
+			"\x1bPtmux;\x1b\x1b]2;inner\x07\x1b\\",
+			"caf\u00e9 \u2764\x1bD\x1bE\x1bH",
+		}
+
+		parse := func(data []byte, utf8 bool) []Event {
+			var c collectEvents
+
+			pr, err := NewParser(nil, &c)
+			require.NoError(t, err)
+
+			pr.SetUTF8(utf8)
+
+			_, err = pr.Write(data)
+			require.NoError(t, err)
+
+			err = pr.Flush()
+			require.NoError(t, err)
+
+			return c.Events
+		}
+
+		encode := func(events []Event, eightBit, utf8 bool) []byte {
+			var out bytes.Buffer
+
+			enc := NewEncoder(&out)
+			enc.SetEightBit(eightBit)
+			enc.SetUTF8(utf8)
+
+			for _, ev := range events {
+				err := enc.Encode(ev)
+				require.NoError(t, err)
+			}
+
+			return out.Bytes()
+		}
+
+		modes := []struct{ eightBit, utf8 bool }{
+			{false, true},
+			{true, true},
+			{true, false},
+		}
+
+		for _, input := range inputs {
+			events := parse([]byte(input), true)
+
+			for _, mode := range modes {
+				// Latin-1 can't hold the heart
+				if !mode.utf8 && strings.Contains(input, "\u2764") {
+					continue
+				}
+
+				data := encode(events, mode.eightBit, mode.utf8)
+
+				again := parse(data, mode.utf8)
+				assert.Equal(t, events, again, "input: %q mode: %v", input, mode)
+
+				assert.Equal(t, data, encode(again, mode.eightBit, mode.utf8), "input: %q mode: %v", input, mode)
+			}
+		}
+	})
+
+	n.It("streams chunks back out", func(t *testing.T) {
+		var out bytes.Buffer
+
+		enc := NewEncoder(&out)
+
+		pr, err := NewParser(nil, enc)
+		require.NoError(t, err)
+
+		pr.SetStringStreaming("DCS", true)
+		pr.SetStringStreaming("APC", true)
+
+		for _, part := range []string{"a\x1bP0;1q#0", ";2~~\x1b\\", "\x1b_Gab", "c\x18b"} {
+			_, err = pr.Write([]byte(part))
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, "a\x1bP0;1q#0;2~~\x1b\\\x1b_Gab\x18b", out.String())
+	})
+
 	n.Meow()
 }
@@ -117,7 +117,7 @@ func unescape(s string) string {
 }
 
 func (tc *termcap) setupterm(name string) error {
-	cmd := exec.Command("infocmp", "-1", name)
+	cmd := exec.Command("infocmp", "-1", "-x", name)
 	output := &bytes.Buffer{}
 	cmd.Stdout = output
 
@@ -211,6 +211,8 @@ func LoadTerminfo(name string) (*terminfo.Terminfo, string, error) {
 	t.Blink = tc.getstr("blink")
 	t.Dim = tc.getstr("dim")
 	t.Reverse = tc.getstr("rev")
+	t.Italic = tc.getstr("sitm")
+	t.Invisible = tc.getstr("invis")
 	t.EnterKeypad = tc.getstr("smkx")
 	t.ExitKeypad = tc.getstr("rmkx")
 	t.SetFg = tc.getstr("setaf")
@@ -304,6 +306,11 @@ func LoadTerminfo(name string) (*terminfo.Terminfo, string, error) {
 	t.ExitAcs = tc.getstr("rmacs")
 	t.EnableAcs = tc.getstr("enacs")
 	t.Mouse = tc.getstr("kmous")
+	t.SetCursorStyle = tc.getstr("Ss")
+	t.ResetCursor = tc.getstr("Se")
+	t.SetULStyle = tc.getstr("Smulx")
+	t.SetULColor = tc.getstr("Setulc")
+	t.StrikeThrough = tc.getstr("smxx")
 	t.KeyShfRight = tc.getstr("kRIT")
 	t.KeyShfLeft = tc.getstr("kLFT")
 	t.KeyShfHome = tc.getstr("kHOM")
@@ -132,7 +132,7 @@ func unescape(s string) string {
 }
 
 func (tc *termcap) setupterm(name string) error {
-	cmd := exec.Command("infocmp", "-1", name)
+	cmd := exec.Command("infocmp", "-1", "-x", name)
 	output := &bytes.Buffer{}
 	cmd.Stdout = output
 
@@ -229,6 +229,8 @@ func getinfo(name string) (*terminfo.Terminfo, string, error) {
 	t.Blink = tc.getstr("blink")
 	t.Dim = tc.getstr("dim")
 	t.Reverse = tc.getstr("rev")
+	t.Italic = tc.getstr("sitm")
+	t.Invisible = tc.getstr("invis")
 	t.EnterKeypad = tc.getstr("smkx")
 	t.ExitKeypad = tc.getstr("rmkx")
 	t.SetFg = tc.getstr("setaf")
@@ -322,6 +324,11 @@ func getinfo(name string) (*terminfo.Terminfo, string, error) {
 	t.ExitAcs = tc.getstr("rmacs")
 	t.EnableAcs = tc.getstr("enacs")
 	t.Mouse = tc.getstr("kmous")
+	t.SetCursorStyle = tc.getstr("Ss")
+	t.ResetCursor = tc.getstr("Se")
+	t.SetULStyle = tc.getstr("Smulx")
+	t.SetULColor = tc.getstr("Setulc")
+	t.StrikeThrough = tc.getstr("smxx")
 	t.KeyShfRight = tc.getstr("kRIT")
 	t.KeyShfLeft = tc.getstr("kLFT")
 	t.KeyShfHome = tc.getstr("kHOM")
@@ -507,6 +514,8 @@ func dotGoInfo(w io.Writer, terms []*TData) {
 		dotGoAddStr(w, "Dim", t.Dim)
 		dotGoAddStr(w, "Blink", t.Blink)
 		dotGoAddStr(w, "Reverse", t.Reverse)
+		dotGoAddStr(w, "Italic", t.Italic)
+		dotGoAddStr(w, "Invisible", t.Invisible)
 		dotGoAddStr(w, "EnterKeypad", t.EnterKeypad)
 		dotGoAddStr(w, "ExitKeypad", t.ExitKeypad)
 		dotGoAddStr(w, "SetFg", t.SetFg)
@@ -520,6 +529,11 @@ func dotGoInfo(w io.Writer, terms []*TData) {
 		dotGoAddStr(w, "SetFgRGB", t.SetFgRGB)
 		dotGoAddStr(w, "SetBgRGB", t.SetBgRGB)
 		dotGoAddStr(w, "SetFgBgRGB", t.SetFgBgRGB)
+		dotGoAddStr(w, "SetCursorStyle", t.SetCursorStyle)
+		dotGoAddStr(w, "ResetCursor", t.ResetCursor)
+		dotGoAddStr(w, "SetULStyle", t.SetULStyle)
+		dotGoAddStr(w, "SetULColor", t.SetULColor)
+		dotGoAddStr(w, "StrikeThrough", t.StrikeThrough)
 		dotGoAddStr(w, "Mouse", t.Mouse)
 		dotGoAddStr(w, "MouseMode", t.MouseMode)
 		dotGoAddStr(w, "SetCursor", t.SetCursor)
@@ -59,6 +59,8 @@ type Terminfo struct {
 	Blink        string // blink
 	Reverse      string // rev
 	Dim          string // dim
+	Italic       string // sitm
+	Invisible    string // invis
 	EnterKeypad  string // smkx
 	ExitKeypad   string // rmkx
 	SetFg        string // setaf
@@ -169,6 +171,11 @@ type Terminfo struct {
 	SetFgBgRGB      string // setfgbgrgb
 	SetFgRGB        string // setfrgb
 	SetBgRGB        string // setbrgb
+	SetCursorStyle  string // Ss
+	ResetCursor     string // Se
+	SetULStyle      string // Smulx
+	SetULColor      string // Setulc
+	StrikeThrough   string // smxx
 	KeyShfUp        string // shift-up
 	KeyShfDown      string // shift-down
 	KeyCtrlUp       string // ctrl-up
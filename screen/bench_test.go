@@ -0,0 +1,60 @@
+package screen
+
+import (
+	"testing"
+
+	"github.com/lab47/vterm/internal/benchdata"
+	"github.com/lab47/vterm/parser"
+	"github.com/lab47/vterm/state"
+)
+
+type discardUpdates struct{}
+
+func (discardUpdates) DamageDone(r state.Rect, cr CellReader) error           { return nil }
+func (discardUpdates) MoveCursor(p state.Pos) error                           { return nil }
+func (discardUpdates) SetTermProp(attr state.TermAttr, val interface{}) error { return nil }
+func (discardUpdates) Output(data []byte) error                               { return nil }
+func (discardUpdates) StringEvent(kind string, data []byte) error             { return nil }
+
+func BenchmarkScreen(b *testing.B) {
+	corpora := benchdata.Corpora(1 << 20)
+
+	for _, name := range []string{"cat", "vim", "htop"} {
+		data := corpora[name]
+
+		b.Run(name, func(b *testing.B) {
+			scr, err := NewScreen(benchdata.Rows, benchdata.Cols, discardUpdates{})
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			st, err := state.NewState(benchdata.Rows, benchdata.Cols, scr)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			pr, err := parser.NewParser(nil, st)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				for start := 0; start < len(data); start += 4096 {
+					end := start + 4096
+					if end > len(data) {
+						end = len(data)
+					}
+
+					_, err := pr.Write(data[start:end])
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -1,5 +1,7 @@
 package screen
 
+import "github.com/lab47/vterm/state"
+
 type ScreenCell struct {
 	val   rune
 	pen   *ScreenPen
@@ -57,6 +59,7 @@ type line struct {
 
 	used         int
 	continuation bool
+	size         state.LineSize
 }
 
 func (l *line) Len() int {
@@ -94,6 +97,31 @@ type Buffer struct {
 	lines      []*line
 }
 
+// resize makes the buffer +rows+ by +cols+, adding blank rows or dropping
+// them at the bottom, and cutting off or padding each row.
+func (b *Buffer) resize(rows, cols int) {
+	for len(b.lines) < rows {
+		b.lines = append(b.lines, nil)
+	}
+
+	b.lines = b.lines[:rows]
+	b.rows, b.cols = rows, cols
+
+	for row := range b.lines {
+		line := b.getLine(row)
+
+		if len(line.cells) > cols {
+			line.cells = line.cells[:cols:cols]
+		} else {
+			line.resize(cols)
+		}
+
+		if line.used > cols {
+			line.used = cols
+		}
+	}
+}
+
 func (b *Buffer) getLine(row int) *line {
 	l := b.lines[row]
 	if l == nil {
@@ -137,20 +165,16 @@ func (b *Buffer) setCell(row, col int, cell ScreenCell) {
 }
 
 func (b *Buffer) moveInRow(row, start, dest, cols int) {
+	if cols <= 0 {
+		return
+	}
+
 	line := b.getLine(row)
 
-	if start < dest {
-		end := start + cols - 1
-		for i := dest + cols - 1; i >= dest; i-- {
-			line.cells[i] = line.cells[end]
-			end--
-		}
-	} else {
-		for i, cell := range line.cells[start : start+cols] {
-			line.cells[dest+i] = cell
-		}
+	copy(line.cells[dest:dest+cols], line.cells[start:start+cols])
 
-		line.used = start + cols
+	if used := dest + cols; line.used < used {
+		line.used = used
 	}
 }
 
@@ -170,6 +194,66 @@ func (b *Buffer) moveBetweenRows(row, rowDest, start, cols int) {
 	}
 }
 
+// rotateRows moves the cells of the rows between top and bottom up by
+// dist rows, or down if it's negative, and clears the rows uncovered. It's
+// the same as moveBetweenRows for whole rows, but swaps the rows' cells
+// rather than copying them.
+func (b *Buffer) rotateRows(top, bottom, dist int) {
+	if bottom >= len(b.lines) {
+		bottom = len(b.lines) - 1
+	}
+
+	n := bottom - top + 1
+	if top < 0 || n <= 0 || dist == 0 {
+		return
+	}
+
+	up := dist > 0
+	if !up {
+		dist = -dist
+	}
+
+	if dist > n {
+		dist = n
+	}
+
+	// Rotating down by dist is rotating up by n-dist
+	shift := dist
+	if !up {
+		shift = n - dist
+	}
+
+	b.reverseRows(top, top+shift-1)
+	b.reverseRows(top+shift, bottom)
+	b.reverseRows(top, bottom)
+
+	moved, cleared := top+dist, top
+	if up {
+		moved, cleared = top, bottom-dist+1
+	}
+
+	for row := moved; row < moved+n-dist; row++ {
+		line := b.getLine(row)
+		if line.used < b.cols {
+			line.used = b.cols
+		}
+	}
+
+	for row := cleared; row < cleared+dist; row++ {
+		line := b.getLine(row)
+		for i := range line.cells {
+			line.cells[i].reset(0, nil)
+		}
+	}
+}
+
+func (b *Buffer) reverseRows(top, bottom int) {
+	for ; top < bottom; top, bottom = top+1, bottom-1 {
+		a, z := b.getLine(top), b.getLine(bottom)
+		a.cells, z.cells = z.cells, a.cells
+	}
+}
+
 func (b *Buffer) eraseInRow(row, start, cols int) {
 	line := b.getLine(row)
 
@@ -177,3 +261,32 @@ func (b *Buffer) eraseInRow(row, start, cols int) {
 		line.cells[i].reset(0, nil)
 	}
 }
+
+// shiftLineSizes moves the size attribute of the rows between top and
+// bottom by dist rows, up when dist is positive. Rows uncovered by the
+// move go back to single size.
+func (b *Buffer) shiftLineSizes(top, bottom, dist int) {
+	if bottom >= len(b.lines) {
+		bottom = len(b.lines) - 1
+	}
+
+	if dist > 0 {
+		for row := top; row <= bottom; row++ {
+			size := state.LineSizeSingle
+			if row+dist <= bottom {
+				size = b.getLine(row + dist).size
+			}
+
+			b.getLine(row).size = size
+		}
+	} else {
+		for row := bottom; row >= top; row-- {
+			size := state.LineSizeSingle
+			if row+dist >= top {
+				size = b.getLine(row + dist).size
+			}
+
+			b.getLine(row).size = size
+		}
+	}
+}
@@ -0,0 +1,144 @@
+//go:build go1.18
+
+package screen
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lab47/vterm/internal/benchdata"
+	"github.com/lab47/vterm/parser"
+	"github.com/lab47/vterm/state"
+)
+
+// checkUpdates is an Updates that fails the test if the Screen reports
+// anything outside of itself, and reads every damaged cell the way a
+// renderer would.
+type checkUpdates struct {
+	discardUpdates
+
+	t   *testing.T
+	scr *Screen
+}
+
+func (u *checkUpdates) inBounds(p state.Pos) bool {
+	return p.Row >= 0 && p.Row < u.scr.rows && p.Col >= 0 && p.Col < u.scr.cols
+}
+
+func (u *checkUpdates) DamageDone(r state.Rect, cr CellReader) error {
+	if !u.inBounds(r.Start) || !u.inBounds(r.End) {
+		u.t.Fatalf("damage %+v is outside %dx%d", r, u.scr.rows, u.scr.cols)
+	}
+
+	for row := r.Start.Row; row <= r.End.Row; row++ {
+		cr.LineSize(row)
+
+		for col := r.Start.Col; col <= r.End.Col; col++ {
+			cr.GetCell(row, col).Value()
+		}
+	}
+
+	return nil
+}
+
+func (u *checkUpdates) MoveCursor(p state.Pos) error {
+	if !u.inBounds(p) {
+		u.t.Fatalf("cursor %+v is outside %dx%d", p, u.scr.rows, u.scr.cols)
+	}
+
+	return nil
+}
+
+// fuzzHandler passes events to State and checks the Screen after each one.
+type fuzzHandler struct {
+	t   *testing.T
+	st  *state.State
+	scr *Screen
+}
+
+func (h *fuzzHandler) HandleEvent(ev parser.Event) error {
+	// Errors are for sequences State doesn't handle, which is fine, so
+	// only panics and broken invariants fail.
+	h.st.HandleEvent(ev)
+
+	buf := h.scr.buffer
+
+	if buf.rows != h.scr.rows || buf.cols != h.scr.cols || len(buf.lines) != h.scr.rows {
+		h.t.Fatalf("buffer is %dx%d with %d lines on a %dx%d screen after %#v",
+			buf.rows, buf.cols, len(buf.lines), h.scr.rows, h.scr.cols, ev)
+	}
+
+	for row := range buf.lines {
+		line := buf.getLine(row)
+
+		if len(line.cells) != h.scr.cols || line.used > h.scr.cols {
+			h.t.Fatalf("row %d has %d cells, %d used, on a screen %d wide after %#v",
+				row, len(line.cells), line.used, h.scr.cols, ev)
+		}
+	}
+
+	return nil
+}
+
+// fuzzSize turns a fuzzed byte into a screen dimension between 1 and max.
+func fuzzSize(b byte, max int) int {
+	return 1 + int(b)%max
+}
+
+func FuzzScreen(f *testing.F) {
+	for _, data := range benchdata.Corpora(4096) {
+		f.Add(data, []byte{24, 80})
+	}
+
+	f.Add([]byte("\x1b[5;2r\x1b[100S\x1b[100T\x1b[999X\x1b[999P\x1b[999@"), []byte{10, 10, 3, 3})
+	f.Add([]byte("\x1b[3g\x1b[999I\x1b[999Z\t\x1b#6\x1b[?69h\x1b[5;2s"), []byte{5, 40, 40, 5})
+	f.Add([]byte("\x1b[?2026h\x1b[29;70Hx\x1b[?7l0123456789\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"), []byte{29, 80, 4, 9})
+
+	f.Fuzz(func(t *testing.T, data []byte, sizes []byte) {
+		rows, cols := 24, 80
+		if len(sizes) >= 2 {
+			rows, cols = fuzzSize(sizes[0], 64), fuzzSize(sizes[1], 160)
+			sizes = sizes[2:]
+		}
+
+		updates := &checkUpdates{t: t}
+
+		scr, err := NewScreen(rows, cols, updates)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		updates.scr = scr
+
+		// Synchronized updates are only ended by the data, never by a
+		// timer firing in the middle of the checks.
+		scr.SetSyncTimeout(time.Hour)
+
+		st, err := state.NewState(rows, cols, scr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pr, err := parser.NewParser(nil, &fuzzHandler{t: t, st: st, scr: scr})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Each pair of sizes is a resize, spread evenly through the data
+		steps := len(sizes)/2 + 1
+
+		for i := 0; i < steps; i++ {
+			pr.Write(data[len(data)*i/steps : len(data)*(i+1)/steps])
+
+			if i < steps-1 {
+				pr.Resize(context.Background(), fuzzSize(sizes[2*i], 64), fuzzSize(sizes[2*i+1], 160))
+			}
+		}
+
+		pr.Flush()
+
+		// End any synchronized update, which reports the damage held back
+		scr.SetTermProp(state.TermAttrSyncUpdate, false)
+	})
+}
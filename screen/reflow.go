@@ -12,10 +12,16 @@ func (s *Screen) Resize(rows, cols int, lines []state.LineInfo) error {
 
 	// buf := NewBuffer(rows, cols)
 
+	oldRows := s.rows
+	if oldRows > len(lines) {
+		oldRows = len(lines)
+	}
+
 	if cols > s.cols {
 		diff := cols - s.cols
 
-		for row := 0; row < s.rows; row++ {
+		// The first row has nothing to continue
+		for row := 1; row < oldRows; row++ {
 			if !lines[row].Continuation {
 				continue
 			}
@@ -25,18 +31,21 @@ func (s *Screen) Resize(rows, cols int, lines []state.LineInfo) error {
 
 			tgt.resize(cols)
 
-			for i, j := 0, s.cols; i < diff; i, j = i+1, j+1 {
-				tgt.cells[j] = src.cells[i]
+			n := diff
+			if n > len(src.cells) {
+				n = len(src.cells)
 			}
 
-			src.cells = src.cells[diff:]
+			copy(tgt.cells[s.cols:], src.cells[:n])
+
+			src.cells = src.cells[n:]
 		}
 	} else if s.cols > cols {
 		// diff := cols - s.cols
 
 		var prepend []ScreenCell
 
-		for row := 0; row < s.rows; row++ {
+		for row := 0; row < oldRows; row++ {
 			src := s.buffer.getLine(row)
 
 			if len(prepend) > 0 {
@@ -53,13 +62,17 @@ func (s *Screen) Resize(rows, cols int, lines []state.LineInfo) error {
 			}
 
 			l := src.Len()
+			if l > len(src.cells) {
+				l = len(src.cells)
+			}
 
 			if l <= cols {
 				continue
 			}
 
 			prepend = src.cells[cols:l]
-			src.cells = src.cells[:cols]
+			src.cells = src.cells[:cols:cols]
+			src.used = cols
 		}
 	}
 
@@ -102,5 +115,8 @@ func (s *Screen) Resize(rows, cols int, lines []state.LineInfo) error {
 	s.cols = cols
 	// s.buffer = buf
 
+	s.buffer.resize(rows, cols)
+	s.resetHeldDamage()
+
 	return nil
 }
@@ -12,15 +12,27 @@ import (
 func TestScreenReflow(t *testing.T) {
 	n := neko.Modern(t)
 
+	// printScr puts +data+ on the screen the way printing it does, which
+	// keeps track of how much of each row is used.
+	printScr := func(scr *Screen, row, col int, data string) {
+		for _, r := range data {
+			var cell ScreenCell
+			cell.reset(r, nil)
+
+			scr.setCell(row, col, cell)
+			col++
+		}
+	}
+
 	n.It("can resize the cells by reflowing them to a wider view", func(t *testing.T) {
 		var sink sinkOps
 
 		screen, err := NewScreen(5, 80, &sink)
 		require.NoError(t, err)
 
-		screen.getCell(2, 0).reset('b', nil)
-		screen.getCell(2, 20).reset('c', nil)
-		screen.getCell(3, 0).reset('d', nil)
+		printScr(screen, 2, 0, "b")
+		printScr(screen, 2, 20, "c")
+		printScr(screen, 3, 0, "d")
 
 		lineInfo := make([]state.LineInfo, 25)
 
@@ -40,9 +52,9 @@ func TestScreenReflow(t *testing.T) {
 		screen, err := NewScreen(5, 80, &sink)
 		require.NoError(t, err)
 
-		screen.getCell(2, 0).reset('b', nil)
-		screen.getCell(2, 70).reset('c', nil)
-		screen.getCell(3, 0).reset('d', nil)
+		printScr(screen, 2, 0, "b")
+		printScr(screen, 2, 70, "c")
+		printScr(screen, 3, 0, "d")
 
 		lineInfo := make([]state.LineInfo, 25)
 
@@ -62,9 +74,9 @@ func TestScreenReflow(t *testing.T) {
 		screen, err := NewScreen(5, 80, &sink)
 		require.NoError(t, err)
 
-		screen.getCell(2, 0).reset('b', nil)
-		screen.getCell(2, 70).reset('c', nil)
-		screen.getCell(3, 0).reset('d', nil)
+		printScr(screen, 2, 0, "b")
+		printScr(screen, 2, 70, "c")
+		printScr(screen, 3, 0, "d")
 
 		lineInfo := make([]state.LineInfo, 25)
 
@@ -76,13 +88,6 @@ func TestScreenReflow(t *testing.T) {
 		assert.Equal(t, 'd', screen.getCell(4, 0).val)
 	})
 
-	printScr := func(scr *Screen, row, col int, data string) {
-		for _, r := range data {
-			scr.buffer.getCell(row, col).reset(r, nil)
-			col++
-		}
-	}
-
 	readScr := func(scr *Screen, row, col, sz int) string {
 		var s string
 
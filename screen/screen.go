@@ -4,13 +4,19 @@ import (
 	"bytes"
 	"errors"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/lab47/vterm/state"
 )
 
 type CellReader interface {
 	GetCell(row, col int) *ScreenCell
+
+	// LineSize returns the size attribute of the row. Rows that are
+	// double width only use the first half of their cells.
+	LineSize(row int) state.LineSize
 }
 
 type Updates interface {
@@ -25,21 +31,55 @@ type ScrollBack interface {
 	AddScrollBack(row []rune) error
 }
 
+// ScrollBackSnapshotter can optionally be implemented by a ScrollBack to
+// have the lines it keeps included in the Screen's snapshots.
+type ScrollBackSnapshotter interface {
+	// ScrollBackLines returns the lines kept, oldest first.
+	ScrollBackLines() [][]rune
+
+	// RestoreScrollBack replaces the lines kept with those of a snapshot.
+	RestoreScrollBack(lines [][]rune) error
+}
+
 type Screen struct {
 	rows, cols int
 
-	pen *ScreenPen
+	pen  *ScreenPen
+	pens map[state.PenState]*ScreenPen
 
 	buffers []*Buffer
 	buffer  *Buffer
 
 	mu sync.Mutex
-
-	updates Updates
-	scroll  ScrollBack
+	tx Tx
+
+	updates  Updates
+	scroll   ScrollBack
+	notifier state.Notifier
+	window   state.Window
+	receiver state.StringReceiver
+	changes  state.ChangeReceiver
+
+	syncMu      sync.Mutex
+	syncing     bool
+	syncDamage  *state.Rect
+	syncTimer   *time.Timer
+	syncTimeout time.Duration
+
+	// Counts the synchronized updates, so a timer can tell if the one it
+	// was started for is still going.
+	syncGen uint64
 }
 
-var _ state.Output = &Screen{}
+var (
+	_ state.Output         = &Screen{}
+	_ state.Notifier       = &Screen{}
+	_ state.Window         = &Screen{}
+	_ state.LineSizer      = &Screen{}
+	_ state.Resetter       = &Screen{}
+	_ state.StringReceiver = &Screen{}
+	_ state.ChangeReceiver = &Screen{}
+)
 
 func NewScreen(rows, cols int, updates Updates) (*Screen, error) {
 	screen := &Screen{
@@ -49,12 +89,30 @@ func NewScreen(rows, cols int, updates Updates) (*Screen, error) {
 
 		buffer: NewBuffer(rows, cols),
 		pen:    &ScreenPen{},
+
+		syncTimeout: DefaultSyncTimeout,
 	}
 
 	if sb, ok := updates.(ScrollBack); ok {
 		screen.scroll = sb
 	}
 
+	if n, ok := updates.(state.Notifier); ok {
+		screen.notifier = n
+	}
+
+	if w, ok := updates.(state.Window); ok {
+		screen.window = w
+	}
+
+	if sr, ok := updates.(state.StringReceiver); ok {
+		screen.receiver = sr
+	}
+
+	if cr, ok := updates.(state.ChangeReceiver); ok {
+		screen.changes = cr
+	}
+
 	return screen, nil
 }
 
@@ -102,6 +160,10 @@ func (cr cellReader) GetCell(row, col int) *ScreenCell {
 	return cr.s.buffer.getCell(row, col)
 }
 
+func (cr cellReader) LineSize(row int) state.LineSize {
+	return cr.s.buffer.getLine(row).size
+}
+
 func (s *Screen) getCell(row, col int) *ScreenCell {
 	return s.buffer.getCell(row, col)
 }
@@ -129,6 +191,46 @@ func (s *Screen) RowString(row int) string {
 	return buf.String()
 }
 
+// Size returns the number of rows and columns.
+func (s *Screen) Size() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rows, s.cols
+}
+
+// Text returns the text of each row, with blank cells as spaces and the
+// spaces at the end of the row removed.
+func (s *Screen) Text() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf bytes.Buffer
+
+	lines := make([]string, s.rows)
+
+	for row := range lines {
+		buf.Reset()
+
+		for _, cell := range s.buffer.getLine(row).cells[:s.cols] {
+			if cell.val == 0 {
+				buf.WriteByte(' ')
+				continue
+			}
+
+			buf.WriteRune(cell.val)
+
+			for _, r := range cell.extra {
+				buf.WriteRune(r)
+			}
+		}
+
+		lines[row] = strings.TrimRight(buf.String(), " ")
+	}
+
+	return lines
+}
+
 var ErrOutOfBounds = errors.New("position of out bounds")
 
 func (s *Screen) GetCell(row, col int) *ScreenCell {
@@ -142,11 +244,43 @@ func (s *Screen) GetCell(row, col int) *ScreenCell {
 	return s.getCell(row, col)
 }
 
+// LineSize returns the size attribute of the given row.
+func (s *Screen) LineSize(row int) state.LineSize {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if row < 0 || row >= s.rows {
+		return state.LineSizeSingle
+	}
+
+	return s.buffer.getLine(row).size
+}
+
+func (s *Screen) SetLineSize(row int, size state.LineSize) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if row < 0 || row >= s.rows {
+		return nil
+	}
+
+	s.buffer.getLine(row).size = size
+
+	return s.damageRect(state.Rect{
+		Start: state.Pos{Row: row, Col: 0},
+		End:   state.Pos{Row: row, Col: s.cols - 1},
+	})
+}
+
 func (s *Screen) damagePos(p state.Pos) error {
 	return s.damageRect(state.Rect{Start: p, End: p})
 }
 
 func (s *Screen) damageRect(r state.Rect) error {
+	if s.holdDamage(r) {
+		return nil
+	}
+
 	return s.updates.DamageDone(r, cellReader{s})
 }
 
@@ -197,11 +331,13 @@ func (s *Screen) ClearRect(r state.Rect) error {
 }
 
 func (s *Screen) slideRectRight(r state.Rect, dist int) error {
+	cols := r.End.Col - r.Start.Col + 1
+
 	for row := r.Start.Row; row <= r.End.Row; row++ {
 		start := r.Start.Col
 		dest := r.Start.Col + dist
 
-		s.buffer.moveInRow(row, start, dest, dist)
+		s.buffer.moveInRow(row, start, dest, cols)
 		s.buffer.eraseInRow(row, start, dist)
 	}
 
@@ -209,18 +345,25 @@ func (s *Screen) slideRectRight(r state.Rect, dist int) error {
 }
 
 func (s *Screen) slideRectLeft(r state.Rect, dist int) error {
+	cols := r.End.Col - r.Start.Col + 1
+
 	for row := r.Start.Row; row <= r.End.Row; row++ {
 		start := r.Start.Col
 		dest := r.Start.Col - dist
 
-		s.buffer.moveInRow(row, start, dest, dist)
-		s.buffer.eraseInRow(row, start, dist)
+		s.buffer.moveInRow(row, start, dest, cols)
+		s.buffer.eraseInRow(row, r.End.Col-dist+1, dist)
 	}
 
 	return nil
 }
 
 func (s *Screen) slideRectDown(r state.Rect, dist int) error {
+	if r.Start.Col == 0 && r.End.Col >= s.cols-1 {
+		s.buffer.rotateRows(r.Start.Row, r.End.Row+dist, -dist)
+		return nil
+	}
+
 	cols := r.End.Col - r.Start.Col + 1
 
 	for row := r.End.Row; row >= r.Start.Row; row-- {
@@ -245,13 +388,18 @@ func (s *Screen) slideRectUp(r state.Rect, dist int) error {
 		}
 	}
 
+	if r.Start.Col == 0 && r.End.Col >= s.cols-1 {
+		s.buffer.rotateRows(r.Start.Row-dist, r.End.Row, dist)
+		return nil
+	}
+
 	cols := r.End.Col - r.Start.Col + 1
 
 	for row := r.Start.Row; row <= r.End.Row; row++ {
 		s.buffer.moveBetweenRows(row, row-dist, r.Start.Col, cols)
 	}
 
-	for row := r.End.Row; row < r.End.Row+dist; row++ {
+	for row := r.End.Row - dist + 1; row <= r.End.Row; row++ {
 		s.buffer.eraseInRow(row, r.Start.Col, cols)
 	}
 
@@ -299,6 +447,15 @@ func (s *Screen) ScrollRect(r state.ScrollRect) error {
 		return nil
 	}
 
+	if r.Start.Col == 0 && r.End.Col >= s.cols-1 {
+		switch r.Direction {
+		case state.ScrollUp:
+			s.buffer.shiftLineSizes(r.Start.Row, r.End.Row, r.Distance)
+		case state.ScrollDown:
+			s.buffer.shiftLineSizes(r.Start.Row, r.End.Row, -r.Distance)
+		}
+	}
+
 	return s.damageRect(r.Rect)
 }
 
@@ -307,14 +464,142 @@ func (s *Screen) Output(data []byte) error {
 }
 
 func (s *Screen) SetTermProp(prop state.TermAttr, val interface{}) error {
+	if prop == state.TermAttrSyncUpdate {
+		on, _ := val.(bool)
+
+		err := s.setSync(on)
+		if err != nil {
+			return err
+		}
+	}
+
 	return s.updates.SetTermProp(prop, val)
 }
 
 func (s *Screen) SetPenProp(prop state.PenAttr, val interface{}, ps state.PenState) error {
-	s.pen = &ScreenPen{PenState: ps}
+	s.pen = s.penFor(ps)
 	return nil
 }
 
+// Change takes the place of SetTermProp and SetPenProp. Changes to the
+// terminal are passed on to the Updates, typed if it implements
+// state.ChangeReceiver too.
+func (s *Screen) Change(c state.Change) error {
+	switch c := c.(type) {
+	case *state.PenChange:
+		s.pen = s.penFor(c.Pen)
+		return nil
+	case *state.SyncUpdateChange:
+		err := s.setSync(c.On)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.changes != nil {
+		return s.changes.Change(c)
+	}
+
+	if tc, ok := c.(state.TermChange); ok {
+		return s.updates.SetTermProp(tc.TermProp())
+	}
+
+	return nil
+}
+
+// maxPens bounds the pens kept by penFor.
+const maxPens = 256
+
+// penFor returns the pen for a state. Cells hold on to their pen, so they
+// are never changed once made, and the same ones are handed out again
+// rather than allocating a new one every time the attributes change.
+func (s *Screen) penFor(ps state.PenState) *ScreenPen {
+	if pen, ok := s.pens[ps]; ok {
+		return pen
+	}
+
+	if s.pens == nil || len(s.pens) >= maxPens {
+		s.pens = make(map[state.PenState]*ScreenPen)
+	}
+
+	pen := &ScreenPen{PenState: ps}
+	s.pens[ps] = pen
+
+	return pen
+}
+
 func (s *Screen) StringEvent(kind string, data []byte) error {
 	return s.updates.StringEvent(kind, data)
 }
+
+// HardReset throws away the buffer contents, dropping any line sizes and
+// pending synchronized update along with it.
+func (s *Screen) HardReset() error {
+	s.syncMu.Lock()
+	if s.syncTimer != nil {
+		s.syncTimer.Stop()
+		s.syncTimer = nil
+	}
+	s.syncing = false
+	s.syncDamage = nil
+	s.syncMu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.buffer = NewBuffer(s.rows, s.cols)
+	s.pen = &ScreenPen{}
+
+	return s.damageRect(state.Rect{
+		Start: state.Pos{Row: 0, Col: 0},
+		End:   state.Pos{Row: s.rows - 1, Col: s.cols - 1},
+	})
+}
+
+func (s *Screen) StringChunk(chunk state.StringChunk) error {
+	if s.receiver == nil {
+		return nil
+	}
+
+	return s.receiver.StringChunk(chunk)
+}
+
+func (s *Screen) StringOverflow(kind string, limit int) error {
+	if s.receiver == nil {
+		return nil
+	}
+
+	return s.receiver.StringOverflow(kind, limit)
+}
+
+func (s *Screen) Bell() error {
+	if s.notifier == nil {
+		return nil
+	}
+
+	return s.notifier.Bell()
+}
+
+func (s *Screen) Notify(n state.Notification) error {
+	if s.notifier == nil {
+		return nil
+	}
+
+	return s.notifier.Notify(n)
+}
+
+func (s *Screen) CellSize() (int, int) {
+	if s.window == nil {
+		return 0, 0
+	}
+
+	return s.window.CellSize()
+}
+
+func (s *Screen) RequestResize(rows, cols int) bool {
+	if s.window == nil {
+		return false
+	}
+
+	return s.window.RequestResize(rows, cols)
+}
@@ -1,7 +1,9 @@
 package screen
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/lab47/vterm/state"
 	"github.com/stretchr/testify/assert"
@@ -13,7 +15,7 @@ type sinkOps struct {
 	damaged []state.Rect
 }
 
-func (s *sinkOps) DamageDone(r state.Rect) error {
+func (s *sinkOps) DamageDone(r state.Rect, cr CellReader) error {
 	s.damaged = append(s.damaged, r)
 	return nil
 }
@@ -27,13 +29,35 @@ func (s *sinkOps) Output(b []byte) error {
 }
 
 func (s *sinkOps) SetTermProp(prop state.TermAttr, val interface{}) error {
-	panic("not implemented")
+	return nil
 }
 
 func (s *sinkOps) StringEvent(kind string, b []byte) error {
 	panic("not implemented")
 }
 
+// propOps records the terminal properties it's told about.
+type propOps struct {
+	sinkOps
+	attrs []state.TermAttr
+}
+
+func (p *propOps) SetTermProp(attr state.TermAttr, val interface{}) error {
+	p.attrs = append(p.attrs, attr)
+	return nil
+}
+
+// changeOps takes changes typed.
+type changeOps struct {
+	propOps
+	changes []string
+}
+
+func (c *changeOps) Change(ch state.Change) error {
+	c.changes = append(c.changes, fmt.Sprintf("%T", ch))
+	return nil
+}
+
 func TestScreen(t *testing.T) {
 	n := neko.Modern(t)
 
@@ -100,6 +124,31 @@ func TestScreen(t *testing.T) {
 		assert.Equal(t, 'b', screen.getCell(2, 0).val)
 	})
 
+	n.It("moves the whole rest of the region when scrolling sideways", func(t *testing.T) {
+		var sink sinkOps
+		screen, err := NewScreen(5, 10, &sink)
+		require.NoError(t, err)
+
+		for i, r := range "abcdefghij" {
+			screen.getCell(1, i).reset(r, nil)
+		}
+
+		rect := state.Rect{
+			Start: state.Pos{Row: 1, Col: 2},
+			End:   state.Pos{Row: 1, Col: 9},
+		}
+
+		err = screen.ScrollRect(rect.ScrollRight(3))
+		require.NoError(t, err)
+
+		assert.Equal(t, "ab...cdefg", screen.RowString(1))
+
+		err = screen.ScrollRect(rect.ScrollLeft(4))
+		require.NoError(t, err)
+
+		assert.Equal(t, "abdefg....", screen.RowString(1))
+	})
+
 	n.It("can scroll a region down", func(t *testing.T) {
 		var sink sinkOps
 		screen, err := NewScreen(25, 80, &sink)
@@ -196,5 +245,165 @@ func TestScreen(t *testing.T) {
 		assert.Equal(t, rune(0), screen.getCell(1, 3).val)
 	})
 
+	n.It("holds back damage during a synchronized update", func(t *testing.T) {
+		var sink sinkOps
+		screen, err := NewScreen(25, 80, &sink)
+		require.NoError(t, err)
+
+		err = screen.SetTermProp(state.TermAttrSyncUpdate, true)
+		require.NoError(t, err)
+
+		err = screen.SetCell(state.Pos{Row: 1, Col: 5}, state.CellRune{Rune: 'a', Width: 1})
+		require.NoError(t, err)
+
+		err = screen.SetCell(state.Pos{Row: 3, Col: 2}, state.CellRune{Rune: 'b', Width: 1})
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, len(sink.damaged))
+
+		err = screen.SetTermProp(state.TermAttrSyncUpdate, false)
+		require.NoError(t, err)
+
+		require.Equal(t, 1, len(sink.damaged))
+
+		assert.Equal(t, state.Rect{
+			Start: state.Pos{Row: 1, Col: 2},
+			End:   state.Pos{Row: 3, Col: 5},
+		}, sink.damaged[0])
+	})
+
+	n.It("flushes damage when a synchronized update times out", func(t *testing.T) {
+		var sink sinkOps
+		screen, err := NewScreen(25, 80, &sink)
+		require.NoError(t, err)
+
+		screen.SetSyncTimeout(time.Millisecond)
+
+		err = screen.SetTermProp(state.TermAttrSyncUpdate, true)
+		require.NoError(t, err)
+
+		err = screen.SetCell(state.Pos{Row: 1, Col: 5}, state.CellRune{Rune: 'a', Width: 1})
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			screen.mu.Lock()
+			defer screen.mu.Unlock()
+
+			return len(sink.damaged) == 1
+		}, time.Second, time.Millisecond)
+
+		// The update is over, so damage flows straight through again
+		err = screen.SetCell(state.Pos{Row: 2, Col: 5}, state.CellRune{Rune: 'a', Width: 1})
+		require.NoError(t, err)
+
+		screen.mu.Lock()
+		defer screen.mu.Unlock()
+
+		assert.Equal(t, 2, len(sink.damaged))
+	})
+
+	n.It("ignores the timer of a synchronized update that's over", func(t *testing.T) {
+		var sink sinkOps
+		screen, err := NewScreen(25, 80, &sink)
+		require.NoError(t, err)
+
+		err = screen.SetTermProp(state.TermAttrSyncUpdate, true)
+		require.NoError(t, err)
+
+		stale := screen.syncGen
+
+		err = screen.SetTermProp(state.TermAttrSyncUpdate, false)
+		require.NoError(t, err)
+
+		err = screen.SetTermProp(state.TermAttrSyncUpdate, true)
+		require.NoError(t, err)
+
+		err = screen.SetCell(state.Pos{Row: 1, Col: 5}, state.CellRune{Rune: 'a', Width: 1})
+		require.NoError(t, err)
+
+		// As if the first update's timer fired after Stop was too late
+		err = screen.endSync(stale)
+		require.NoError(t, err)
+
+		assert.True(t, screen.syncing)
+		assert.Equal(t, 0, len(sink.damaged))
+
+		err = screen.SetTermProp(state.TermAttrSyncUpdate, false)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, len(sink.damaged))
+	})
+
+	n.It("keeps line sizes with their rows", func(t *testing.T) {
+		var sink sinkOps
+		screen, err := NewScreen(5, 10, &sink)
+		require.NoError(t, err)
+
+		err = screen.SetLineSize(2, state.LineSizeDoubleWidth)
+		require.NoError(t, err)
+
+		assert.Equal(t, state.LineSizeDoubleWidth, screen.LineSize(2))
+
+		err = screen.ScrollRect(state.Rect{
+			Start: state.Pos{Row: 0, Col: 0},
+			End:   state.Pos{Row: 4, Col: 9},
+		}.ScrollUp(1))
+		require.NoError(t, err)
+
+		assert.Equal(t, state.LineSizeSingle, screen.LineSize(2))
+		assert.Equal(t, state.LineSizeDoubleWidth, screen.LineSize(1))
+
+		err = screen.ScrollRect(state.Rect{
+			Start: state.Pos{Row: 0, Col: 0},
+			End:   state.Pos{Row: 4, Col: 9},
+		}.ScrollDown(3))
+		require.NoError(t, err)
+
+		assert.Equal(t, state.LineSizeSingle, screen.LineSize(1))
+		assert.Equal(t, state.LineSizeDoubleWidth, screen.LineSize(4))
+	})
+
+	n.It("clears everything on a hard reset", func(t *testing.T) {
+		var sink sinkOps
+		screen, err := NewScreen(5, 10, &sink)
+		require.NoError(t, err)
+
+		err = screen.SetCell(state.Pos{Row: 1, Col: 1}, state.CellRune{Rune: 'a', Width: 1})
+		require.NoError(t, err)
+
+		err = screen.SetLineSize(1, state.LineSizeDoubleWidth)
+		require.NoError(t, err)
+
+		err = screen.HardReset()
+		require.NoError(t, err)
+
+		val, _ := screen.GetCell(1, 1).Value()
+		assert.Equal(t, rune(0), val)
+		assert.Equal(t, state.LineSizeSingle, screen.LineSize(1))
+	})
+
+	n.It("passes changes on typed or as properties", func(t *testing.T) {
+		var props propOps
+
+		screen, err := NewScreen(2, 5, &props)
+		require.NoError(t, err)
+
+		require.NoError(t, screen.Change(&state.TitleChange{Title: "hi"}))
+		require.NoError(t, screen.Change(&state.PenChange{Attr: state.PenAttrIntensity}))
+
+		assert.Equal(t, []state.TermAttr{state.TermAttrTitle}, props.attrs)
+
+		var changes changeOps
+
+		screen, err = NewScreen(2, 5, &changes)
+		require.NoError(t, err)
+
+		require.NoError(t, screen.Change(&state.TitleChange{Title: "hi"}))
+		require.NoError(t, screen.Change(&state.PenChange{Attr: state.PenAttrIntensity}))
+
+		assert.Equal(t, []string{"*state.TitleChange"}, changes.changes)
+		assert.Empty(t, changes.attrs)
+	})
+
 	n.Meow()
 }
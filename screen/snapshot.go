@@ -0,0 +1,244 @@
+package screen
+
+import (
+	"github.com/lab47/vterm/internal/snapshot"
+	"github.com/lab47/vterm/state"
+)
+
+const (
+	snapshotMagic   = "VTSC"
+	snapshotVersion = 2
+)
+
+// Snapshot returns the contents of the screen encoded as bytes, to be given
+// to Restore later. It's meant to be taken at the same time as a snapshot
+// of the State writing to the screen. Scrollback is kept by the Updates, and
+// is included if it implements ScrollBackSnapshotter.
+func (s *Screen) Snapshot() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Each pen is written once, and cells refer to it by its index. Index 0
+	// is for cells that have never been written and have no pen.
+	index := map[*ScreenPen]int{nil: 0}
+	pens := [][]byte{nil}
+
+	penIndex := func(pen *ScreenPen) (int, error) {
+		if i, ok := index[pen]; ok {
+			return i, nil
+		}
+
+		data, err := pen.MarshalBinary()
+		if err != nil {
+			return 0, err
+		}
+
+		index[pen] = len(pens)
+		pens = append(pens, data)
+
+		return index[pen], nil
+	}
+
+	body := &snapshot.Encoder{}
+
+	cur, err := penIndex(s.pen)
+	if err != nil {
+		return nil, err
+	}
+
+	body.Int(cur)
+
+	body.Uint(uint64(s.rows))
+	for row := 0; row < s.rows; row++ {
+		l := s.buffer.getLine(row)
+
+		body.Int(l.used)
+		body.Bool(l.continuation)
+		body.Int(int(l.size))
+
+		body.Uint(uint64(len(l.cells)))
+		for i := range l.cells {
+			cell := &l.cells[i]
+
+			pi, err := penIndex(cell.pen)
+			if err != nil {
+				return nil, err
+			}
+
+			body.Int(int(cell.val))
+			body.Int(pi)
+			encodeRunes(body, cell.extra)
+		}
+	}
+
+	// Added in version 2
+	var scrollback [][]rune
+	if sb, ok := s.scroll.(ScrollBackSnapshotter); ok {
+		scrollback = sb.ScrollBackLines()
+	}
+
+	body.Uint(uint64(len(scrollback)))
+	for _, line := range scrollback {
+		encodeRunes(body, line)
+	}
+
+	e := snapshot.NewEncoder(snapshotMagic, snapshotVersion)
+
+	e.Int(s.rows)
+	e.Int(s.cols)
+
+	e.Uint(uint64(len(pens) - 1))
+	for _, data := range pens[1:] {
+		e.Bytes(data)
+	}
+
+	return append(e.Data(), body.Data()...), nil
+}
+
+// Restore puts the contents of the screen back to how they were when +data+
+// was returned by Snapshot, including its size, and reports the whole
+// screen as damaged. The scrollback is passed to the Updates if it
+// implements ScrollBackSnapshotter, unless the snapshot is from before
+// scrollback was included. If +data+ can't be decoded, the screen is left
+// alone and an error returned.
+func (s *Screen) Restore(data []byte) error {
+	d, err := snapshot.NewDecoder(data, snapshotMagic, snapshotVersion)
+	if err != nil {
+		return err
+	}
+
+	rows := d.Int()
+	cols := d.Int()
+
+	pens := make([]state.PenState, d.Len()+1)
+	for i := 1; i < len(pens) && !d.Failed(); i++ {
+		err := pens[i].UnmarshalBinary(d.Bytes())
+		if err != nil {
+			d.Fail("pen %d: %s", i, err)
+		}
+	}
+
+	penAt := func(i int) int {
+		if i < 0 || i >= len(pens) {
+			d.Fail("unknown pen %d", i)
+			return 0
+		}
+
+		return i
+	}
+
+	cur := penAt(d.Int())
+
+	type cellPen struct {
+		cell *ScreenCell
+		pen  int
+	}
+
+	var (
+		lines    = make([]*line, d.Len())
+		cellPens []cellPen
+	)
+
+	if !d.Failed() && (rows < 1 || cols < 1 || len(lines) != rows) {
+		d.Fail("invalid size %dx%d with %d rows", rows, cols, len(lines))
+	}
+
+	for row := range lines {
+		l := &line{
+			used:         d.Int(),
+			continuation: d.Bool(),
+			size:         state.LineSize(d.Int()),
+			cells:        make([]ScreenCell, d.Len()),
+		}
+
+		if len(l.cells) != cols || l.used < 0 || l.used > cols {
+			d.Fail("row %d doesn't fit %d columns", row, cols)
+		}
+
+		for i := range l.cells {
+			cell := &l.cells[i]
+
+			cell.val = rune(d.Int())
+			cellPens = append(cellPens, cellPen{cell, penAt(d.Int())})
+			cell.extra = decodeRunes(d)
+		}
+
+		if d.Failed() {
+			break
+		}
+
+		lines[row] = l
+	}
+
+	var scrollback [][]rune
+	if d.Version >= 2 && !d.Failed() {
+		scrollback = make([][]rune, d.Len())
+		for i := range scrollback {
+			scrollback[i] = decodeRunes(d)
+		}
+	}
+
+	err = d.Err()
+	if err != nil {
+		return err
+	}
+
+	if sb, ok := s.scroll.(ScrollBackSnapshotter); ok && d.Version >= 2 {
+		err = sb.RestoreScrollBack(scrollback)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pen := func(i int) *ScreenPen {
+		if i == 0 {
+			return nil
+		}
+
+		return s.penFor(pens[i])
+	}
+
+	for _, cp := range cellPens {
+		cp.cell.pen = pen(cp.pen)
+	}
+
+	s.pen = pen(cur)
+	if s.pen == nil {
+		s.pen = &ScreenPen{}
+	}
+
+	s.rows = rows
+	s.cols = cols
+	s.buffer = &Buffer{rows: rows, cols: cols, lines: lines}
+
+	s.resetHeldDamage()
+
+	return s.damageRect(state.Rect{
+		Start: state.Pos{Row: 0, Col: 0},
+		End:   state.Pos{Row: rows - 1, Col: cols - 1},
+	})
+}
+
+func encodeRunes(e *snapshot.Encoder, rs []rune) {
+	e.Uint(uint64(len(rs)))
+	for _, r := range rs {
+		e.Int(int(r))
+	}
+}
+
+func decodeRunes(d *snapshot.Decoder) []rune {
+	n := d.Len()
+	if n == 0 {
+		return nil
+	}
+
+	rs := make([]rune, n)
+	for i := range rs {
+		rs[i] = rune(d.Int())
+	}
+
+	return rs
+}
@@ -0,0 +1,170 @@
+package screen
+
+import (
+	"testing"
+
+	"github.com/lab47/vterm/parser"
+	"github.com/lab47/vterm/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+// keepScrollBack keeps the scrollback and lets snapshots include it.
+type keepScrollBack struct {
+	discardUpdates
+	lines [][]rune
+}
+
+func (k *keepScrollBack) AddScrollBack(row []rune) error {
+	k.lines = append(k.lines, row)
+	return nil
+}
+
+func (k *keepScrollBack) ScrollBackLines() [][]rune {
+	return k.lines
+}
+
+func (k *keepScrollBack) RestoreScrollBack(lines [][]rune) error {
+	k.lines = lines
+	return nil
+}
+
+func TestSnapshot(t *testing.T) {
+	n := neko.Modern(t)
+
+	n.It("restores the cells, pens and line sizes", func(t *testing.T) {
+		var sink discardUpdates
+
+		scr, err := NewScreen(4, 10, &sink)
+		require.NoError(t, err)
+
+		st, err := state.NewState(4, 10, scr)
+		require.NoError(t, err)
+
+		p, err := parser.NewParser(nil, st)
+		require.NoError(t, err)
+
+		_, err = p.Write([]byte("he\x1b[1mllo\x1b[31m wo\u0301r\r\n\x1b#6ab\x1b[0m"))
+		require.NoError(t, err)
+		require.NoError(t, p.Flush())
+
+		data, err := scr.Snapshot()
+		require.NoError(t, err)
+
+		var ops sinkOps
+
+		restored, err := NewScreen(2, 3, &ops)
+		require.NoError(t, err)
+
+		require.NoError(t, restored.Restore(data))
+
+		rows, cols := restored.Size()
+		assert.Equal(t, 4, rows)
+		assert.Equal(t, 10, cols)
+
+		assert.Equal(t, scr.Text(), restored.Text())
+		assert.Equal(t, state.LineSizeDoubleWidth, restored.LineSize(1))
+
+		_, extra := restored.GetCell(0, 7).Value()
+		assert.Equal(t, []rune{0x301}, extra)
+
+		for _, pos := range [][2]int{{0, 0}, {0, 2}, {0, 6}, {1, 0}, {3, 0}} {
+			want, got := scr.GetCell(pos[0], pos[1]).Pen(), restored.GetCell(pos[0], pos[1]).Pen()
+
+			if want == nil {
+				assert.Nil(t, got)
+			} else {
+				require.NotNil(t, got)
+				assert.Equal(t, want.PenState, got.PenState)
+			}
+		}
+
+		// Cells with the same pen still share it
+		assert.True(t, restored.GetCell(0, 2).Pen() == restored.GetCell(0, 4).Pen())
+
+		assert.Equal(t, []state.Rect{{End: state.Pos{Row: 3, Col: 9}}}, ops.damaged)
+
+		again, err := restored.Snapshot()
+		require.NoError(t, err)
+
+		assert.Equal(t, data, again)
+	})
+
+	n.It("includes the scrollback", func(t *testing.T) {
+		var sb keepScrollBack
+
+		scr, err := NewScreen(2, 3, &sb)
+		require.NoError(t, err)
+
+		st, err := state.NewState(2, 3, scr)
+		require.NoError(t, err)
+
+		p, err := parser.NewParser(nil, st)
+		require.NoError(t, err)
+
+		_, err = p.Write([]byte("one\r\ntwo\r\nsix\r\nten"))
+		require.NoError(t, err)
+		require.NoError(t, p.Flush())
+
+		require.Equal(t, [][]rune{[]rune("one"), []rune("two")}, sb.lines)
+
+		data, err := scr.Snapshot()
+		require.NoError(t, err)
+
+		var other keepScrollBack
+
+		restored, err := NewScreen(2, 3, &other)
+		require.NoError(t, err)
+
+		require.NoError(t, restored.Restore(data))
+
+		assert.Equal(t, sb.lines, other.lines)
+		assert.Equal(t, scr.Text(), restored.Text())
+
+		again, err := restored.Snapshot()
+		require.NoError(t, err)
+
+		assert.Equal(t, data, again)
+
+		// A screen whose Updates doesn't keep scrollback skips it
+		var ops sinkOps
+
+		plain, err := NewScreen(1, 1, &ops)
+		require.NoError(t, err)
+
+		require.NoError(t, plain.Restore(data))
+		assert.Equal(t, scr.Text(), plain.Text())
+	})
+
+	n.It("leaves the screen alone when the snapshot is bad", func(t *testing.T) {
+		var sink discardUpdates
+
+		scr, err := NewScreen(2, 5, &sink)
+		require.NoError(t, err)
+
+		scr.SetPenProp(state.PenAttrIntensity, state.PenBold, state.PenState{})
+		scr.SetCell(state.Pos{Row: 1, Col: 1}, state.CellRune{Rune: 'x', Width: 1})
+
+		data, err := scr.Snapshot()
+		require.NoError(t, err)
+
+		var ops sinkOps
+
+		other, err := NewScreen(3, 3, &ops)
+		require.NoError(t, err)
+
+		for i := 0; i < len(data); i++ {
+			assert.Error(t, other.Restore(data[:i]))
+		}
+
+		assert.Error(t, other.Restore(append(data, 0)))
+
+		rows, cols := other.Size()
+		assert.Equal(t, 3, rows)
+		assert.Equal(t, 3, cols)
+		assert.Empty(t, ops.damaged)
+	})
+
+	n.Meow()
+}
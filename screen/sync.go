@@ -0,0 +1,147 @@
+package screen
+
+import (
+	"time"
+
+	"github.com/lab47/vterm/state"
+)
+
+// DefaultSyncTimeout is how long damage is held back while the application
+// has synchronized output (mode 2026) enabled. If the application doesn't
+// end the update by then, the damage is flushed anyway so that a crashed
+// or misbehaving program can't freeze the display.
+const DefaultSyncTimeout = 150 * time.Millisecond
+
+// SetSyncTimeout changes how long damage is held back during a
+// synchronized update.
+func (s *Screen) SetSyncTimeout(d time.Duration) {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	s.syncTimeout = d
+}
+
+// setSync starts a synchronized update, or ends one and flushes the damage
+// held back during it.
+func (s *Screen) setSync(on bool) error {
+	if on {
+		s.beginSync()
+		return nil
+	}
+
+	return s.flushSync()
+}
+
+func (s *Screen) beginSync() {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	if s.syncing {
+		return
+	}
+
+	s.syncing = true
+	s.syncGen++
+
+	// The timer can fire after the update it was started for has ended and
+	// another begun, so it only ends the update while it's still the one
+	// the Screen is waiting on.
+	gen := s.syncGen
+
+	s.syncTimer = time.AfterFunc(s.syncTimeout, func() {
+		s.endSync(gen)
+	})
+}
+
+// holdDamage records +r+ to be reported when the synchronized update ends.
+// It returns false if there is no update in progress.
+func (s *Screen) holdDamage(r state.Rect) bool {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	if !s.syncing {
+		return false
+	}
+
+	if s.syncDamage == nil {
+		// A copy, so that r doesn't escape and get allocated on every call
+		d := r
+		s.syncDamage = &d
+		return true
+	}
+
+	d := s.syncDamage
+
+	if r.Start.Row < d.Start.Row {
+		d.Start.Row = r.Start.Row
+	}
+
+	if r.Start.Col < d.Start.Col {
+		d.Start.Col = r.Start.Col
+	}
+
+	if r.End.Row > d.End.Row {
+		d.End.Row = r.End.Row
+	}
+
+	if r.End.Col > d.End.Col {
+		d.End.Col = r.End.Col
+	}
+
+	return true
+}
+
+// resetHeldDamage makes any damage held back cover the whole screen, for
+// when its size changed and the rect may no longer fit on it.
+func (s *Screen) resetHeldDamage() {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	if s.syncDamage == nil {
+		return
+	}
+
+	*s.syncDamage = state.Rect{
+		Start: state.Pos{Row: 0, Col: 0},
+		End:   state.Pos{Row: s.rows - 1, Col: s.cols - 1},
+	}
+}
+
+// flushSync ends the synchronized update and reports all the damage held
+// back during it as one rect.
+func (s *Screen) flushSync() error {
+	return s.endSync(0)
+}
+
+// endSync is flushSync for the timer of update +gen+, which does nothing
+// unless that update is still going. Passing 0 ends whatever update there
+// is.
+func (s *Screen) endSync(gen uint64) error {
+	s.syncMu.Lock()
+
+	if gen != 0 && (gen != s.syncGen || !s.syncing) {
+		s.syncMu.Unlock()
+		return nil
+	}
+
+	if s.syncTimer != nil {
+		s.syncTimer.Stop()
+		s.syncTimer = nil
+	}
+
+	s.syncing = false
+
+	damage := s.syncDamage
+	s.syncDamage = nil
+
+	s.syncMu.Unlock()
+
+	if damage == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.updates.DamageDone(*damage, cellReader{s})
+}
@@ -3,24 +3,48 @@ package screen
 import "github.com/lab47/vterm/state"
 
 type Tx struct {
-	s      *Screen
-	damage *state.Rect
+	s       *Screen
+	damage  state.Rect
+	damaged bool
 }
 
+var _ state.RunSetter = &Tx{}
+
 func (tx *Tx) SetCell(pos state.Pos, val state.CellRune) error {
 	tx.s.setCell(pos.Row, pos.Col, ScreenCell{val: val.Rune, pen: tx.s.pen})
-	if tx.damage == nil {
-		tx.damage = &state.Rect{Start: pos, End: pos}
-	} else {
-		if pos.Col > tx.damage.End.Col {
-			tx.damage.End.Col = pos.Col
-		}
-
-		if pos.Row > tx.damage.End.Row {
-			tx.damage.End.Row = pos.Row
-		}
+	tx.damagePos(pos)
+
+	return nil
+}
+
+// SetRun sets a run of cells on one row at once.
+func (tx *Tx) SetRun(pos state.Pos, text []byte) error {
+	if len(text) == 0 {
+		return nil
+	}
+
+	line := tx.s.buffer.getLine(pos.Row)
+
+	end := pos.Col + len(text)
+	if end > len(line.cells) {
+		end = len(line.cells)
+	}
+
+	for col := pos.Col; col < end; col++ {
+		line.cells[col] = ScreenCell{val: rune(text[col-pos.Col]), pen: tx.s.pen}
+	}
+
+	if end <= pos.Col {
+		return nil
+	}
+
+	if end > line.used {
+		line.used = end
 	}
 
+	tx.damagePos(pos)
+	tx.damagePos(state.Pos{Row: pos.Row, Col: end - 1})
+
 	return nil
 }
 
@@ -35,36 +59,43 @@ func (tx *Tx) AppendCell(pos state.Pos, r rune) error {
 		return err
 	}
 
-	if tx.damage == nil {
-		tx.damage = &state.Rect{Start: pos, End: pos}
-	} else {
-		if pos.Col > tx.damage.End.Col {
-			tx.damage.End.Col = pos.Col
-		}
+	tx.damagePos(pos)
+
+	return nil
+}
 
-		if pos.Row > tx.damage.End.Row {
-			tx.damage.End.Row = pos.Row
-		}
+func (tx *Tx) damagePos(pos state.Pos) {
+	if !tx.damaged {
+		tx.damage = state.Rect{Start: pos, End: pos}
+		tx.damaged = true
+		return
 	}
 
-	return nil
+	if pos.Col > tx.damage.End.Col {
+		tx.damage.End.Col = pos.Col
+	}
+
+	if pos.Row > tx.damage.End.Row {
+		tx.damage.End.Row = pos.Row
+	}
 }
 
 func (tx *Tx) Close() error {
 	tx.s.mu.Unlock()
 
-	if tx.damage == nil {
+	if !tx.damaged {
 		return nil
 	}
 
-	return tx.s.damageRect(*tx.damage)
+	return tx.s.damageRect(tx.damage)
 }
 
+// BeginTx locks the screen until the returned transaction is closed. The
+// Tx is reused by the next BeginTx, so it must not be kept after Close.
 func (s *Screen) BeginTx() state.ModifyTx {
 	s.mu.Lock()
 
-	var tx Tx
-	tx.s = s
+	s.tx = Tx{s: s}
 
-	return &tx
+	return &s.tx
 }
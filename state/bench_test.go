@@ -0,0 +1,63 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/lab47/vterm/internal/benchdata"
+	"github.com/lab47/vterm/parser"
+)
+
+// discardOutput is an Output that does nothing, so the benchmarks measure
+// just State.
+type discardOutput struct{}
+
+func (discardOutput) MoveCursor(pos Pos) error                                    { return nil }
+func (discardOutput) SetCell(pos Pos, val CellRune) error                         { return nil }
+func (d discardOutput) BeginTx() ModifyTx                                         { return d }
+func (discardOutput) AppendCell(pos Pos, r rune) error                            { return nil }
+func (discardOutput) ClearRect(r Rect) error                                      { return nil }
+func (discardOutput) ScrollRect(s ScrollRect) error                               { return nil }
+func (discardOutput) Output(data []byte) error                                    { return nil }
+func (discardOutput) SetTermProp(prop TermAttr, val interface{}) error            { return nil }
+func (discardOutput) SetPenProp(prop PenAttr, val interface{}, ps PenState) error { return nil }
+func (discardOutput) StringEvent(kind string, data []byte) error                  { return nil }
+func (discardOutput) Resize(rows, cols int, lines []LineInfo) error               { return nil }
+func (discardOutput) Close() error                                                { return nil }
+
+func BenchmarkState(b *testing.B) {
+	corpora := benchdata.Corpora(1 << 20)
+
+	for _, name := range []string{"cat", "vim", "htop"} {
+		data := corpora[name]
+
+		b.Run(name, func(b *testing.B) {
+			st, err := NewState(benchdata.Rows, benchdata.Cols, discardOutput{})
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			pr, err := parser.NewParser(nil, st)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				for start := 0; start < len(data); start += 4096 {
+					end := start + 4096
+					if end > len(data) {
+						end = len(data)
+					}
+
+					_, err := pr.Write(data[start:end])
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package state
+
+import "fmt"
+
+// Change is a change to a property of the terminal or of the pen. Each
+// kind of change is its own type, so an Output implementing ChangeReceiver
+// can switch on the type and get at the new value without guessing what it
+// is, as it has to with SetTermProp and SetPenProp.
+//
+// The State reuses the same value for each kind of change, so a receiver
+// that wants to keep one around has to copy it.
+type Change interface {
+	change()
+}
+
+// ChangeReceiver can optionally be implemented by an Output to be told
+// about changes as typed values. Change is then called instead of
+// SetTermProp and SetPenProp, which aren't called at all.
+type ChangeReceiver interface {
+	Change(c Change) error
+}
+
+// TermChange is a Change to a property of the terminal as a whole, which
+// is passed to SetTermProp if the Output doesn't implement ChangeReceiver.
+type TermChange interface {
+	Change
+
+	// TermProp returns the change as it's passed to SetTermProp.
+	TermProp() (TermAttr, interface{})
+}
+
+// ApplyChange passes +c+ on to +o+ with SetTermProp or SetPenProp, as the
+// State does for an Output that doesn't implement ChangeReceiver. It's
+// for receivers that pass changes on to something only having those.
+func ApplyChange(o Output, c Change) error {
+	switch c := c.(type) {
+	case *PenChange:
+		attr, val := c.PenProp()
+		return o.SetPenProp(attr, val, c.Pen)
+	case TermChange:
+		attr, val := c.TermProp()
+		return o.SetTermProp(attr, val)
+	default:
+		return fmt.Errorf("unknown change: %T", c)
+	}
+}
+
+// PenChange is sent when the pen changes. Attr is which attribute of the
+// pen changed, and Pen the whole pen after the change.
+type PenChange struct {
+	Attr PenAttr
+	Pen  PenState
+}
+
+// PenProp returns the change as it's passed to SetPenProp.
+func (c *PenChange) PenProp() (PenAttr, interface{}) {
+	p := &c.Pen
+
+	switch c.Attr {
+	case PenAttrIntensity:
+		return c.Attr, p.attrs & PenIntensity
+	case PenAttrUnderline:
+		return c.Attr, p.attrs & PenUnderline
+	case PenAttrStyle:
+		return c.Attr, p.attrs & PenStyle
+	case PenAttrWrapper:
+		return c.Attr, p.attrs & PenWrapper
+	case PenAttrReverse:
+		return c.Attr, p.attrs&PenReverse != 0
+	case PenAttrStrikethrough:
+		return c.Attr, p.attrs&PenStrikeThrough != 0
+	case PenAttrBlink:
+		return c.Attr, p.attrs&PenBlink != 0
+	case PenAttrConceal:
+		return c.Attr, p.attrs&PenConceal != 0
+	case PenAttrOverlined:
+		return c.Attr, p.attrs&PenOverlined != 0
+	case PenAttrFont:
+		return c.Attr, int(p.font)
+	case PenAttrFGColor:
+		return c.Attr, p.fgColor
+	case PenAttrBGColor:
+		return c.Attr, p.bgColor
+	case PenAttrUnderlineColor:
+		return c.Attr, p.ulColor
+	default:
+		return c.Attr, nil
+	}
+}
+
+// TitleChange is sent when the window title changes.
+type TitleChange struct {
+	Title string
+}
+
+// IconNameChange is sent when the icon name changes.
+type IconNameChange struct {
+	Name string
+}
+
+// ReverseVideoChange is sent when DECSCNM turns reverse video for the
+// whole screen on or off.
+type ReverseVideoChange struct {
+	On bool
+}
+
+// CursorShapeChange is sent when DECSCUSR changes the cursor shape.
+type CursorShapeChange struct {
+	Shape CursorShape
+}
+
+// CursorBlinkChange is sent when the cursor starts or stops blinking.
+type CursorBlinkChange struct {
+	Blink bool
+}
+
+// CursorVisibleChange is sent when the cursor is shown or hidden.
+type CursorVisibleChange struct {
+	Visible bool
+}
+
+// CursorColorChange is sent when OSC 12 or 112 changes the cursor color.
+type CursorColorChange struct {
+	Color Color
+}
+
+// MouseModeChange is sent when the application changes which mouse events
+// it wants: MouseNone, MouseClick, MouseDrag or MouseMove.
+type MouseModeChange struct {
+	Mode int
+}
+
+// AltScreenChange is sent when the alternate screen is switched to or
+// away from.
+type AltScreenChange struct {
+	On bool
+}
+
+// SyncUpdateChange is sent when a synchronized update starts or ends.
+type SyncUpdateChange struct {
+	On bool
+}
+
+// WorkingDirectoryChange is sent when OSC 7 reports the application's
+// working directory.
+type WorkingDirectoryChange struct {
+	Host, Path string
+}
+
+// OSCChange is sent for an OSC the State doesn't handle itself.
+type OSCChange struct {
+	Command int
+	Data    string
+}
+
+func (*PenChange) change()              {}
+func (*TitleChange) change()            {}
+func (*IconNameChange) change()         {}
+func (*ReverseVideoChange) change()     {}
+func (*CursorShapeChange) change()      {}
+func (*CursorBlinkChange) change()      {}
+func (*CursorVisibleChange) change()    {}
+func (*CursorColorChange) change()      {}
+func (*MouseModeChange) change()        {}
+func (*AltScreenChange) change()        {}
+func (*SyncUpdateChange) change()       {}
+func (*WorkingDirectoryChange) change() {}
+func (*OSCChange) change()              {}
+
+func (c *TitleChange) TermProp() (TermAttr, interface{}) {
+	return TermAttrTitle, c.Title
+}
+
+func (c *IconNameChange) TermProp() (TermAttr, interface{}) {
+	return TermAttrIconName, c.Name
+}
+
+func (c *ReverseVideoChange) TermProp() (TermAttr, interface{}) {
+	return TermAttrReverse, c.On
+}
+
+func (c *CursorShapeChange) TermProp() (TermAttr, interface{}) {
+	return TermAttrCursorShape, c.Shape
+}
+
+func (c *CursorBlinkChange) TermProp() (TermAttr, interface{}) {
+	return TermAttrBlink, c.Blink
+}
+
+func (c *CursorVisibleChange) TermProp() (TermAttr, interface{}) {
+	return TermAttrVisible, c.Visible
+}
+
+func (c *CursorColorChange) TermProp() (TermAttr, interface{}) {
+	return TermAttrCursorColor, c.Color
+}
+
+func (c *MouseModeChange) TermProp() (TermAttr, interface{}) {
+	return TermAttrMouse, c.Mode
+}
+
+func (c *AltScreenChange) TermProp() (TermAttr, interface{}) {
+	return TermAttrAltScreen, c.On
+}
+
+func (c *SyncUpdateChange) TermProp() (TermAttr, interface{}) {
+	return TermAttrSyncUpdate, c.On
+}
+
+// TermProp passes the host and path together, as a WorkingDirectoryChange.
+// The path is only meaningful on that host.
+func (c *WorkingDirectoryChange) TermProp() (TermAttr, interface{}) {
+	return TermAttrWorkingDirectory, *c
+}
+
+// TermProp passes the command and data as one string, "<command>;<data>".
+func (c *OSCChange) TermProp() (TermAttr, interface{}) {
+	return TermAttrOSC, fmt.Sprintf("%d;%s", c.Command, c.Data)
+}
+
+// changes holds the value the State sends for each kind of change, so that
+// sending one doesn't allocate.
+type changes struct {
+	pen       PenChange
+	title     TitleChange
+	iconName  IconNameChange
+	reverse   ReverseVideoChange
+	shape     CursorShapeChange
+	blink     CursorBlinkChange
+	visible   CursorVisibleChange
+	color     CursorColorChange
+	mouse     MouseModeChange
+	altScreen AltScreenChange
+	sync      SyncUpdateChange
+	cwd       WorkingDirectoryChange
+	osc       OSCChange
+}
+
+// sendChange passes +c+ to the Output, typed if it can take it.
+func (s *State) sendChange(c Change) error {
+	if s.changeReceiver != nil {
+		return s.changeReceiver.Change(c)
+	}
+
+	return ApplyChange(s.output, c)
+}
+
+// setPenProp sends a change to +attr+ of the current pen.
+func (s *State) setPenProp(attr PenAttr) error {
+	s.changes.pen = PenChange{Attr: attr, Pen: s.pen}
+	return s.sendChange(&s.changes.pen)
+}
+
+func (s *State) setTermTitle(title string) error {
+	s.changes.title = TitleChange{Title: title}
+	return s.sendChange(&s.changes.title)
+}
+
+func (s *State) setTermIconName(name string) error {
+	s.changes.iconName = IconNameChange{Name: name}
+	return s.sendChange(&s.changes.iconName)
+}
+
+func (s *State) setTermReverse(on bool) error {
+	s.changes.reverse = ReverseVideoChange{On: on}
+	return s.sendChange(&s.changes.reverse)
+}
+
+func (s *State) setTermCursorShape(shape CursorShape) error {
+	s.changes.shape = CursorShapeChange{Shape: shape}
+	return s.sendChange(&s.changes.shape)
+}
+
+func (s *State) setTermBlink(blink bool) error {
+	s.changes.blink = CursorBlinkChange{Blink: blink}
+	return s.sendChange(&s.changes.blink)
+}
+
+func (s *State) setTermVisible(visible bool) error {
+	s.changes.visible = CursorVisibleChange{Visible: visible}
+	return s.sendChange(&s.changes.visible)
+}
+
+func (s *State) setTermCursorColor(c Color) error {
+	s.changes.color = CursorColorChange{Color: c}
+	return s.sendChange(&s.changes.color)
+}
+
+func (s *State) setTermMouse(mode int) error {
+	s.changes.mouse = MouseModeChange{Mode: mode}
+	return s.sendChange(&s.changes.mouse)
+}
+
+func (s *State) setTermAltScreen(on bool) error {
+	s.changes.altScreen = AltScreenChange{On: on}
+	return s.sendChange(&s.changes.altScreen)
+}
+
+func (s *State) setTermSyncUpdate(on bool) error {
+	s.changes.sync = SyncUpdateChange{On: on}
+	return s.sendChange(&s.changes.sync)
+}
+
+func (s *State) setTermWorkingDirectory(host, path string) error {
+	s.changes.cwd = WorkingDirectoryChange{Host: host, Path: path}
+	return s.sendChange(&s.changes.cwd)
+}
+
+func (s *State) setTermOSC(command int, data string) error {
+	s.changes.osc = OSCChange{Command: command, Data: data}
+	return s.sendChange(&s.changes.osc)
+}
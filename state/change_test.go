@@ -0,0 +1,123 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/lab47/vterm/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+// changeSink takes changes typed, and fails the test if the old methods
+// are used.
+type changeSink struct {
+	opSink
+	t       *testing.T
+	changes []Change
+}
+
+func (c *changeSink) Change(ch Change) error {
+	// Copied, since the State reuses them
+	switch ch := ch.(type) {
+	case *PenChange:
+		cp := *ch
+		c.changes = append(c.changes, &cp)
+	case *TitleChange:
+		cp := *ch
+		c.changes = append(c.changes, &cp)
+	case *MouseModeChange:
+		cp := *ch
+		c.changes = append(c.changes, &cp)
+	case *WorkingDirectoryChange:
+		cp := *ch
+		c.changes = append(c.changes, &cp)
+	case *OSCChange:
+		cp := *ch
+		c.changes = append(c.changes, &cp)
+	default:
+		c.changes = append(c.changes, ch)
+	}
+
+	return nil
+}
+
+func (c *changeSink) SetTermProp(p TermAttr, val interface{}) error {
+	c.t.Errorf("SetTermProp called with a ChangeReceiver: %s", p)
+	return nil
+}
+
+func (c *changeSink) SetPenProp(p PenAttr, val interface{}, ps PenState) error {
+	c.t.Errorf("SetPenProp called with a ChangeReceiver: %s", p)
+	return nil
+}
+
+func TestChange(t *testing.T) {
+	n := neko.Modern(t)
+
+	n.It("sends typed changes to a ChangeReceiver", func(t *testing.T) {
+		sink := &changeSink{t: t}
+
+		state, err := NewState(5, 10, sink)
+		require.NoError(t, err)
+
+		p, err := parser.NewParser(nil, state)
+		require.NoError(t, err)
+
+		_, err = p.Write([]byte("\x1b]2;hello\x07\x1b[?1000h\x1b[1m\x1b[38;5;100m" +
+			"\x1b]7;file://box/tmp\x07\x1b]1337;foo\x07"))
+		require.NoError(t, err)
+		require.NoError(t, p.Flush())
+
+		require.Len(t, sink.changes, 6)
+
+		assert.Equal(t, &TitleChange{Title: "hello"}, sink.changes[0])
+		assert.Equal(t, &MouseModeChange{Mode: MouseClick}, sink.changes[1])
+
+		pc, ok := sink.changes[2].(*PenChange)
+		require.True(t, ok)
+		assert.Equal(t, PenAttrIntensity, pc.Attr)
+		assert.Equal(t, PenBold, pc.Pen.Attrs())
+
+		pc, ok = sink.changes[3].(*PenChange)
+		require.True(t, ok)
+		assert.Equal(t, PenAttrFGColor, pc.Attr)
+		assert.Equal(t, IndexColor{Index: 100}, pc.Pen.FGColor())
+
+		assert.Equal(t, &WorkingDirectoryChange{Host: "box", Path: "/tmp"}, sink.changes[4])
+		assert.Equal(t, &OSCChange{Command: 1337, Data: "foo"}, sink.changes[5])
+	})
+
+	n.It("passes changes to the old methods for other Outputs", func(t *testing.T) {
+		var sink opSink
+
+		changes := []Change{
+			&TitleChange{Title: "hello"},
+			&CursorVisibleChange{Visible: false},
+			&WorkingDirectoryChange{Host: "box", Path: "/tmp"},
+			&OSCChange{Command: 1337, Data: "foo"},
+			&PenChange{Attr: PenAttrUnderline, Pen: PenState{attrs: PenBold | PenUnderlineDouble}},
+			&PenChange{Attr: PenAttrReverse, Pen: PenState{attrs: PenReverse}},
+			&PenChange{Attr: PenAttrBGColor, Pen: PenState{bgColor: IndexColor{Index: 3}}},
+		}
+
+		for _, c := range changes {
+			require.NoError(t, ApplyChange(&sink, c))
+		}
+
+		assert.Equal(t, []prop{
+			{"title", "hello"},
+			{"visible", false},
+			{"workingdirectory", WorkingDirectoryChange{Host: "box", Path: "/tmp"}},
+			{"osc", "1337;foo"},
+		}, sink.termProps)
+
+		assert.Equal(t, []prop{
+			{"underline", PenUnderlineDouble},
+			{"reverse", true},
+			{"bgcolor", IndexColor{Index: 3}},
+		}, sink.penProps)
+	})
+
+	n.Meow()
+}
@@ -1,6 +1,79 @@
 package state
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Color is the color of text, its background or underline, or of the
+// cursor. It's an IndexColor, RGBColor or DefaultColor.
+type Color interface {
+	// RGB returns the color as it's drawn with palette +p+. DefaultColor is
+	// drawn as the default foreground, or the default background if +bg+
+	// is true.
+	RGB(p *Palette, bg bool) RGBColor
+
+	color()
+}
+
+// IndexColor is one of the 256 colors of the palette.
+type IndexColor struct {
+	Index int
+}
+
+func (i IndexColor) String() string {
+	if name, ok := NamedColors[i.Index]; ok {
+		return name
+	}
+
+	return ""
+}
+
+// RGB returns the palette's color, or the default color for an index
+// outside of it.
+func (i IndexColor) RGB(p *Palette, bg bool) RGBColor {
+	if i.Index < 0 || i.Index >= len(p.Colors) {
+		return DefaultColor{}.RGB(p, bg)
+	}
+
+	return p.Colors[i.Index]
+}
+
+// RGBColor is a color given directly, by its red, green and blue.
+type RGBColor struct {
+	Red, Green, Blue uint8
+}
+
+func (c RGBColor) RGB(p *Palette, bg bool) RGBColor {
+	return c
+}
+
+// Spec formats the color the way xterm replies to color queries, as
+// rgb:rrrr/gggg/bbbb.
+func (c RGBColor) Spec() string {
+	return fmt.Sprintf("rgb:%04x/%04x/%04x", uint16(c.Red)*257, uint16(c.Green)*257, uint16(c.Blue)*257)
+}
+
+// Hex formats the color as #rrggbb.
+func (c RGBColor) Hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", c.Red, c.Green, c.Blue)
+}
+
+// DefaultColor is whatever the terminal draws with when no color is set.
+type DefaultColor struct{}
+
+func (DefaultColor) RGB(p *Palette, bg bool) RGBColor {
+	if bg {
+		return p.Background
+	}
+
+	return p.Foreground
+}
+
+func (IndexColor) color()   {}
+func (RGBColor) color()     {}
+func (DefaultColor) color() {}
 
 var NamedColors = map[int]string{
 	0:  "black",
@@ -26,3 +99,60 @@ func init() {
 		NamedColors[i] = fmt.Sprintf("gray%d", 256-i)
 	}
 }
+
+// ParseColorSpec parses the X11 color specifications used by the color
+// OSCs, either rgb:r/g/b with 1 to 4 hex digits per component or the
+// older #rgb form with 1 to 4 digits per component. rgb: scales the digits
+// to the full range, "rgb:f/f/f" being white, where # takes them as the
+// high bits of each component, so "#fff" is 0xf0f0f0.
+func ParseColorSpec(spec string) (RGBColor, bool) {
+	var parts []string
+
+	hash := strings.HasPrefix(spec, "#")
+
+	switch {
+	case strings.HasPrefix(spec, "rgb:"):
+		parts = strings.Split(spec[4:], "/")
+		if len(parts) != 3 {
+			return RGBColor{}, false
+		}
+	case hash:
+		hex := spec[1:]
+		if len(hex) == 0 || len(hex)%3 != 0 || len(hex) > 12 {
+			return RGBColor{}, false
+		}
+
+		sz := len(hex) / 3
+		parts = []string{hex[:sz], hex[sz : sz*2], hex[sz*2:]}
+	default:
+		return RGBColor{}, false
+	}
+
+	var out [3]uint8
+
+	for i, p := range parts {
+		if len(p) < 1 || len(p) > 4 {
+			return RGBColor{}, false
+		}
+
+		v, err := strconv.ParseUint(p, 16, 16)
+		if err != nil {
+			return RGBColor{}, false
+		}
+
+		bits := 4 * uint(len(p))
+
+		switch {
+		case hash && bits >= 8:
+			out[i] = uint8(v >> (bits - 8))
+		case hash:
+			out[i] = uint8(v << (8 - bits))
+		default:
+			// Scale the value from however many digits were used to 8 bits
+			max := uint64(1)<<bits - 1
+			out[i] = uint8((v*255 + max/2) / max)
+		}
+	}
+
+	return RGBColor{Red: out[0], Green: out[1], Blue: out[2]}, true
+}
@@ -0,0 +1,92 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vektra/neko"
+)
+
+func TestColor(t *testing.T) {
+	n := neko.Modern(t)
+
+	n.It("resolves colors with a palette", func(t *testing.T) {
+		p := DefaultPalette
+
+		assert.Equal(t, RGBColor{Red: 0xcd}, IndexColor{Index: 1}.RGB(&p, false))
+		assert.Equal(t, RGBColor{Red: 0xff, Blue: 0xd7}, IndexColor{Index: 200}.RGB(&p, false))
+		assert.Equal(t, RGBColor{Red: 0x12, Green: 0x12, Blue: 0x12}, IndexColor{Index: 233}.RGB(&p, false))
+		assert.Equal(t, RGBColor{Red: 1, Green: 2, Blue: 3}, RGBColor{Red: 1, Green: 2, Blue: 3}.RGB(&p, false))
+
+		assert.Equal(t, p.Foreground, DefaultColor{}.RGB(&p, false))
+		assert.Equal(t, p.Background, DefaultColor{}.RGB(&p, true))
+		assert.Equal(t, p.Background, IndexColor{Index: 256}.RGB(&p, true))
+		assert.Equal(t, p.Background, p.Resolve(nil, true))
+	})
+
+	n.It("finds the nearest color of the palette", func(t *testing.T) {
+		p := DefaultPalette
+
+		assert.Equal(t, IndexColor{Index: 196}, p.Nearest256(RGBColor{Red: 0xff}))
+		assert.Equal(t, IndexColor{Index: 241}, p.Nearest256(RGBColor{Red: 100, Green: 100, Blue: 100}))
+		assert.Equal(t, IndexColor{Index: 9}, p.Nearest16(RGBColor{Red: 0xff}))
+		assert.Equal(t, IndexColor{Index: 8}, p.Nearest16(RGBColor{Red: 100, Green: 100, Blue: 100}))
+
+		// Differences in green stand out more, so this is closer to bright
+		// blue to the eye, though not by the plain distance to each.
+		assert.Equal(t, IndexColor{Index: 12}, p.Nearest16(RGBColor{Green: 0x50, Blue: 0xe0}))
+	})
+
+	n.It("sets and resets colors from the data of OSC 4 and 104", func(t *testing.T) {
+		p := DefaultPalette
+
+		assert.True(t, p.SetColors("1;#ff8000;2;?;300;#000;3;bad;4;rgb:0/0/0"))
+		assert.Equal(t, RGBColor{Red: 0xff, Green: 0x80}, p.Colors[1])
+		assert.Equal(t, DefaultPalette.Colors[2], p.Colors[2])
+		assert.Equal(t, DefaultPalette.Colors[3], p.Colors[3])
+		assert.Equal(t, RGBColor{}, p.Colors[4])
+
+		assert.False(t, p.SetColors("5;?"))
+
+		p.ResetColors(&DefaultPalette, "4;x")
+		assert.Equal(t, RGBColor{Red: 0xff, Green: 0x80}, p.Colors[1])
+		assert.Equal(t, DefaultPalette.Colors[4], p.Colors[4])
+
+		p.ResetColors(&DefaultPalette, "")
+		assert.Equal(t, DefaultPalette, p)
+	})
+
+	n.It("parses and formats color specifications", func(t *testing.T) {
+		for spec, c := range map[string]RGBColor{
+			"rgb:ff/80/0":        {Red: 0xff, Green: 0x80},
+			"rgb:ffff/8080/0000": {Red: 0xff, Green: 0x80},
+			"rgb:f/8/0":          {Red: 0xff, Green: 0x88},
+			"#102030":            {Red: 0x10, Green: 0x20, Blue: 0x30},
+			"#fff":               {Red: 0xf0, Green: 0xf0, Blue: 0xf0},
+			"#f80":               {Red: 0xf0, Green: 0x80},
+			"#123456789":         {Red: 0x12, Green: 0x45, Blue: 0x78},
+			"#10ff20ff30ff":      {Red: 0x10, Green: 0x20, Blue: 0x30},
+			"rgb:1000/2000/3000": {Red: 0x10, Green: 0x20, Blue: 0x30},
+		} {
+			got, ok := ParseColorSpec(spec)
+			assert.True(t, ok, spec)
+			assert.Equal(t, c, got, spec)
+		}
+
+		for _, spec := range []string{"", "red", "rgb:1/2", "rgb:12345/0/0", "#12", "rgb:g/0/0"} {
+			_, ok := ParseColorSpec(spec)
+			assert.False(t, ok, spec)
+		}
+
+		c := RGBColor{Red: 0x10, Green: 0x80, Blue: 0xff}
+
+		assert.Equal(t, "rgb:1010/8080/ffff", c.Spec())
+		assert.Equal(t, "#1080ff", c.Hex())
+
+		back, ok := ParseColorSpec(c.Hex())
+		assert.True(t, ok)
+		assert.Equal(t, c, back)
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,141 @@
+package state
+
+import (
+	"fmt"
+
+	"github.com/lab47/vterm/parser"
+)
+
+// CursorShape is the shape used to draw the cursor, as selected by DECSCUSR.
+type CursorShape int
+
+const (
+	CursorShapeDefault CursorShape = iota // whatever the terminal draws normally
+	CursorShapeBlock
+	CursorShapeUnderline
+	CursorShapeBar
+)
+
+//go:generate stringer -type=CursorShape
+
+// CursorStyle describes how the cursor should be drawn. Changes to it
+// are reported as a CursorShapeChange, CursorBlinkChange,
+// CursorVisibleChange and CursorColorChange.
+type CursorStyle struct {
+	Shape   CursorShape
+	Blink   bool
+	Visible bool
+
+	// Set via OSC 12, DefaultColor if the application hasn't picked one.
+	Color Color
+}
+
+// CursorStyle returns how the application wants the cursor to be drawn.
+func (s *State) CursorStyle() CursorStyle {
+	return s.cursorStyle
+}
+
+func (s *State) resetCursorStyle() {
+	s.cursorStyle = CursorStyle{
+		Visible: true,
+		Color:   DefaultColor{},
+	}
+}
+
+func (s *State) setCursorShape(ev *parser.CSIEvent) error {
+	var which int
+
+	if len(ev.Args) > 0 {
+		which = ev.Args[0]
+	}
+
+	var (
+		shape CursorShape
+		blink bool
+	)
+
+	switch which {
+	case 0:
+		shape = CursorShapeDefault
+	case 1, 2:
+		shape = CursorShapeBlock
+	case 3, 4:
+		shape = CursorShapeUnderline
+	case 5, 6:
+		shape = CursorShapeBar
+	default:
+		return nil
+	}
+
+	// The odd values are the blinking variants
+	if which%2 == 1 {
+		blink = true
+	}
+
+	s.cursorStyle.Shape = shape
+
+	err := s.setTermCursorShape(shape)
+	if err != nil {
+		return err
+	}
+
+	return s.setCursorBlink(blink)
+}
+
+// decscusr returns the DECSCUSR parameter that selects the style.
+func (c CursorStyle) decscusr() int {
+	var which int
+
+	switch c.Shape {
+	case CursorShapeBlock:
+		which = 1
+	case CursorShapeUnderline:
+		which = 3
+	case CursorShapeBar:
+		which = 5
+	default:
+		return 0
+	}
+
+	if !c.Blink {
+		which++
+	}
+
+	return which
+}
+
+func (s *State) setCursorBlink(blink bool) error {
+	s.cursorStyle.Blink = blink
+	return s.setTermBlink(blink)
+}
+
+func (s *State) setCursorVisible(visible bool) error {
+	s.cursorStyle.Visible = visible
+	return s.setTermVisible(visible)
+}
+
+// setCursorColor handles OSC 12, which sets the cursor color or queries
+// it when the data is "?".
+func (s *State) setCursorColor(data string) error {
+	if data == "?" {
+		// By default the cursor is drawn in the text's color
+		c := s.palette.Resolve(s.cursorStyle.Color, false)
+
+		return s.output.Output([]byte(fmt.Sprintf("%s12;%s%s", s.osc(), c.Spec(), s.st())))
+	}
+
+	c, ok := ParseColorSpec(data)
+	if !ok {
+		return nil
+	}
+
+	s.cursorStyle.Color = c
+
+	return s.setTermCursorColor(c)
+}
+
+// resetCursorColor handles OSC 112
+func (s *State) resetCursorColor() error {
+	s.cursorStyle.Color = DefaultColor{}
+	return s.setTermCursorColor(DefaultColor{})
+}
@@ -0,0 +1,182 @@
+//go:build go1.18
+
+package state
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lab47/vterm/internal/benchdata"
+	"github.com/lab47/vterm/parser"
+)
+
+// boundsOutput is an Output that fails the test if State asks for anything
+// outside the screen.
+type boundsOutput struct {
+	discardOutput
+
+	t          *testing.T
+	rows, cols int
+}
+
+func (o *boundsOutput) checkPos(what string, pos Pos) {
+	if pos.Row < 0 || pos.Row >= o.rows || pos.Col < 0 || pos.Col >= o.cols {
+		o.t.Fatalf("%s at %+v is outside %dx%d", what, pos, o.rows, o.cols)
+	}
+}
+
+func (o *boundsOutput) checkRect(what string, r Rect) {
+	if r.Start.Row > r.End.Row || r.Start.Col > r.End.Col {
+		o.t.Fatalf("%s %+v is inverted", what, r)
+	}
+
+	o.checkPos(what, r.Start)
+	o.checkPos(what, r.End)
+}
+
+func (o *boundsOutput) MoveCursor(pos Pos) error {
+	o.checkPos("cursor", pos)
+	return nil
+}
+
+func (o *boundsOutput) SetCell(pos Pos, val CellRune) error {
+	o.checkPos("cell", pos)
+	return nil
+}
+
+func (o *boundsOutput) BeginTx() ModifyTx {
+	return o
+}
+
+func (o *boundsOutput) AppendCell(pos Pos, r rune) error {
+	o.checkPos("appended cell", pos)
+	return nil
+}
+
+func (o *boundsOutput) ClearRect(r Rect) error {
+	o.checkRect("cleared rect", r)
+	return nil
+}
+
+func (o *boundsOutput) ScrollRect(s ScrollRect) error {
+	o.checkRect("scrolled rect", s.Rect)
+
+	if s.Distance < 0 {
+		o.t.Fatalf("scroll by %d", s.Distance)
+	}
+
+	return nil
+}
+
+func (o *boundsOutput) Resize(rows, cols int, lines []LineInfo) error {
+	if len(lines) < rows {
+		o.t.Fatalf("resized to %d rows with %d lines", rows, len(lines))
+	}
+
+	o.rows, o.cols = rows, cols
+	return nil
+}
+
+// fuzzHandler passes events to State and checks its invariants after
+// each one.
+type fuzzHandler struct {
+	t  *testing.T
+	st *State
+}
+
+func (h *fuzzHandler) HandleEvent(ev parser.Event) error {
+	st := h.st
+
+	// Errors are for sequences State doesn't handle, which is fine, so
+	// only panics and broken invariants fail. They're still passed on so
+	// the parser deals with them as it would with State as the handler.
+	err := st.HandleEvent(ev)
+
+	if st.cursor.Row < 0 || st.cursor.Row >= st.rows || st.cursor.Col < 0 || st.cursor.Col >= st.cols {
+		h.t.Fatalf("cursor %+v is outside %dx%d after %#v", st.cursor, st.rows, st.cols, ev)
+	}
+
+	top, bottom := st.scrollBounds()
+	if top < 0 || top > bottom || bottom >= st.rows {
+		h.t.Fatalf("scroll region %d-%d is outside %d rows after %#v", top, bottom, st.rows, ev)
+	}
+
+	return err
+}
+
+// fuzzSize turns a fuzzed byte into a screen dimension between 1 and max.
+func fuzzSize(b byte, max int) int {
+	return 1 + int(b)%max
+}
+
+func FuzzState(f *testing.F) {
+	for _, data := range benchdata.Corpora(4096) {
+		f.Add(data, []byte{24, 80})
+	}
+
+	f.Add([]byte("\x1b[5;2r\x1b[100S\x1b[100T\x1b[999X\x1b[999P\x1b[999@"), []byte{10, 10, 3, 3})
+	f.Add([]byte("\x1b[3g\x1b[999I\x1b[999Z\t\x1b#6\x1b[?69h\x1b[5;2s"), []byte{5, 40, 40, 5})
+
+	f.Fuzz(func(t *testing.T, data []byte, sizes []byte) {
+		rows, cols := 24, 80
+		if len(sizes) >= 2 {
+			rows, cols = fuzzSize(sizes[0], 64), fuzzSize(sizes[1], 160)
+			sizes = sizes[2:]
+		}
+
+		out := &boundsOutput{t: t, rows: rows, cols: cols}
+
+		st, err := NewState(rows, cols, out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pr, err := parser.NewParser(nil, &fuzzHandler{t: t, st: st})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Each pair of sizes is a resize, spread evenly through the data
+		steps := len(sizes)/2 + 1
+
+		for i := 0; i < steps; i++ {
+			pr.Write(data[len(data)*i/steps : len(data)*(i+1)/steps])
+
+			if i < steps-1 {
+				pr.Resize(context.Background(), fuzzSize(sizes[2*i], 64), fuzzSize(sizes[2*i+1], 160))
+			}
+		}
+
+		pr.Flush()
+	})
+}
+
+// FuzzParserState uses the State itself as the parser's handler, as
+// programs do. The data is written a byte at a time, so that an error
+// from State doesn't throw away the rest of it.
+func FuzzParserState(f *testing.F) {
+	for _, data := range benchdata.Corpora(4096) {
+		f.Add(data)
+	}
+
+	f.Add([]byte("\x1b[79999[38;2;200;100;0m\x1b[?1049h\x1bP$qm\x1b\\"))
+	f.Add([]byte("\x1b]4;1;?\x07\x1b[4:3m\x1b[58:2::1:2:3m\x1b[!p\x1b#6"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		st, err := NewState(24, 80, &boundsOutput{t: t, rows: 24, cols: 80})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pr, err := parser.NewParser(nil, st)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := range data {
+			pr.Write(data[i : i+1])
+		}
+
+		pr.Flush()
+	})
+}
@@ -0,0 +1,171 @@
+package state
+
+import (
+	"fmt"
+
+	"github.com/lab47/vterm/parser"
+)
+
+// TerminalModel is the kind of terminal State presents itself as to
+// applications.
+type TerminalModel int
+
+const (
+	ModelVT100 TerminalModel = iota // VT100 with the advanced video option
+	ModelVT220
+	ModelVT420
+	ModelXTerm
+)
+
+//go:generate stringer -type=TerminalModel
+
+// Identity controls how State answers the queries applications use to
+// find out what terminal they're talking to.
+type Identity struct {
+	Model TerminalModel
+
+	// Firmware version reported in the secondary device attributes.
+	Version int
+
+	// Reported in the tertiary device attributes, as 8 hex digits. VT420
+	// and later only.
+	UnitID string
+
+	// Reported by XTVERSION, nothing is sent if it's empty.
+	Name string
+
+	// Sent in response to ENQ, nothing is sent if it's empty.
+	Answerback string
+}
+
+// DefaultIdentity is used by NewState until SetIdentity is called.
+var DefaultIdentity = Identity{
+	Model:   ModelVT100,
+	Version: 100,
+	UnitID:  "00000000",
+	Name:    "vterm",
+}
+
+// Identity returns the identity State reports to applications.
+func (s *State) Identity() Identity {
+	return s.identity
+}
+
+// SetIdentity changes the identity State reports to applications.
+func (s *State) SetIdentity(id Identity) {
+	s.identity = id
+
+	if id.Model == ModelVT100 {
+		s.eightBitControls = false
+	}
+}
+
+// The primary device attributes, the service class followed by the
+// features we support.
+func (id Identity) primaryAttributes() string {
+	switch id.Model {
+	case ModelVT220:
+		return "?62;1;22c"
+	case ModelVT420, ModelXTerm:
+		return "?64;1;22c"
+	default:
+		return "?1;2c"
+	}
+}
+
+func (id Identity) terminalID() int {
+	switch id.Model {
+	case ModelVT220:
+		return 1
+	case ModelVT420, ModelXTerm:
+		return 41
+	default:
+		return 0
+	}
+}
+
+// The C1 controls used in replies. They are 7-bit escape sequences unless
+// the application asked for 8-bit ones with S8C1T, in which case they are
+// encoded to match the input, see SetUTF8.
+func (s *State) c1(b byte, sevenBit string) string {
+	switch {
+	case !s.eightBitControls:
+		return sevenBit
+	case s.utf8:
+		return string(rune(b))
+	default:
+		return string([]byte{b})
+	}
+}
+
+func (s *State) csi() string { return s.c1(0x9b, "\x1b[") }
+func (s *State) dcs() string { return s.c1(0x90, "\x1bP") }
+func (s *State) osc() string { return s.c1(0x9d, "\x1b]") }
+func (s *State) st() string  { return s.c1(0x9c, "\x1b\\") }
+
+// SetUTF8 tells State whether the application is talking UTF-8, which
+// should match the Parser. It is on by default. When it's off, 8-bit
+// controls in replies are sent as raw bytes rather than UTF-8 encoded.
+func (s *State) SetUTF8(enabled bool) {
+	s.utf8 = enabled
+}
+
+// setControlWidth handles S7C1T and S8C1T. A VT100 has no 8-bit controls
+// so it stays with 7-bit ones.
+func (s *State) setControlWidth(eightBit bool) {
+	if s.identity.Model == ModelVT100 {
+		eightBit = false
+	}
+
+	s.eightBitControls = eightBit
+}
+
+func (s *State) emitDeviceAttributes(ev *parser.CSIEvent) error {
+	if len(ev.Args) > 0 && ev.Args[0] != 0 {
+		return nil
+	}
+
+	return s.output.Output([]byte(s.csi() + s.identity.primaryAttributes()))
+}
+
+func (s *State) emitDeviceAttributes2(ev *parser.CSIEvent) error {
+	if len(ev.Args) > 0 && ev.Args[0] != 0 {
+		return nil
+	}
+
+	return s.output.Output([]byte(fmt.Sprintf("%s>%d;%d;0c", s.csi(), s.identity.terminalID(), s.identity.Version)))
+}
+
+func (s *State) emitDeviceAttributes3(ev *parser.CSIEvent) error {
+	if len(ev.Args) > 0 && ev.Args[0] != 0 {
+		return nil
+	}
+
+	switch s.identity.Model {
+	case ModelVT420, ModelXTerm:
+	default:
+		return nil
+	}
+
+	return s.output.Output([]byte(s.dcs() + "!|" + s.identity.UnitID + s.st()))
+}
+
+func (s *State) emitVersion(ev *parser.CSIEvent) error {
+	if len(ev.Args) > 0 && ev.Args[0] != 0 {
+		return nil
+	}
+
+	if s.identity.Name == "" {
+		return nil
+	}
+
+	return s.output.Output([]byte(s.dcs() + ">|" + s.identity.Name + s.st()))
+}
+
+func (s *State) emitAnswerback() error {
+	if s.identity.Answerback == "" {
+		return nil
+	}
+
+	return s.output.Output([]byte(s.identity.Answerback))
+}
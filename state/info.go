@@ -0,0 +1,111 @@
+package state
+
+import "sync"
+
+// Modes are the modes an application can turn on and off, as they stand.
+type Modes struct {
+	Insert          bool // IRM
+	Newline         bool // LNM, line feed also does a carriage return
+	AppCursor       bool // DECCKM, cursor keys send application sequences
+	Origin          bool // DECOM
+	Autowrap        bool // DECAWM
+	LeftRightMargin bool // DECLRMM
+	ReportFocus     bool // focus in and out are reported
+	BracketedPaste  bool // pasted text is wrapped in CSI 200~ and CSI 201~
+	AltScreen       bool // the alternate screen is showing
+	SyncUpdate      bool // a synchronized update is in progress
+	Reverse         bool // DECSCNM, the whole screen is in reverse video
+}
+
+// Info is what the State knows about the terminal at one moment. It's a
+// copy, so it doesn't change once returned.
+type Info struct {
+	Rows, Cols int
+
+	Cursor      Pos
+	CursorStyle CursorStyle
+
+	Modes Modes
+
+	// One of MouseNone, MouseClick, MouseDrag or MouseMove, and how mouse
+	// events are encoded: MouseX10, MouseUTF8, MouseSGR or MouseRXVT.
+	MouseMode     int
+	MouseProtocol int
+
+	// The first and last rows of the scroll region.
+	ScrollTop, ScrollBottom int
+
+	Title, IconName string
+
+	// As set by OSC 7, both empty if the application hasn't said.
+	Host, WorkingDirectory string
+
+	// As set by SetPalette. It's shared with other Infos and the State
+	// replaces rather than changes it, so it mustn't be changed.
+	Palette *Palette
+}
+
+// sharedInfo holds what Info returns. It's updated at the end of each
+// parser Write and after the State is changed directly, so it can be read
+// from other goroutines without waiting on, or holding up, the one
+// running the State.
+type sharedInfo struct {
+	mu   sync.Mutex
+	info Info
+}
+
+// Info returns what the State knows about the terminal, as of the end of
+// the last parser Write. Unlike the rest of the State, it's safe to call
+// from any goroutine, including from within the Output's methods.
+func (s *State) Info() Info {
+	s.info.mu.Lock()
+	defer s.info.mu.Unlock()
+
+	return s.info.info
+}
+
+// WriteDone updates what Info returns, once the events of a parser Write
+// have been handled. A State that's passed events some other way should
+// call it after them.
+func (s *State) WriteDone() error {
+	s.publish()
+	return nil
+}
+
+// publish updates what Info returns.
+func (s *State) publish() {
+	top, bottom := s.scrollBounds()
+
+	info := Info{
+		Rows:        s.rows,
+		Cols:        s.cols,
+		Cursor:      s.cursor,
+		CursorStyle: s.cursorStyle,
+		Modes: Modes{
+			Insert:          s.modes.insert,
+			Newline:         s.modes.newline,
+			AppCursor:       s.modes.cursor,
+			Origin:          s.modes.origin,
+			Autowrap:        s.modes.autowrap,
+			LeftRightMargin: s.modes.leftrightmargin,
+			ReportFocus:     s.modes.report_focus,
+			BracketedPaste:  s.modes.bracketpaste,
+			AltScreen:       s.modes.altscreen,
+			SyncUpdate:      s.modes.syncupdate,
+			Reverse:         s.modes.reverse,
+		},
+		MouseMode:        s.mouseMode,
+		MouseProtocol:    s.mouseProtocol,
+		ScrollTop:        top,
+		ScrollBottom:     bottom,
+		Title:            s.title,
+		IconName:         s.iconName,
+		Host:             s.cwd.host,
+		WorkingDirectory: s.cwd.path,
+		Palette:          s.infoPalette,
+	}
+
+	s.info.mu.Lock()
+	s.info.info = info
+	s.info.mu.Unlock()
+}
@@ -0,0 +1,145 @@
+package state
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/lab47/vterm/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestInfo(t *testing.T) {
+	n := neko.Modern(t)
+
+	n.It("starts with the defaults", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(5, 10, &sink)
+		require.NoError(t, err)
+
+		info := state.Info()
+
+		assert.Equal(t, 5, info.Rows)
+		assert.Equal(t, 10, info.Cols)
+		assert.Equal(t, Pos{}, info.Cursor)
+		assert.True(t, info.CursorStyle.Visible)
+		assert.Equal(t, Modes{Newline: true, Autowrap: true}, info.Modes)
+		assert.Equal(t, MouseNone, info.MouseMode)
+		assert.Equal(t, MouseX10, info.MouseProtocol)
+		assert.Equal(t, 0, info.ScrollTop)
+		assert.Equal(t, 4, info.ScrollBottom)
+	})
+
+	n.It("follows what the application changes", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(10, 20, &sink)
+		require.NoError(t, err)
+
+		p, err := parser.NewParser(nil, state)
+		require.NoError(t, err)
+
+		_, err = p.Write([]byte("\x1b[?1h\x1b[?2004h\x1b[?1003h\x1b[?1006h\x1b[?1049h\x1b[?25l" +
+			"\x1b[3;7r\x1b]0;hello\x07\x1b]7;file://box/home\x07\x1b[4;5Hab"))
+		require.NoError(t, err)
+		require.NoError(t, p.Flush())
+
+		info := state.Info()
+
+		assert.True(t, info.Modes.AppCursor)
+		assert.True(t, info.Modes.BracketedPaste)
+		assert.True(t, info.Modes.AltScreen)
+		assert.False(t, info.CursorStyle.Visible)
+		assert.Equal(t, MouseMove, info.MouseMode)
+		assert.Equal(t, MouseSGR, info.MouseProtocol)
+		assert.Equal(t, 2, info.ScrollTop)
+		assert.Equal(t, 6, info.ScrollBottom)
+		assert.Equal(t, "hello", info.Title)
+		assert.Equal(t, "hello", info.IconName)
+		assert.Equal(t, "box", info.Host)
+		assert.Equal(t, "/home", info.WorkingDirectory)
+		assert.Equal(t, Pos{Row: 3, Col: 6}, info.Cursor)
+
+		// What was returned earlier doesn't change
+		_, err = p.Write([]byte("\x1b[?1003l\x1b[r"))
+		require.NoError(t, err)
+		require.NoError(t, p.Flush())
+
+		assert.Equal(t, MouseMove, info.MouseMode)
+		assert.Equal(t, MouseNone, state.Info().MouseMode)
+		assert.Equal(t, 9, state.Info().ScrollBottom)
+
+		require.NoError(t, state.Resize(4, 8))
+		assert.Equal(t, 4, state.Info().Rows)
+		assert.Equal(t, 8, state.Info().Cols)
+
+		pal := DefaultPalette
+		pal.Colors[1] = RGBColor{Red: 1, Green: 2, Blue: 3}
+
+		before := state.Info()
+
+		state.SetPalette(pal)
+
+		after := state.Info()
+		assert.Equal(t, pal, *after.Palette)
+		assert.Equal(t, DefaultPalette, *before.Palette)
+
+		// Infos share the palette until it changes
+		require.NoError(t, state.WriteDone())
+		assert.True(t, after.Palette == state.Info().Palette)
+	})
+
+	n.It("is updated once the events of a write are done", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(10, 20, &sink)
+		require.NoError(t, err)
+
+		require.NoError(t, state.HandleEvent(&parser.CSIEvent{Command: 'H', Args: []int{3, 4}}))
+		assert.Equal(t, Pos{}, state.Info().Cursor)
+
+		require.NoError(t, state.WriteDone())
+		assert.Equal(t, Pos{Row: 2, Col: 3}, state.Info().Cursor)
+	})
+
+	n.It("can be read while the state is being changed", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(10, 20, &sink)
+		require.NoError(t, err)
+
+		p, err := parser.NewParser(nil, state)
+		require.NoError(t, err)
+
+		done := make(chan struct{})
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				info := state.Info()
+				assert.True(t, info.Cursor.Row < info.Rows)
+			}
+		}()
+
+		for i := 0; i < 1000; i++ {
+			p.Write([]byte("hello\r\n\x1b]2;title\x07\x1b[?2004h\x1b[?2004l"))
+		}
+
+		close(done)
+		wg.Wait()
+	})
+
+	n.Meow()
+}
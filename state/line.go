@@ -0,0 +1,146 @@
+package state
+
+// LineSize is the DEC line size attribute of a row, set by DECSWL, DECDWL
+// and DECDHL. Rows that aren't single width only hold half as many
+// columns, with each character drawn twice as wide.
+type LineSize int
+
+const (
+	LineSizeSingle             LineSize = iota // normal size
+	LineSizeDoubleWidth                        // DECDWL
+	LineSizeDoubleHeightTop                    // DECDHL, top half of the characters
+	LineSizeDoubleHeightBottom                 // DECDHL, bottom half of the characters
+)
+
+//go:generate stringer -type=LineSize
+
+// DoubleWidth returns true if the characters on the line take up two
+// columns each.
+func (l LineSize) DoubleWidth() bool {
+	return l != LineSizeSingle
+}
+
+// LineSizer can optionally be implemented by an Output to be told when the
+// size attribute of a row changes. The attribute travels with the row's
+// content when it is scrolled.
+type LineSizer interface {
+	SetLineSize(row int, size LineSize) error
+}
+
+// LineSize returns the size attribute of the given row.
+func (s *State) LineSize(row int) LineSize {
+	if row < 0 || row >= len(s.lineInfo) {
+		return LineSizeSingle
+	}
+
+	return s.lineInfo[row].Size
+}
+
+// The number of columns usable on the given row, taking the line size
+// into account. A double width line of an odd width leaves the last column
+// unused, as half of a character doesn't fit in it, but always has one.
+func (s *State) lineCols(row int) int {
+	if s.LineSize(row).DoubleWidth() && s.cols > 1 {
+		return s.cols / 2
+	}
+
+	return s.cols
+}
+
+func (s *State) setLineSize(size LineSize) error {
+	row := s.cursor.Row
+
+	if row >= len(s.lineInfo) || s.lineInfo[row].Size == size {
+		return nil
+	}
+
+	s.lineInfo[row].Size = size
+
+	if s.lineSizer != nil {
+		err := s.lineSizer.SetLineSize(row, size)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Pull the cursor back onto the now narrower line.
+	pos := s.cursor
+	if pos.Col >= s.lineCols(row) {
+		pos.Col = s.lineCols(row) - 1
+		s.updateCursor(pos, true)
+	}
+
+	return nil
+}
+
+// Reset the size of the rows between top and bottom, used when they're
+// completely erased.
+func (s *State) resetLineSizes(top, bottom int) error {
+	for row := top; row <= bottom && row < len(s.lineInfo); row++ {
+		if s.lineInfo[row].Size == LineSizeSingle {
+			continue
+		}
+
+		s.lineInfo[row].Size = LineSizeSingle
+
+		if s.lineSizer != nil {
+			err := s.lineSizer.SetLineSize(row, LineSizeSingle)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// scrollRect passes a ScrollRect to the output, first moving the line
+// info along with the rows if whole rows are being scrolled. The rect is
+// clipped to the screen and the distance to the size of the rect, so the
+// output never has to deal with anything outside it.
+func (s *State) scrollRect(sr ScrollRect) error {
+	var ok bool
+
+	sr.Rect, ok = s.clipRect(sr.Rect)
+	if !ok || sr.Distance <= 0 {
+		return nil
+	}
+
+	size := sr.Height()
+	if sr.Direction == ScrollLeft || sr.Direction == ScrollRight {
+		size = sr.Width()
+	}
+
+	if sr.Distance > size {
+		sr.Distance = size
+	}
+
+	if sr.Start.Col == 0 && sr.End.Col >= s.cols-1 && sr.Distance > 0 {
+		top, bottom := sr.Start.Row, sr.End.Row
+		if bottom >= len(s.lineInfo) {
+			bottom = len(s.lineInfo) - 1
+		}
+
+		dist := sr.Distance
+		if dist > bottom-top+1 {
+			dist = bottom - top + 1
+		}
+
+		switch sr.Direction {
+		case ScrollUp:
+			copy(s.lineInfo[top:bottom+1], s.lineInfo[top+dist:bottom+1])
+
+			for row := bottom - dist + 1; row <= bottom; row++ {
+				s.lineInfo[row] = LineInfo{}
+			}
+		case ScrollDown:
+			copy(s.lineInfo[top+dist:bottom+1], s.lineInfo[top:bottom+1])
+
+			for row := top; row < top+dist; row++ {
+				s.lineInfo[row] = LineInfo{}
+			}
+		}
+	}
+
+	return s.output.ScrollRect(sr)
+}
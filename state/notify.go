@@ -0,0 +1,175 @@
+package state
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+)
+
+// Urgency indicates how insistent a notification wants to be.
+type Urgency int
+
+const (
+	UrgencyNormal Urgency = iota
+	UrgencyLow
+	UrgencyCritical
+)
+
+// Notification is a desktop notification requested by the application,
+// via OSC 9 (iTerm2), OSC 777 (rxvt/VTE) or OSC 99 (kitty).
+type Notification struct {
+	Title   string
+	Body    string
+	Urgency Urgency
+}
+
+// Notifier can optionally be implemented by an Output to be informed
+// when the application rings the bell or wants to post a notification.
+type Notifier interface {
+	Bell() error
+	Notify(n Notification) error
+}
+
+func (s *State) emitBell() error {
+	if s.notifier == nil {
+		return nil
+	}
+
+	return s.notifier.Bell()
+}
+
+func (s *State) emitNotification(n Notification) error {
+	if s.notifier == nil {
+		return nil
+	}
+
+	return s.notifier.Notify(n)
+}
+
+// notifyITerm handles OSC 9. ConEmu reuses the same number for a family
+// of numbered sub commands (progress, etc), which we don't treat as
+// notifications.
+func (s *State) notifyITerm(data string) (bool, error) {
+	if sc := strings.IndexByte(data, ';'); sc != -1 {
+		if _, err := strconv.Atoi(data[:sc]); err == nil {
+			return false, nil
+		}
+	}
+
+	return true, s.emitNotification(Notification{Body: data})
+}
+
+// notifyRXVT handles OSC 777, which is of the form notify;title;body
+func (s *State) notifyRXVT(data string) (bool, error) {
+	parts := strings.SplitN(data, ";", 3)
+	if parts[0] != "notify" || len(parts) < 2 {
+		return false, nil
+	}
+
+	n := Notification{Title: parts[1]}
+
+	if len(parts) == 3 {
+		n.Body = parts[2]
+	}
+
+	return true, s.emitNotification(n)
+}
+
+// maxNotification is the most bytes of title and body kept for a kitty
+// notification sent in chunks. The chunks after it are dropped, otherwise
+// a program that never finishes one could use up all our memory.
+const maxNotification = 64 << 10
+
+// notifyKitty handles OSC 99, which is of the form metadata;payload.
+// The metadata is a colon separated list of key=value pairs that say
+// which part of the notification the payload is for and if more chunks
+// are coming before the notification should be shown.
+func (s *State) notifyKitty(data string) (bool, error) {
+	sc := strings.IndexByte(data, ';')
+	if sc == -1 {
+		return false, nil
+	}
+
+	var (
+		id      string
+		done    = true
+		part    = "title"
+		encoded bool
+		urgency = -1
+	)
+
+	for _, kv := range strings.Split(data[:sc], ":") {
+		eq := strings.IndexByte(kv, '=')
+		if eq == -1 {
+			continue
+		}
+
+		val := kv[eq+1:]
+
+		switch kv[:eq] {
+		case "i":
+			id = val
+		case "d":
+			done = val != "0"
+		case "p":
+			part = val
+		case "e":
+			encoded = val == "1"
+		case "u":
+			urgency, _ = strconv.Atoi(val)
+		}
+	}
+
+	payload := data[sc+1:]
+
+	if encoded {
+		b, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			return true, nil
+		}
+
+		payload = string(b)
+	}
+
+	n := &s.pendingNotify
+
+	// A chunk for a different notification means the previous one is
+	// never going to be finished, so just start over.
+	if n.id != id {
+		*n = pendingNotification{id: id}
+	}
+
+	if len(n.Title)+len(n.Body)+len(payload) > maxNotification {
+		payload = ""
+	}
+
+	switch part {
+	case "title":
+		n.Title += payload
+	case "body":
+		n.Body += payload
+	}
+
+	switch urgency {
+	case 0:
+		n.Urgency = UrgencyLow
+	case 1:
+		n.Urgency = UrgencyNormal
+	case 2:
+		n.Urgency = UrgencyCritical
+	}
+
+	if !done {
+		return true, nil
+	}
+
+	ready := n.Notification
+	*n = pendingNotification{}
+
+	return true, s.emitNotification(ready)
+}
+
+type pendingNotification struct {
+	Notification
+	id string
+}
@@ -0,0 +1,205 @@
+package state
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Palette is the colors a terminal draws with.
+type Palette struct {
+	Colors [256]RGBColor
+
+	// The colors DefaultColor is drawn as.
+	Foreground, Background RGBColor
+}
+
+// DefaultPalette is xterm's palette, with light gray text on black. It's
+// used by NewState until SetPalette is called.
+var DefaultPalette Palette
+
+// cubeLevels are the values each component takes in the 6x6x6 color cube
+// that makes up colors 16 to 231.
+var cubeLevels = [6]uint8{0x00, 0x5f, 0x87, 0xaf, 0xd7, 0xff}
+
+func init() {
+	ansi := [16]uint32{
+		0x000000, 0xcd0000, 0x00cd00, 0xcdcd00, 0x0000ee, 0xcd00cd, 0x00cdcd, 0xe5e5e5,
+		0x7f7f7f, 0xff0000, 0x00ff00, 0xffff00, 0x5c5cff, 0xff00ff, 0x00ffff, 0xffffff,
+	}
+
+	p := &DefaultPalette
+
+	for i, v := range ansi {
+		p.Colors[i] = RGBColor{Red: uint8(v >> 16), Green: uint8(v >> 8), Blue: uint8(v)}
+	}
+
+	for i := 0; i < 216; i++ {
+		p.Colors[16+i] = RGBColor{
+			Red:   cubeLevels[i/36],
+			Green: cubeLevels[i/6%6],
+			Blue:  cubeLevels[i%6],
+		}
+	}
+
+	for i := 0; i < 24; i++ {
+		v := uint8(8 + i*10)
+		p.Colors[232+i] = RGBColor{Red: v, Green: v, Blue: v}
+	}
+
+	p.Foreground = p.Colors[7]
+	p.Background = p.Colors[0]
+}
+
+// Resolve returns +c+ as it's drawn with the palette, treating nil, which a
+// PenState has before its colors are set, as DefaultColor.
+func (p *Palette) Resolve(c Color, bg bool) RGBColor {
+	if c == nil {
+		c = DefaultColor{}
+	}
+
+	return c.RGB(p, bg)
+}
+
+// Nearest256 returns the color of the 256 color palette that looks closest
+// to +c+. The first 16 are left out, as terminals let users change them,
+// and so they'd often be drawn as something else.
+func (p *Palette) Nearest256(c RGBColor) IndexColor {
+	return p.nearest(c, 16, len(p.Colors))
+}
+
+// Nearest16 returns which of the first 16 colors of the palette looks
+// closest to +c+.
+func (p *Palette) Nearest16(c RGBColor) IndexColor {
+	return p.nearest(c, 0, 16)
+}
+
+func (p *Palette) nearest(c RGBColor, from, to int) IndexColor {
+	best, bestDist := from, -1
+
+	for i := from; i < to; i++ {
+		d := colorDistance(c, p.Colors[i])
+		if bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+
+	return IndexColor{Index: best}
+}
+
+// colorDistance returns how different +a+ and +b+ look. It weighs the
+// difference of each component by how sensitive the eye is to it, which
+// depends on how red the colors are (the "redmean" approximation), and so
+// is closer to what people see than the plain distance between them.
+func colorDistance(a, b RGBColor) int {
+	rmean := (int(a.Red) + int(b.Red)) / 2
+	dr := int(a.Red) - int(b.Red)
+	dg := int(a.Green) - int(b.Green)
+	db := int(a.Blue) - int(b.Blue)
+
+	return ((512+rmean)*dr*dr)>>8 + 4*dg*dg + ((767-rmean)*db*db)>>8
+}
+
+// SetColors sets colors of the palette from the data of an OSC 4, pairs
+// of an index and a color specification. The pairs that query a color
+// or can't be parsed are skipped. It returns true if any color was set.
+func (p *Palette) SetColors(data string) bool {
+	parts := strings.Split(data, ";")
+	set := false
+
+	for i := 0; i+1 < len(parts); i += 2 {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil || n < 0 || n >= len(p.Colors) {
+			continue
+		}
+
+		c, ok := ParseColorSpec(parts[i+1])
+		if !ok {
+			continue
+		}
+
+		p.Colors[n] = c
+		set = true
+	}
+
+	return set
+}
+
+// ResetColors puts colors of the palette back to those of +base+, from the
+// data of an OSC 104: the indexes to reset, or all of them if it's empty.
+func (p *Palette) ResetColors(base *Palette, data string) {
+	if data == "" {
+		p.Colors = base.Colors
+		return
+	}
+
+	for _, part := range strings.Split(data, ";") {
+		n, err := strconv.Atoi(part)
+		if err == nil && n >= 0 && n < len(p.Colors) {
+			p.Colors[n] = base.Colors[n]
+		}
+	}
+}
+
+// Palette returns the palette used to answer applications' color queries.
+func (s *State) Palette() Palette {
+	return s.palette
+}
+
+// SetPalette changes the palette used to answer applications' color
+// queries, which should be the one the Output draws with. OSCs setting
+// colors are passed on to the Output, to apply and then pass back here if
+// it wants to. The State only changes it itself on RIS, going back to
+// DefaultPalette after sending the Output an OSC 104. It's kept in
+// snapshots and returned by Info.
+func (s *State) SetPalette(p Palette) {
+	s.setPalette(p)
+	s.publish()
+}
+
+func (s *State) setPalette(p Palette) {
+	s.palette = p
+	s.infoPalette = &p
+}
+
+// queryPalette handles OSC 4 when it only queries colors, with pairs of a
+// palette index and "?". It returns false for anything else, to be
+// passed on.
+func (s *State) queryPalette(data string) (bool, error) {
+	parts := strings.Split(data, ";")
+	if len(parts)%2 != 0 {
+		return false, nil
+	}
+
+	idx := make([]int, 0, len(parts)/2)
+
+	for i := 0; i < len(parts); i += 2 {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil || n < 0 || n >= len(s.palette.Colors) || parts[i+1] != "?" {
+			return false, nil
+		}
+
+		idx = append(idx, n)
+	}
+
+	for _, n := range idx {
+		err := s.output.Output([]byte(fmt.Sprintf("%s4;%d;%s%s", s.osc(), n, s.palette.Colors[n].Spec(), s.st())))
+		if err != nil {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
+
+// queryDefaultColor handles OSC 10 and 11 when they query the default
+// foreground or background color. It returns false for anything else.
+func (s *State) queryDefaultColor(command int, data string) (bool, error) {
+	if data != "?" {
+		return false, nil
+	}
+
+	c := DefaultColor{}.RGB(&s.palette, command == 11)
+
+	return true, s.output.Output([]byte(fmt.Sprintf("%s%d;%s%s", s.osc(), command, c.Spec(), s.st())))
+}
@@ -4,32 +4,31 @@ import (
 	"github.com/lab47/vterm/parser"
 )
 
-type IndexColor struct {
-	Index int
-}
+// rgbColor returns the color as a Color. Boxing an RGBColor allocates, and
+// programs tend to use the same few colors over and over, so recently used
+// ones are kept around.
+func (s *State) rgbColor(r, g, b int) Color {
+	c := RGBColor{Red: colorValue(r), Green: colorValue(g), Blue: colorValue(b)}
+
+	slot := &s.rgbCache[(int(c.Red)*31+int(c.Green)*7+int(c.Blue))%len(s.rgbCache)]
 
-func (i IndexColor) String() string {
-	if name, ok := NamedColors[i.Index]; ok {
-		return name
+	if old, ok := (*slot).(RGBColor); ok && old == c {
+		return *slot
 	}
 
-	return ""
-}
+	*slot = c
 
-type RGBColor struct {
-	Red, Green, Blue uint8
+	return *slot
 }
 
-type DefaultColor struct{}
-
-type Color interface{}
-
 type PenState struct {
-	attrs PenGraphic
-	font  uint8
+	attrs     PenGraphic
+	font      uint8
+	underline UnderlineStyle
 
 	fgColor Color
 	bgColor Color
+	ulColor Color
 }
 
 func (p *PenState) Attrs() PenGraphic {
@@ -40,6 +39,17 @@ func (p *PenState) Font() int {
 	return int(p.font)
 }
 
+// Underline returns how text is underlined.
+func (p *PenState) Underline() UnderlineStyle {
+	return p.underline
+}
+
+// UnderlineColor returns the color of the underline, as set by SGR 58.
+// DefaultColor means the underline is drawn in the foreground color.
+func (p *PenState) UnderlineColor() Color {
+	return p.ulColor
+}
+
 func (p *PenState) FGColor() Color {
 	return p.fgColor
 }
@@ -77,8 +87,11 @@ const (
 	PenIntensity PenGraphic = PenBold | PenFaint
 	PenStyle     PenGraphic = PenItalic | PenFraktur
 
+	// Deprecated: curly, dotted and dashed underlines only set
+	// PenUnderlineSingle, so they aren't mistaken for a double underline.
+	// Use PenState.Underline to tell them apart.
 	PenUnderlineCurly PenGraphic = PenUnderlineSingle | PenUnderlineDouble
-	PenUnderline      PenGraphic = PenUnderlineCurly
+	PenUnderline      PenGraphic = PenUnderlineSingle | PenUnderlineDouble
 
 	PenWrapper PenGraphic = PenFramed | PenEncircled
 )
@@ -100,22 +113,57 @@ const (
 	PenAttrFont
 	PenAttrFGColor
 	PenAttrBGColor
+	PenAttrUnderlineColor
 )
 
 //go:generate stringer -type=PenAttr
 
+// UnderlineStyle is how text is underlined, as selected by SGR 4, 21 and 24.
+type UnderlineStyle uint8
+
+const (
+	UnderlineNone UnderlineStyle = iota
+	UnderlineSingle
+	UnderlineDouble
+	UnderlineCurly
+	UnderlineDotted
+	UnderlineDashed
+)
+
+//go:generate stringer -type=UnderlineStyle
+
+// graphic returns the PenGraphic bits set for the style, so that Outputs
+// only looking at Attrs still see that the text is underlined.
+func (u UnderlineStyle) graphic() PenGraphic {
+	switch u {
+	case UnderlineNone:
+		return PenNormal
+	case UnderlineDouble:
+		return PenUnderlineDouble
+	default:
+		return PenUnderlineSingle
+	}
+}
+
+// setUnderline changes the underline style of the pen.
+func (s *State) setUnderline(u UnderlineStyle) error {
+	s.pen.underline = u
+	s.pen.attrs = s.pen.attrs&^PenUnderline | u.graphic()
+
+	return s.setPenProp(PenAttrUnderline)
+}
+
 func (s *State) penReset() error {
 	if s.pen.attrs&PenIntensity != PenNormal {
 		s.pen.attrs &= ^PenIntensity
-		err := s.output.SetPenProp(PenAttrIntensity, PenNormal, s.pen)
+		err := s.setPenProp(PenAttrIntensity)
 		if err != nil {
 			return err
 		}
 	}
 
-	if s.pen.attrs&PenUnderline != PenNormal {
-		s.pen.attrs &= ^PenUnderline
-		err := s.output.SetPenProp(PenAttrUnderline, PenNormal, s.pen)
+	if s.pen.underline != UnderlineNone {
+		err := s.setUnderline(UnderlineNone)
 		if err != nil {
 			return err
 		}
@@ -123,7 +171,7 @@ func (s *State) penReset() error {
 
 	if s.pen.attrs&PenStyle != PenNormal {
 		s.pen.attrs &= ^PenStyle
-		err := s.output.SetPenProp(PenAttrStyle, PenNormal, s.pen)
+		err := s.setPenProp(PenAttrStyle)
 		if err != nil {
 			return err
 		}
@@ -131,7 +179,7 @@ func (s *State) penReset() error {
 
 	if s.pen.attrs&PenReverse != PenNormal {
 		s.pen.attrs &= ^PenReverse
-		err := s.output.SetPenProp(PenAttrReverse, false, s.pen)
+		err := s.setPenProp(PenAttrReverse)
 		if err != nil {
 			return err
 		}
@@ -139,7 +187,7 @@ func (s *State) penReset() error {
 
 	if s.pen.attrs&PenStrikeThrough != PenNormal {
 		s.pen.attrs &= ^PenStrikeThrough
-		err := s.output.SetPenProp(PenAttrStrikethrough, false, s.pen)
+		err := s.setPenProp(PenAttrStrikethrough)
 		if err != nil {
 			return err
 		}
@@ -147,7 +195,7 @@ func (s *State) penReset() error {
 
 	if s.pen.attrs&PenOverlined != PenNormal {
 		s.pen.attrs &= ^PenOverlined
-		err := s.output.SetPenProp(PenAttrOverlined, true, s.pen)
+		err := s.setPenProp(PenAttrOverlined)
 		if err != nil {
 			return err
 		}
@@ -155,7 +203,7 @@ func (s *State) penReset() error {
 
 	if s.pen.attrs&PenWrapper != PenNormal {
 		s.pen.attrs &= ^PenWrapper
-		err := s.output.SetPenProp(PenAttrWrapper, PenNormal, s.pen)
+		err := s.setPenProp(PenAttrWrapper)
 		if err != nil {
 			return err
 		}
@@ -165,7 +213,7 @@ func (s *State) penReset() error {
 
 	if s.pen.font != 0 {
 		s.pen.font = 0
-		err := s.output.SetPenProp(PenAttrFont, 0, s.pen)
+		err := s.setPenProp(PenAttrFont)
 		if err != nil {
 			return err
 		}
@@ -177,7 +225,7 @@ func (s *State) penReset() error {
 
 	if s.pen.fgColor != def {
 		s.pen.fgColor = def
-		err := s.output.SetPenProp(PenAttrFGColor, def, s.pen)
+		err := s.setPenProp(PenAttrFGColor)
 		if err != nil {
 			return err
 		}
@@ -185,7 +233,96 @@ func (s *State) penReset() error {
 
 	if s.pen.bgColor != def {
 		s.pen.bgColor = def
-		err := s.output.SetPenProp(PenAttrBGColor, def, s.pen)
+		err := s.setPenProp(PenAttrBGColor)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.pen.ulColor != def {
+		s.pen.ulColor = def
+		err := s.setPenProp(PenAttrUnderlineColor)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// switchPen moves the output from pen +from+ to +to+, reporting only the
+// attributes that differ between them.
+func (s *State) switchPen(from, to PenState) error {
+	s.pen = to
+
+	groups := []struct {
+		attr PenAttr
+		mask PenGraphic
+	}{
+		{PenAttrIntensity, PenIntensity},
+		{PenAttrStyle, PenStyle},
+		{PenAttrWrapper, PenWrapper},
+	}
+
+	for _, g := range groups {
+		if from.attrs&g.mask != to.attrs&g.mask {
+			err := s.setPenProp(g.attr)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	flags := []struct {
+		attr PenAttr
+		bit  PenGraphic
+	}{
+		{PenAttrReverse, PenReverse},
+		{PenAttrStrikethrough, PenStrikeThrough},
+		{PenAttrBlink, PenBlink},
+		{PenAttrConceal, PenConceal},
+		{PenAttrOverlined, PenOverlined},
+	}
+
+	for _, f := range flags {
+		if from.attrs&f.bit != to.attrs&f.bit {
+			err := s.setPenProp(f.attr)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if from.underline != to.underline {
+		err := s.setPenProp(PenAttrUnderline)
+		if err != nil {
+			return err
+		}
+	}
+
+	if from.font != to.font {
+		err := s.setPenProp(PenAttrFont)
+		if err != nil {
+			return err
+		}
+	}
+
+	if from.fgColor != to.fgColor {
+		err := s.setPenProp(PenAttrFGColor)
+		if err != nil {
+			return err
+		}
+	}
+
+	if from.bgColor != to.bgColor {
+		err := s.setPenProp(PenAttrBGColor)
+		if err != nil {
+			return err
+		}
+	}
+
+	if from.ulColor != to.ulColor {
+		err := s.setPenProp(PenAttrUnderlineColor)
 		if err != nil {
 			return err
 		}
@@ -199,165 +336,226 @@ func (s *State) selectGraphics(ev *parser.CSIEvent) error {
 		return s.penReset()
 	}
 
-	arg := ev.Args[0]
+	for i := 0; i < len(ev.Args); {
+		arg := ev.Args[i]
+		i++
+
+		start := i
+		for i < len(ev.Args) && ev.IsSub(i) {
+			i++
+		}
+
+		sub := ev.Args[start:i]
+
+		// The extended colors are as often given with ';' as with ':'
+		if len(sub) == 0 && (arg == 38 || arg == 48 || arg == 58) {
+			sub = colorArgs(ev.Args[i:])
+			i += len(sub)
+		}
+
+		err := s.selectGraphic(arg, sub)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// colorArgs returns the arguments at the start of +args+ that go with an
+// extended color given with ';', 5;n or 2;r;g;b. Those missing at the end
+// of the sequence are left for extendedColor to treat as empty.
+func colorArgs(args []int) []int {
+	n := 0
+
+	switch {
+	case len(args) > 0 && args[0] == 5:
+		n = 2
+	case len(args) > 0 && args[0] == 2:
+		n = 4
+	}
+
+	if n > len(args) {
+		n = len(args)
+	}
+
+	return args[:n]
+}
+
+// colorValue returns a color component or index from an SGR parameter.
+// Like xterm, an empty one is 0 and ones out of range are clamped.
+func colorValue(v int) uint8 {
+	switch {
+	case v < 0:
+		return 0
+	case v > 255:
+		return 255
+	default:
+		return uint8(v)
+	}
+}
+
+// extendedColor returns the color SGR 38, 48 or 58 selects with +sub+.
+// Components missing from the end are empty.
+func (s *State) extendedColor(sub []int) (Color, bool) {
+	at := func(i int) int {
+		if i < len(sub) {
+			return sub[i]
+		}
+
+		return -1
+	}
+
+	switch {
+	case len(sub) == 0:
+		return nil, false
+	case sub[0] == 5 && len(sub) <= 2:
+		return IndexColor{Index: int(colorValue(at(1)))}, true
+	case sub[0] == 2 && len(sub) <= 4:
+		return s.rgbColor(at(1), at(2), at(3)), true
+	case sub[0] == 2 && len(sub) == 5:
+		// 38:2::r:g:b has a color space ID before the color, which is
+		// ignored like everyone else does.
+		return s.rgbColor(sub[2], sub[3], sub[4]), true
+	default:
+		return nil, false
+	}
+}
+
+// selectGraphic handles one SGR parameter, +arg+, and its sub-parameters.
+func (s *State) selectGraphic(arg int, sub []int) error {
 	switch arg {
-	case 0:
+	case -1, 0:
 		return s.penReset()
 	case 1:
 		if s.pen.attrs&PenIntensity != PenBold {
 			s.pen.attrs &= ^PenIntensity
 			s.pen.attrs |= PenBold
-			return s.output.SetPenProp(PenAttrIntensity, PenBold, s.pen)
+			return s.setPenProp(PenAttrIntensity)
 		}
 	case 2:
 		s.pen.attrs &= ^PenIntensity
 		s.pen.attrs |= PenFaint
-		return s.output.SetPenProp(PenAttrIntensity, PenFaint, s.pen)
+		return s.setPenProp(PenAttrIntensity)
 	case 3:
 		s.pen.attrs &= ^PenStyle
 		s.pen.attrs |= PenItalic
-		return s.output.SetPenProp(PenAttrStyle, PenItalic, s.pen)
+		return s.setPenProp(PenAttrStyle)
 	case 4:
-		// Reset all underline values to reset them properly
-		s.pen.attrs &= ^PenUnderline
-
-		if len(ev.Args) > 1 {
-			switch ev.Args[1] {
-			case 0:
-				// nothing, this is off
-			case 1:
-				s.pen.attrs |= PenUnderlineSingle
-			case 2:
-				s.pen.attrs |= PenUnderlineDouble
-			case 3:
-				s.pen.attrs |= PenUnderlineCurly
+		// 4:n picks the style, where n is the UnderlineStyle
+		u := UnderlineSingle
+
+		if len(sub) > 0 {
+			u = UnderlineStyle(sub[0])
+
+			if sub[0] < 0 || u > UnderlineDashed {
+				u = UnderlineSingle
 			}
-		} else {
-			s.pen.attrs |= PenUnderlineSingle
 		}
 
-		return s.output.SetPenProp(PenAttrUnderline, s.pen.attrs&PenUnderline, s.pen)
+		return s.setUnderline(u)
 	case 5:
 		s.pen.attrs |= PenBlink
-		return s.output.SetPenProp(PenAttrBlink, true, s.pen)
+		return s.setPenProp(PenAttrBlink)
 	case 7:
 		s.pen.attrs |= PenReverse
-		return s.output.SetPenProp(PenAttrReverse, true, s.pen)
+		return s.setPenProp(PenAttrReverse)
 	case 8:
 		s.pen.attrs |= PenConceal
-		return s.output.SetPenProp(PenAttrConceal, true, s.pen)
+		return s.setPenProp(PenAttrConceal)
 	case 9:
 		s.pen.attrs |= PenStrikeThrough
-		return s.output.SetPenProp(PenAttrStrikethrough, true, s.pen)
+		return s.setPenProp(PenAttrStrikethrough)
 	case 10, 11, 12, 13, 14, 15, 16, 17, 18, 19:
 		s.pen.font = uint8(arg) - 10
-		return s.output.SetPenProp(PenAttrFont, int(s.pen.font), s.pen)
+		return s.setPenProp(PenAttrFont)
 	case 20:
 		s.pen.attrs &= ^PenStyle
 		s.pen.attrs |= PenFraktur
-		return s.output.SetPenProp(PenAttrStyle, PenFraktur, s.pen)
+		return s.setPenProp(PenAttrStyle)
 	case 21:
-		s.pen.attrs &= ^PenUnderline
-		s.pen.attrs |= PenUnderlineDouble
-
-		return s.output.SetPenProp(PenAttrUnderline, PenUnderlineDouble, s.pen)
+		return s.setUnderline(UnderlineDouble)
 	case 22:
 		s.pen.attrs &= ^PenIntensity
-		return s.output.SetPenProp(PenAttrIntensity, PenNormal, s.pen)
+		return s.setPenProp(PenAttrIntensity)
 	case 23:
 		s.pen.attrs &= ^PenStyle
-		return s.output.SetPenProp(PenAttrStyle, PenNormal, s.pen)
+		return s.setPenProp(PenAttrStyle)
 	case 24:
-		s.pen.attrs &= ^PenUnderline
-		return s.output.SetPenProp(PenAttrUnderline, PenNormal, s.pen)
+		return s.setUnderline(UnderlineNone)
 	case 25:
 		s.pen.attrs &= ^PenBlink
-		return s.output.SetPenProp(PenAttrBlink, false, s.pen)
+		return s.setPenProp(PenAttrBlink)
 	case 27:
 		s.pen.attrs &= ^PenReverse
-		return s.output.SetPenProp(PenAttrReverse, false, s.pen)
+		return s.setPenProp(PenAttrReverse)
 	case 28:
 		s.pen.attrs &= ^PenConceal
-		return s.output.SetPenProp(PenAttrConceal, false, s.pen)
+		return s.setPenProp(PenAttrConceal)
 	case 29:
 		s.pen.attrs &= ^PenStrikeThrough
-		return s.output.SetPenProp(PenAttrStrikethrough, false, s.pen)
+		return s.setPenProp(PenAttrStrikethrough)
 	case 30, 31, 32, 33, 34, 35, 36, 37:
 		newColor := IndexColor{Index: arg - 30}
 
 		if s.pen.fgColor != newColor {
 			s.pen.fgColor = newColor
-			return s.output.SetPenProp(PenAttrFGColor, newColor, s.pen)
+			return s.setPenProp(PenAttrFGColor)
 		}
 	case 38:
-		if len(ev.Args) == 3 && ev.Args[1] == 5 {
-			newColor := IndexColor{Index: ev.Args[2]}
-			if s.pen.fgColor != newColor {
-				s.pen.fgColor = newColor
-				return s.output.SetPenProp(PenAttrFGColor, newColor, s.pen)
-			}
-		}
-
-		if len(ev.Args) == 5 && ev.Args[1] == 2 {
-			s.pen.fgColor = RGBColor{
-				Red:   uint8(ev.Args[2]),
-				Green: uint8(ev.Args[3]),
-				Blue:  uint8(ev.Args[4]),
-			}
-
-			return s.output.SetPenProp(PenAttrFGColor, s.pen.fgColor, s.pen)
+		if c, ok := s.extendedColor(sub); ok && s.pen.fgColor != c {
+			s.pen.fgColor = c
+			return s.setPenProp(PenAttrFGColor)
 		}
 	case 39:
 		newColor := DefaultColor{}
 
 		if s.pen.fgColor != newColor {
 			s.pen.fgColor = newColor
-			return s.output.SetPenProp(PenAttrFGColor, newColor, s.pen)
+			return s.setPenProp(PenAttrFGColor)
 		}
 	case 40, 41, 42, 43, 44, 45, 46, 47:
 		s.pen.bgColor = IndexColor{Index: arg - 40}
-		return s.output.SetPenProp(PenAttrBGColor, s.pen.bgColor, s.pen)
+		return s.setPenProp(PenAttrBGColor)
 	case 48:
-		if len(ev.Args) == 3 && ev.Args[1] == 5 {
-			s.pen.bgColor = IndexColor{Index: ev.Args[2]}
-			return s.output.SetPenProp(PenAttrBGColor, s.pen.bgColor, s.pen)
-		}
-
-		if len(ev.Args) == 5 && ev.Args[1] == 2 {
-			s.pen.bgColor = RGBColor{
-				Red:   uint8(ev.Args[2]),
-				Green: uint8(ev.Args[3]),
-				Blue:  uint8(ev.Args[4]),
-			}
-
-			return s.output.SetPenProp(PenAttrBGColor, s.pen.bgColor, s.pen)
+		if c, ok := s.extendedColor(sub); ok {
+			s.pen.bgColor = c
+			return s.setPenProp(PenAttrBGColor)
 		}
 	case 49:
 		s.pen.bgColor = DefaultColor{}
-		return s.output.SetPenProp(PenAttrBGColor, s.pen.bgColor, s.pen)
+		return s.setPenProp(PenAttrBGColor)
 	case 51:
 		s.pen.attrs &= ^PenWrapper
 		s.pen.attrs |= PenFramed
-		return s.output.SetPenProp(PenAttrWrapper, PenFramed, s.pen)
+		return s.setPenProp(PenAttrWrapper)
 	case 52:
 		s.pen.attrs &= ^PenWrapper
 		s.pen.attrs |= PenEncircled
-		return s.output.SetPenProp(PenAttrWrapper, PenEncircled, s.pen)
+		return s.setPenProp(PenAttrWrapper)
 	case 53:
 		s.pen.attrs |= PenOverlined
-		return s.output.SetPenProp(PenAttrOverlined, true, s.pen)
+		return s.setPenProp(PenAttrOverlined)
 	case 54:
 		s.pen.attrs &= ^PenWrapper
-		return s.output.SetPenProp(PenAttrWrapper, PenNormal, s.pen)
+		return s.setPenProp(PenAttrWrapper)
 	case 55:
 		s.pen.attrs &= ^PenOverlined
-		return s.output.SetPenProp(PenAttrOverlined, false, s.pen)
+		return s.setPenProp(PenAttrOverlined)
+	case 58:
+		if c, ok := s.extendedColor(sub); ok {
+			s.pen.ulColor = c
+			return s.setPenProp(PenAttrUnderlineColor)
+		}
+	case 59:
+		s.pen.ulColor = DefaultColor{}
+		return s.setPenProp(PenAttrUnderlineColor)
 	case 90, 91, 92, 93, 94, 95, 96, 97:
 		s.pen.fgColor = IndexColor{Index: (arg - 90) + 8}
-		return s.output.SetPenProp(PenAttrFGColor, s.pen.fgColor, s.pen)
+		return s.setPenProp(PenAttrFGColor)
 	case 100, 101, 102, 103, 104, 105, 106, 107:
 		s.pen.bgColor = IndexColor{Index: (arg - 100) + 8}
-		return s.output.SetPenProp(PenAttrFGColor, s.pen.bgColor, s.pen)
+		return s.setPenProp(PenAttrBGColor)
 	}
 	return nil
 }
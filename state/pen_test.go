@@ -9,7 +9,7 @@ import (
 	"github.com/vektra/neko"
 )
 
-func TeststatePen(t *testing.T) {
+func TestStatePen(t *testing.T) {
 	n := neko.Modern(t)
 
 	n.It("can set the current pen attributes", func(t *testing.T) {
@@ -22,6 +22,7 @@ func TeststatePen(t *testing.T) {
 			sink.penProps = nil
 			state.pen.fgColor = nil
 			state.pen.attrs = 0
+			state.pen.underline = UnderlineNone
 
 			f()
 
@@ -63,31 +64,56 @@ func TeststatePen(t *testing.T) {
 		})
 
 		wrap(0, PenUnderline, func() {
-			err = state.HandleEvent(&parser.CSIEvent{Command: 'm', Args: []int{4, 0}})
+			err = state.HandleEvent(&parser.CSIEvent{Command: 'm', Args: []int{4, 0}, Sub: 1 << 1})
 			require.NoError(t, err)
 
 			checkProp("underline", PenNormal)
 		})
 
 		wrap(PenUnderlineSingle, PenUnderline, func() {
-			err = state.HandleEvent(&parser.CSIEvent{Command: 'm', Args: []int{4, 1}})
+			err = state.HandleEvent(&parser.CSIEvent{Command: 'm', Args: []int{4, 1}, Sub: 1 << 1})
 			require.NoError(t, err)
 
 			checkProp("underline", PenUnderlineSingle)
 		})
 
 		wrap(PenUnderlineDouble, PenUnderline, func() {
-			err = state.HandleEvent(&parser.CSIEvent{Command: 'm', Args: []int{4, 2}})
+			err = state.HandleEvent(&parser.CSIEvent{Command: 'm', Args: []int{4, 2}, Sub: 1 << 1})
 			require.NoError(t, err)
 
 			checkProp("underline", PenUnderlineDouble)
 		})
 
-		wrap(PenUnderlineCurly, PenUnderline, func() {
-			err = state.HandleEvent(&parser.CSIEvent{Command: 'm', Args: []int{4, 3}})
+		wrap(PenUnderlineSingle, PenUnderline, func() {
+			err = state.HandleEvent(&parser.CSIEvent{Command: 'm', Args: []int{4, 3}, Sub: 1 << 1})
+			require.NoError(t, err)
+
+			checkProp("underline", PenUnderlineSingle)
+			assert.Equal(t, UnderlineCurly, state.pen.Underline())
+		})
+
+		wrap(PenUnderlineSingle, PenUnderline, func() {
+			err = state.HandleEvent(&parser.CSIEvent{Command: 'm', Args: []int{4, 4}, Sub: 1 << 1})
+			require.NoError(t, err)
+
+			checkProp("underline", PenUnderlineSingle)
+			assert.Equal(t, UnderlineDotted, state.pen.Underline())
+		})
+
+		wrap(PenUnderlineSingle, PenUnderline, func() {
+			err = state.HandleEvent(&parser.CSIEvent{Command: 'm', Args: []int{4, 5}, Sub: 1 << 1})
+			require.NoError(t, err)
+
+			checkProp("underline", PenUnderlineSingle)
+			assert.Equal(t, UnderlineDashed, state.pen.Underline())
+		})
+
+		wrap(PenUnderlineSingle, PenUnderline, func() {
+			err = state.HandleEvent(&parser.CSIEvent{Command: 'm', Args: []int{4, 9}, Sub: 1 << 1})
 			require.NoError(t, err)
 
-			checkProp("underline", PenUnderlineCurly)
+			checkProp("underline", PenUnderlineSingle)
+			assert.Equal(t, UnderlineSingle, state.pen.Underline())
 		})
 
 		wrap(PenBlink, PenBlink, func() {
@@ -206,7 +232,7 @@ func TeststatePen(t *testing.T) {
 
 				assert.Equal(t, IndexColor{Index: i - 30}, state.pen.fgColor)
 
-				checkProp("fg_color", IndexColor{Index: i - 30})
+				checkProp("fgcolor", IndexColor{Index: i - 30})
 			})
 		}
 
@@ -216,7 +242,7 @@ func TeststatePen(t *testing.T) {
 
 			assert.Equal(t, IndexColor{Index: 132}, state.pen.fgColor)
 
-			checkProp("fg_color", IndexColor{Index: 132})
+			checkProp("fgcolor", IndexColor{Index: 132})
 		})
 
 		wrap(PenNormal, PenNormal, func() {
@@ -225,7 +251,7 @@ func TeststatePen(t *testing.T) {
 
 			assert.Equal(t, RGBColor{Red: 55, Green: 77, Blue: 99}, state.pen.fgColor)
 
-			checkProp("fg_color", RGBColor{Red: 55, Green: 77, Blue: 99})
+			checkProp("fgcolor", RGBColor{Red: 55, Green: 77, Blue: 99})
 		})
 
 		wrap(PenNormal, PenNormal, func() {
@@ -234,7 +260,7 @@ func TeststatePen(t *testing.T) {
 
 			assert.Equal(t, DefaultColor{}, state.pen.fgColor)
 
-			checkProp("fg_color", DefaultColor{})
+			checkProp("fgcolor", DefaultColor{})
 		})
 
 		for i := 40; i < 48; i++ {
@@ -244,7 +270,7 @@ func TeststatePen(t *testing.T) {
 
 				assert.Equal(t, IndexColor{Index: i - 40}, state.pen.bgColor)
 
-				checkProp("bg_color", IndexColor{Index: i - 40})
+				checkProp("bgcolor", IndexColor{Index: i - 40})
 			})
 		}
 
@@ -254,7 +280,7 @@ func TeststatePen(t *testing.T) {
 
 			assert.Equal(t, IndexColor{Index: 132}, state.pen.bgColor)
 
-			checkProp("bg_color", IndexColor{Index: 132})
+			checkProp("bgcolor", IndexColor{Index: 132})
 		})
 
 		wrap(PenNormal, PenNormal, func() {
@@ -263,7 +289,7 @@ func TeststatePen(t *testing.T) {
 
 			assert.Equal(t, RGBColor{Red: 55, Green: 77, Blue: 99}, state.pen.bgColor)
 
-			checkProp("bg_color", RGBColor{Red: 55, Green: 77, Blue: 99})
+			checkProp("bgcolor", RGBColor{Red: 55, Green: 77, Blue: 99})
 		})
 
 		wrap(PenNormal, PenNormal, func() {
@@ -272,7 +298,7 @@ func TeststatePen(t *testing.T) {
 
 			assert.Equal(t, DefaultColor{}, state.pen.bgColor)
 
-			checkProp("bg_color", DefaultColor{})
+			checkProp("bgcolor", DefaultColor{})
 		})
 
 		wrap(PenFramed, PenWrapper, func() {
@@ -319,7 +345,7 @@ func TeststatePen(t *testing.T) {
 
 				assert.Equal(t, IndexColor{Index: (i - 90) + 8}, state.pen.fgColor)
 
-				checkProp("fg_color", IndexColor{Index: (i - 90) + 8})
+				checkProp("fgcolor", IndexColor{Index: (i - 90) + 8})
 			})
 		}
 
@@ -330,10 +356,37 @@ func TeststatePen(t *testing.T) {
 
 				assert.Equal(t, IndexColor{Index: (i - 100) + 8}, state.pen.bgColor)
 
-				checkProp("bg_color", IndexColor{Index: (i - 100) + 8})
+				checkProp("bgcolor", IndexColor{Index: (i - 100) + 8})
 			})
 		}
 
+		wrap(PenNormal, PenNormal, func() {
+			err = state.HandleEvent(&parser.CSIEvent{Command: 'm', Args: []int{58, 5, 100}})
+			require.NoError(t, err)
+
+			assert.Equal(t, IndexColor{Index: 100}, state.pen.ulColor)
+
+			checkProp("underlinecolor", IndexColor{Index: 100})
+		})
+
+		wrap(PenNormal, PenNormal, func() {
+			err = state.HandleEvent(&parser.CSIEvent{Command: 'm', Args: []int{58, 2, 1, 2, 3}})
+			require.NoError(t, err)
+
+			assert.Equal(t, RGBColor{1, 2, 3}, state.pen.ulColor)
+
+			checkProp("underlinecolor", RGBColor{1, 2, 3})
+		})
+
+		wrap(PenNormal, PenNormal, func() {
+			err = state.HandleEvent(&parser.CSIEvent{Command: 'm', Args: []int{59}})
+			require.NoError(t, err)
+
+			assert.Equal(t, DefaultColor{}, state.pen.ulColor)
+
+			checkProp("underlinecolor", DefaultColor{})
+		})
+
 	})
 
 	n.Meow()
@@ -20,11 +20,12 @@ func _() {
 	_ = x[PenAttrFont-9]
 	_ = x[PenAttrFGColor-10]
 	_ = x[PenAttrBGColor-11]
+	_ = x[PenAttrUnderlineColor-12]
 }
 
-const _PenAttr_name = "PenAttrIntensityPenAttrUnderlinePenAttrStylePenAttrReversePenAttrStrikethroughPenAttrBlinkPenAttrConcealPenAttrWrapperPenAttrOverlinedPenAttrFontPenAttrFGColorPenAttrBGColor"
+const _PenAttr_name = "PenAttrIntensityPenAttrUnderlinePenAttrStylePenAttrReversePenAttrStrikethroughPenAttrBlinkPenAttrConcealPenAttrWrapperPenAttrOverlinedPenAttrFontPenAttrFGColorPenAttrBGColorPenAttrUnderlineColor"
 
-var _PenAttr_index = [...]uint8{0, 16, 32, 44, 58, 78, 90, 104, 118, 134, 145, 159, 173}
+var _PenAttr_index = [...]uint8{0, 16, 32, 44, 58, 78, 90, 104, 118, 134, 145, 159, 173, 194}
 
 func (i PenAttr) String() string {
 	if i < 0 || i >= PenAttr(len(_PenAttr_index)-1) {
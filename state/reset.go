@@ -0,0 +1,170 @@
+package state
+
+import (
+	"github.com/lab47/vterm/parser"
+)
+
+// Resetter can optionally be implemented by an Output to be told about a
+// hard reset (RIS), so it can drop anything it keeps beyond what the State
+// tells it about, such as alternate buffers. The State clears the screen
+// and resets the pen and terminal properties through the Output itself
+// right after calling HardReset.
+type Resetter interface {
+	HardReset() error
+}
+
+// softReset handles DECSTR. Unlike RIS, it leaves the screen contents,
+// the cursor position and the alternate screen alone.
+func (s *State) softReset(ev *parser.CSIEvent) error {
+	s.modes.insert = false
+	s.modes.origin = false
+	s.modes.cursor = false
+
+	// VT510 turns autowrap off here, but like xterm we put it back to the
+	// power on default, since the usual reset strings don't turn it back
+	// on again.
+	s.modes.autowrap = true
+
+	s.scrollregion.top = 0
+	s.scrollregion.bottom = -1
+
+	s.savedCursor = Pos{}
+
+	err := s.penReset()
+	if err != nil {
+		return err
+	}
+
+	if !s.cursorStyle.Visible {
+		return s.setCursorVisible(true)
+	}
+
+	return nil
+}
+
+// hardReset handles RIS, putting the State and its Output back to how they
+// were when first created.
+func (s *State) hardReset() error {
+	if s.resetter != nil {
+		err := s.resetter.HardReset()
+		if err != nil {
+			return err
+		}
+	}
+
+	var changes []Change
+
+	if s.modes.altscreen {
+		changes = append(changes, &AltScreenChange{On: false})
+	}
+
+	if s.modes.syncupdate {
+		changes = append(changes, &SyncUpdateChange{On: false})
+	}
+
+	changes = append(changes,
+		&ReverseVideoChange{On: false},
+		&MouseModeChange{Mode: MouseNone},
+		&CursorShapeChange{Shape: CursorShapeDefault},
+		&CursorBlinkChange{Blink: false},
+		&CursorVisibleChange{Visible: true},
+		&CursorColorChange{Color: DefaultColor{}},
+	)
+
+	for _, c := range changes {
+		err := s.sendChange(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.penReset()
+	if err != nil {
+		return err
+	}
+
+	err = s.Reset()
+	if err != nil {
+		return err
+	}
+
+	s.mouseMode = MouseNone
+	s.mouseProtocol = MouseX10
+	s.savedCursor = Pos{}
+	s.lastChar.valid = false
+	s.eightBitControls = false
+	s.atPhantom = false
+	s.deferNewline = false
+
+	s.titleStack = nil
+	s.iconStack = nil
+
+	err = s.setTitle("")
+	if err != nil {
+		return err
+	}
+
+	err = s.setIconName("")
+	if err != nil {
+		return err
+	}
+
+	// Colors the application redefined go back too. The Output is sent
+	// an OSC 104 for them, and can pass back its own palette from there.
+	s.setPalette(DefaultPalette)
+
+	err = s.setTermOSC(104, "")
+	if err != nil {
+		return err
+	}
+
+	err = s.resetLineSizes(0, s.rows-1)
+	if err != nil {
+		return err
+	}
+
+	for i := range s.lineInfo {
+		s.lineInfo[i] = LineInfo{}
+	}
+
+	err = s.output.ClearRect(Rect{Pos{0, 0}, Pos{s.rows - 1, s.cols - 1}})
+	if err != nil {
+		return err
+	}
+
+	s.updateCursor(Pos{0, 0}, true)
+
+	return nil
+}
+
+// alignmentTest handles DECALN, filling the screen with E's.
+func (s *State) alignmentTest() error {
+	s.scrollregion.top = 0
+	s.scrollregion.bottom = -1
+
+	err := s.resetLineSizes(0, s.rows-1)
+	if err != nil {
+		return err
+	}
+
+	tx := s.output.BeginTx()
+
+	for row := 0; row < s.rows; row++ {
+		for col := 0; col < s.cols; col++ {
+			err := tx.SetCell(Pos{row, col}, CellRune{'E', 1})
+			if err != nil {
+				tx.Close()
+				return err
+			}
+		}
+	}
+
+	err = tx.Close()
+	if err != nil {
+		return err
+	}
+
+	s.setCursor(Pos{0, 0})
+
+	return nil
+}
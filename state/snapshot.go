@@ -0,0 +1,465 @@
+package state
+
+import (
+	"fmt"
+
+	"github.com/lab47/vterm/internal/snapshot"
+)
+
+const (
+	snapshotMagic   = "VTST"
+	snapshotVersion = 3
+)
+
+// Snapshot returns the State encoded as bytes, to be given to Restore later,
+// possibly in another process. It covers everything the State tracks: the
+// cursor, modes, margins, tab stops, pens, saved cursor, titles, line
+// sizes and palette. The contents of the screen live in the Output and aren't included;
+// a screen.Screen has its own Snapshot for that.
+func (s *State) Snapshot() ([]byte, error) {
+	e := snapshot.NewEncoder(snapshotMagic, snapshotVersion)
+
+	e.Int(s.rows)
+	e.Int(s.cols)
+	encodePos(e, s.cursor)
+	e.Bool(s.atPhantom)
+
+	err := encodePen(e, s.pen)
+	if err != nil {
+		return nil, err
+	}
+
+	encodePos(e, s.lastPos)
+	e.Bool(s.lastChar.valid)
+	e.Int(int(s.lastChar.r))
+	encodeRunes(e, s.lastChar.extra)
+
+	err = encodePen(e, s.lastChar.pen)
+	if err != nil {
+		return nil, err
+	}
+
+	e.Uint(uint64(len(s.tabStops)))
+	for _, stop := range s.tabStops {
+		e.Bool(stop)
+	}
+
+	m := &s.modes
+	for _, b := range []bool{
+		m.insert, m.newline, m.cursor, m.origin, m.autowrap, m.leftrightmargin,
+		m.report_focus, m.bracketpaste, m.altscreen, m.syncupdate, m.reverse,
+	} {
+		e.Bool(b)
+	}
+
+	e.Int(s.mouseMode)
+	e.Int(s.mouseProtocol)
+	encodePos(e, s.savedCursor)
+
+	e.Int(int(s.cursorStyle.Shape))
+	e.Bool(s.cursorStyle.Blink)
+	e.Bool(s.cursorStyle.Visible)
+
+	err = encodeColor(e, s.cursorStyle.Color)
+	if err != nil {
+		return nil, err
+	}
+
+	e.Int(s.scrollregion.top)
+	e.Int(s.scrollregion.bottom)
+
+	e.Uint(uint64(len(s.lineInfo)))
+	for _, li := range s.lineInfo {
+		e.Bool(li.Continuation)
+		e.Int(int(li.Size))
+	}
+
+	e.Bool(s.deferNewline)
+	e.String(s.cwd.host)
+	e.String(s.cwd.path)
+
+	e.String(s.pendingNotify.id)
+	e.String(s.pendingNotify.Title)
+	e.String(s.pendingNotify.Body)
+	e.Int(int(s.pendingNotify.Urgency))
+
+	e.String(s.title)
+	e.String(s.iconName)
+	encodeStrings(e, s.titleStack)
+	encodeStrings(e, s.iconStack)
+
+	e.Bool(s.eightBitControls)
+	e.Bool(s.utf8)
+
+	// Added in version 3
+	for _, c := range s.palette.Colors {
+		encodeRGB(e, c)
+	}
+
+	encodeRGB(e, s.palette.Foreground)
+	encodeRGB(e, s.palette.Background)
+
+	return e.Data(), nil
+}
+
+// Restore puts the State back to how it was when +data+ was returned by
+// Snapshot, including its size. If +data+ can't be decoded, the State is
+// left alone and an error returned.
+//
+// The terminal properties and pen are then sent to the Output, along with
+// the cursor position, so that it matches the State again. The Output isn't
+// resized or redrawn; restore its contents from a snapshot of its own taken
+// at the same time.
+func (s *State) Restore(data []byte) error {
+	d, err := snapshot.NewDecoder(data, snapshotMagic, snapshotVersion)
+	if err != nil {
+		return err
+	}
+
+	r := *s
+
+	r.rows = d.Int()
+	r.cols = d.Int()
+	r.cursor = decodePos(d)
+	r.atPhantom = d.Bool()
+	r.pen = decodePen(d, d.Version >= 2)
+
+	r.lastPos = decodePos(d)
+	r.lastChar.valid = d.Bool()
+	r.lastChar.r = rune(d.Int())
+	r.lastChar.extra = decodeRunes(d)
+	r.lastChar.pen = decodePen(d, d.Version >= 2)
+
+	r.tabStops = make([]bool, d.Len())
+	for i := range r.tabStops {
+		r.tabStops[i] = d.Bool()
+	}
+
+	m := &r.modes
+	for _, b := range []*bool{
+		&m.insert, &m.newline, &m.cursor, &m.origin, &m.autowrap, &m.leftrightmargin,
+		&m.report_focus, &m.bracketpaste, &m.altscreen, &m.syncupdate, &m.reverse,
+	} {
+		*b = d.Bool()
+	}
+
+	r.mouseMode = d.Int()
+	r.mouseProtocol = d.Int()
+	r.savedCursor = decodePos(d)
+
+	r.cursorStyle.Shape = CursorShape(d.Int())
+	r.cursorStyle.Blink = d.Bool()
+	r.cursorStyle.Visible = d.Bool()
+	r.cursorStyle.Color = decodeColor(d)
+
+	r.scrollregion.top = d.Int()
+	r.scrollregion.bottom = d.Int()
+
+	r.lineInfo = make([]LineInfo, d.Len())
+	for i := range r.lineInfo {
+		r.lineInfo[i].Continuation = d.Bool()
+		r.lineInfo[i].Size = LineSize(d.Int())
+	}
+
+	r.deferNewline = d.Bool()
+	r.cwd.host = d.String()
+	r.cwd.path = d.String()
+
+	r.pendingNotify.id = d.String()
+	r.pendingNotify.Title = d.String()
+	r.pendingNotify.Body = d.String()
+	r.pendingNotify.Urgency = Urgency(d.Int())
+
+	r.title = d.String()
+	r.iconName = d.String()
+	r.titleStack = decodeStrings(d)
+	r.iconStack = decodeStrings(d)
+
+	r.eightBitControls = d.Bool()
+	r.utf8 = d.Bool()
+
+	// Older snapshots leave the palette as it is
+	if d.Version >= 3 {
+		var p Palette
+
+		for i := range p.Colors {
+			p.Colors[i] = decodeRGB(d)
+		}
+
+		p.Foreground = decodeRGB(d)
+		p.Background = decodeRGB(d)
+
+		r.setPalette(p)
+	}
+
+	err = d.Err()
+	if err != nil {
+		return err
+	}
+
+	err = r.checkRestored()
+	if err != nil {
+		return err
+	}
+
+	*s = r
+
+	s.publish()
+
+	return s.announce()
+}
+
+// checkRestored makes sure everything that points into the screen is on
+// it, so a bad snapshot can't make the State index out of range later.
+func (s *State) checkRestored() error {
+	inside := func(p Pos) bool {
+		return p.Row >= 0 && p.Row < s.rows && p.Col >= 0 && p.Col < s.cols
+	}
+
+	switch {
+	case s.rows < 1 || s.cols < 1:
+		return fmt.Errorf("%w: invalid size %dx%d", snapshot.ErrCorrupt, s.rows, s.cols)
+	case len(s.tabStops) < s.cols || len(s.lineInfo) < s.rows:
+		return fmt.Errorf("%w: tab stops or line sizes missing", snapshot.ErrCorrupt)
+	case !inside(s.cursor) || !inside(s.savedCursor) || !inside(s.lastPos):
+		return fmt.Errorf("%w: cursor off the screen", snapshot.ErrCorrupt)
+	case s.scrollregion.top < 0 || s.scrollregion.top >= s.rows:
+		return fmt.Errorf("%w: invalid scroll region", snapshot.ErrCorrupt)
+	case s.scrollregion.bottom != -1 &&
+		(s.scrollregion.bottom <= s.scrollregion.top || s.scrollregion.bottom >= s.rows):
+		return fmt.Errorf("%w: invalid scroll region", snapshot.ErrCorrupt)
+	}
+
+	return nil
+}
+
+// announce sends the terminal properties and pen to the Output, for after
+// the State has been changed underneath it.
+func (s *State) announce() error {
+	changes := []Change{
+		&AltScreenChange{On: s.modes.altscreen},
+		&SyncUpdateChange{On: s.modes.syncupdate},
+		&ReverseVideoChange{On: s.modes.reverse},
+		&MouseModeChange{Mode: s.mouseMode},
+		&CursorShapeChange{Shape: s.cursorStyle.Shape},
+		&CursorBlinkChange{Blink: s.cursorStyle.Blink},
+		&CursorVisibleChange{Visible: s.cursorStyle.Visible},
+		&CursorColorChange{Color: s.cursorStyle.Color},
+		&TitleChange{Title: s.title},
+		&IconNameChange{Name: s.iconName},
+	}
+
+	for _, c := range changes {
+		err := s.sendChange(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.switchPen(PenState{}, s.pen)
+	if err != nil {
+		return err
+	}
+
+	return s.output.MoveCursor(s.cursor)
+}
+
+// MarshalBinary encodes the pen, for an Output that keeps pens to include
+// them in a snapshot of its own.
+func (p PenState) MarshalBinary() ([]byte, error) {
+	var e snapshot.Encoder
+
+	err := encodePen(&e, p)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.Data(), nil
+}
+
+// UnmarshalBinary decodes a pen encoded by MarshalBinary, including one
+// from before pens had an underline style and color.
+func (p *PenState) UnmarshalBinary(data []byte) error {
+	d := snapshot.Open(data)
+
+	pen := decodePen(d, false)
+
+	if d.More() {
+		decodeUnderline(d, &pen)
+	}
+
+	err := d.Err()
+	if err != nil {
+		return err
+	}
+
+	*p = pen
+
+	return nil
+}
+
+func encodePen(e *snapshot.Encoder, p PenState) error {
+	e.Uint(uint64(p.attrs))
+	e.Byte(p.font)
+
+	err := encodeColor(e, p.fgColor)
+	if err != nil {
+		return err
+	}
+
+	err = encodeColor(e, p.bgColor)
+	if err != nil {
+		return err
+	}
+
+	// Added in version 2
+	e.Byte(byte(p.underline))
+
+	return encodeColor(e, p.ulColor)
+}
+
+// decodePen reads a pen, with its underline style and color if +underline+
+// is true. Otherwise they're worked out from the attributes, as they were
+// before the style and color were added.
+func decodePen(d *snapshot.Decoder, underline bool) PenState {
+	var p PenState
+
+	p.attrs = PenGraphic(d.Uint())
+	p.font = d.Byte()
+	p.fgColor = decodeColor(d)
+	p.bgColor = decodeColor(d)
+
+	if underline {
+		decodeUnderline(d, &p)
+		return p
+	}
+
+	switch p.attrs & PenUnderline {
+	case PenUnderlineSingle:
+		p.underline = UnderlineSingle
+	case PenUnderlineDouble:
+		p.underline = UnderlineDouble
+	case PenUnderlineSingle | PenUnderlineDouble:
+		p.underline = UnderlineCurly
+		p.attrs = p.attrs&^PenUnderline | PenUnderlineSingle
+	}
+
+	if p.fgColor != nil {
+		p.ulColor = DefaultColor{}
+	}
+
+	return p
+}
+
+func decodeUnderline(d *snapshot.Decoder, p *PenState) {
+	p.underline = UnderlineStyle(d.Byte())
+	p.ulColor = decodeColor(d)
+
+	if p.underline > UnderlineDashed {
+		d.Fail("unknown underline style %d", p.underline)
+	}
+}
+
+// The tags written before each color, saying which type it is.
+const (
+	colorNone byte = iota
+	colorDefault
+	colorIndex
+	colorRGB
+)
+
+func encodeColor(e *snapshot.Encoder, c Color) error {
+	switch c := c.(type) {
+	case nil:
+		e.Byte(colorNone)
+	case DefaultColor:
+		e.Byte(colorDefault)
+	case IndexColor:
+		e.Byte(colorIndex)
+		e.Int(c.Index)
+	case RGBColor:
+		e.Byte(colorRGB)
+		encodeRGB(e, c)
+	default:
+		return fmt.Errorf("unable to snapshot color of type %T", c)
+	}
+
+	return nil
+}
+
+func decodeColor(d *snapshot.Decoder) Color {
+	switch tag := d.Byte(); tag {
+	case colorNone:
+		return nil
+	case colorDefault:
+		return DefaultColor{}
+	case colorIndex:
+		return IndexColor{Index: d.Int()}
+	case colorRGB:
+		return decodeRGB(d)
+	default:
+		d.Fail("unknown color type %d", tag)
+		return nil
+	}
+}
+
+func encodeRGB(e *snapshot.Encoder, c RGBColor) {
+	e.Byte(c.Red)
+	e.Byte(c.Green)
+	e.Byte(c.Blue)
+}
+
+func decodeRGB(d *snapshot.Decoder) RGBColor {
+	return RGBColor{Red: d.Byte(), Green: d.Byte(), Blue: d.Byte()}
+}
+
+func encodePos(e *snapshot.Encoder, p Pos) {
+	e.Int(p.Row)
+	e.Int(p.Col)
+}
+
+func decodePos(d *snapshot.Decoder) Pos {
+	return Pos{Row: d.Int(), Col: d.Int()}
+}
+
+func encodeRunes(e *snapshot.Encoder, rs []rune) {
+	e.Uint(uint64(len(rs)))
+	for _, r := range rs {
+		e.Int(int(r))
+	}
+}
+
+func decodeRunes(d *snapshot.Decoder) []rune {
+	n := d.Len()
+	if n == 0 {
+		return nil
+	}
+
+	rs := make([]rune, n)
+	for i := range rs {
+		rs[i] = rune(d.Int())
+	}
+
+	return rs
+}
+
+func encodeStrings(e *snapshot.Encoder, strs []string) {
+	e.Uint(uint64(len(strs)))
+	for _, str := range strs {
+		e.String(str)
+	}
+}
+
+func decodeStrings(d *snapshot.Decoder) []string {
+	n := d.Len()
+	if n == 0 {
+		return nil
+	}
+
+	strs := make([]string, n)
+	for i := range strs {
+		strs[i] = d.String()
+	}
+
+	return strs
+}
@@ -0,0 +1,191 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/lab47/vterm/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+// otherColor is a Color that snapshots don't know about.
+type otherColor struct {
+	DefaultColor
+}
+
+func TestSnapshot(t *testing.T) {
+	n := neko.Modern(t)
+
+	// write sends +data+ through a parser to +state+.
+	write := func(t *testing.T, state *State, data string) {
+		p, err := parser.NewParser(nil, state)
+		require.NoError(t, err)
+
+		_, err = p.Write([]byte(data))
+		require.NoError(t, err)
+
+		require.NoError(t, p.Flush())
+	}
+
+	n.It("restores everything it tracks", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(10, 20, &sink)
+		require.NoError(t, err)
+
+		write(t, state, "\x1b]2;hello\x07\x1b[22;0t\x1b]1;icon\x07"+
+			"\x1b[3;8r\x1b[?6h\x1b[2;3H\x1b[1m\x1b[4m\x1b[38;2;1;2;3m\x1b[48;5;200m"+
+			"é\x1b[?1002h\x1b[?1006h\x1b[?5h\x1b[?2004h\x1b[5 q"+
+			"\x1b[3g\x1b[4G\x1bH\x1b#6\x1b]7;file://host/tmp\x07")
+
+		data, err := state.Snapshot()
+		require.NoError(t, err)
+
+		var sink2 opSink
+
+		restored, err := NewState(3, 4, &sink2)
+		require.NoError(t, err)
+
+		require.NoError(t, restored.Restore(data))
+
+		again, err := restored.Snapshot()
+		require.NoError(t, err)
+
+		assert.Equal(t, data, again)
+
+		assert.Equal(t, state.cursor, restored.cursor)
+		assert.Equal(t, state.pen, restored.pen)
+		assert.Equal(t, state.modes, restored.modes)
+		assert.Equal(t, state.scrollregion, restored.scrollregion)
+		assert.Equal(t, state.tabStops, restored.tabStops)
+		assert.Equal(t, state.titleStack, restored.titleStack)
+		assert.Equal(t, MouseDrag, restored.mouseMode)
+		assert.Equal(t, MouseSGR, restored.mouseProtocol)
+
+		assert.Contains(t, sink2.termProps, prop{"title", "hello"})
+		assert.Contains(t, sink2.termProps, prop{"iconname", "icon"})
+		assert.Contains(t, sink2.termProps, prop{"mouse", MouseDrag})
+		assert.Contains(t, sink2.termProps, prop{"reverse", true})
+		assert.Contains(t, sink2.termProps, prop{"cursorshape", CursorShapeBar})
+		assert.Contains(t, sink2.penProps, prop{"fgcolor", RGBColor{1, 2, 3}})
+		assert.Contains(t, sink2.penProps, prop{"bgcolor", IndexColor{200}})
+
+		// Both carry on the same way from there
+		write(t, state, "x́\x1b[2b\x1b[?1049h\r\n\tz")
+		write(t, restored, "x́\x1b[2b\x1b[?1049h\r\n\tz")
+
+		data, err = state.Snapshot()
+		require.NoError(t, err)
+
+		again, err = restored.Snapshot()
+		require.NoError(t, err)
+
+		assert.Equal(t, data, again)
+	})
+
+	n.It("keeps the palette", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(5, 10, &sink)
+		require.NoError(t, err)
+
+		pal := DefaultPalette
+		pal.Colors[1] = RGBColor{Red: 1, Green: 2, Blue: 3}
+		pal.Background = RGBColor{Blue: 0x40}
+
+		state.SetPalette(pal)
+
+		data, err := state.Snapshot()
+		require.NoError(t, err)
+
+		var sink2 opSink
+
+		restored, err := NewState(3, 4, &sink2)
+		require.NoError(t, err)
+
+		require.NoError(t, restored.Restore(data))
+
+		assert.Equal(t, pal, restored.Palette())
+		assert.Equal(t, pal, *restored.Info().Palette)
+	})
+
+	n.It("leaves the state alone when the snapshot is bad", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(5, 10, &sink)
+		require.NoError(t, err)
+
+		write(t, state, "hello\x1b[1m")
+
+		data, err := state.Snapshot()
+		require.NoError(t, err)
+
+		var sink2 opSink
+
+		other, err := NewState(3, 4, &sink2)
+		require.NoError(t, err)
+
+		before, err := other.Snapshot()
+		require.NoError(t, err)
+
+		for i := 0; i < len(data); i++ {
+			assert.Error(t, other.Restore(data[:i]))
+		}
+
+		assert.Error(t, other.Restore(append(data, 0)))
+		assert.Error(t, other.Restore([]byte("VTSC\x02")))
+
+		after, err := other.Snapshot()
+		require.NoError(t, err)
+
+		assert.Equal(t, before, after)
+		assert.Empty(t, sink2.termProps)
+	})
+
+	n.It("encodes pens on their own", func(t *testing.T) {
+		pen := PenState{
+			attrs:     PenBold | PenUnderlineDouble,
+			font:      3,
+			underline: UnderlineDouble,
+			fgColor:   IndexColor{Index: 9},
+			bgColor:   RGBColor{Red: 10, Green: 20, Blue: 30},
+			ulColor:   IndexColor{Index: 5},
+		}
+
+		data, err := pen.MarshalBinary()
+		require.NoError(t, err)
+
+		var back PenState
+
+		require.NoError(t, back.UnmarshalBinary(data))
+		assert.Equal(t, pen, back)
+
+		_, err = PenState{fgColor: otherColor{}}.MarshalBinary()
+		assert.Error(t, err)
+	})
+
+	n.It("decodes pens from before underline styles", func(t *testing.T) {
+		pen := PenState{
+			attrs:   PenBold | PenUnderlineCurly,
+			fgColor: DefaultColor{},
+			bgColor: DefaultColor{},
+		}
+
+		data, err := pen.MarshalBinary()
+		require.NoError(t, err)
+
+		// Without the underline style and the tag of its nil color
+		data = data[:len(data)-2]
+
+		var back PenState
+
+		require.NoError(t, back.UnmarshalBinary(data))
+
+		assert.Equal(t, PenBold|PenUnderlineSingle, back.Attrs())
+		assert.Equal(t, UnderlineCurly, back.Underline())
+		assert.Equal(t, DefaultColor{}, back.UnderlineColor())
+	})
+
+	n.Meow()
+}
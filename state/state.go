@@ -167,6 +167,8 @@ type modes struct {
 	report_focus    bool
 	bracketpaste    bool
 	altscreen       bool
+	syncupdate      bool
+	reverse         bool
 }
 
 const (
@@ -185,6 +187,7 @@ const (
 
 type LineInfo struct {
 	Continuation bool
+	Size         LineSize
 }
 
 type State struct {
@@ -198,11 +201,19 @@ type State struct {
 	output     Output
 
 	lastPos  Pos
+	lastChar struct {
+		valid bool
+		r     rune
+		extra []rune
+		pen   PenState
+	}
 	tabStops []bool
 
 	modes         modes
+	mouseMode     int
 	mouseProtocol int
 	savedCursor   Pos
+	cursorStyle   CursorStyle
 
 	scrollregion struct {
 		top, bottom int
@@ -210,9 +221,42 @@ type State struct {
 
 	lineInfo     []LineInfo
 	deferNewline bool
+
+	cwd struct {
+		host, path string
+	}
+
+	notifier      Notifier
+	pendingNotify pendingNotification
+
+	window    Window
+	lineSizer LineSizer
+	resetter  Resetter
+
+	stringReceiver StringReceiver
+	changeReceiver ChangeReceiver
+	changes        changes
+
+	info *sharedInfo
+
+	// A copy of palette for Info to share, replaced when it changes
+	infoPalette *Palette
+
+	title, iconName       string
+	titleStack, iconStack []string
+
+	identity         Identity
+	palette          Palette
+	eightBitControls bool
+	utf8             bool
+
+	rgbCache [64]Color
 }
 
-var _ parser.EventHandler = &State{}
+var (
+	_ parser.EventHandler = &State{}
+	_ parser.WriteHandler = &State{}
+)
 
 func NewState(rows, cols int, output Output) (*State, error) {
 	screen := &State{
@@ -221,6 +265,35 @@ func NewState(rows, cols int, output Output) (*State, error) {
 		output:   output,
 		tabStops: make([]bool, cols),
 		lineInfo: make([]LineInfo, rows),
+		identity: DefaultIdentity,
+		utf8:     true,
+		info:     &sharedInfo{},
+	}
+
+	screen.setPalette(DefaultPalette)
+
+	if n, ok := output.(Notifier); ok {
+		screen.notifier = n
+	}
+
+	if w, ok := output.(Window); ok {
+		screen.window = w
+	}
+
+	if ls, ok := output.(LineSizer); ok {
+		screen.lineSizer = ls
+	}
+
+	if r, ok := output.(Resetter); ok {
+		screen.resetter = r
+	}
+
+	if sr, ok := output.(StringReceiver); ok {
+		screen.stringReceiver = sr
+	}
+
+	if cr, ok := output.(ChangeReceiver); ok {
+		screen.changeReceiver = cr
 	}
 
 	err := screen.Reset()
@@ -247,17 +320,101 @@ func (s *State) Reset() error {
 
 	s.pen.fgColor = DefaultColor{}
 	s.pen.bgColor = DefaultColor{}
+	s.pen.ulColor = DefaultColor{}
+
+	s.resetCursorStyle()
+
+	s.publish()
 
 	return nil
 }
 
 func (s *State) Resize(rows, cols int) error {
+	if rows < 1 || cols < 1 {
+		return fmt.Errorf("invalid size: %dx%d", rows, cols)
+	}
+
+	defer s.publish()
+
+	for col := len(s.tabStops); col < cols; col++ {
+		s.tabStops = append(s.tabStops, col%8 == 0)
+	}
+
+	for row := len(s.lineInfo); row < rows; row++ {
+		s.lineInfo = append(s.lineInfo, LineInfo{})
+	}
+
 	s.rows = rows
 	s.cols = cols
-	return s.output.Resize(rows, cols, s.lineInfo)
+
+	// Everything that points into the screen has to be kept on it
+	s.scrollregion.top = 0
+	s.scrollregion.bottom = -1
+	s.savedCursor = s.clampPos(s.savedCursor)
+	s.lastPos = s.clampPos(s.lastPos)
+	s.lastChar.valid = false
+
+	pos := s.clampPos(s.cursor)
+	if pos != s.cursor {
+		s.atPhantom = false
+	}
+
+	s.cursor = pos
+
+	err := s.output.Resize(rows, cols, s.lineInfo)
+	if err != nil {
+		return err
+	}
+
+	return s.output.MoveCursor(s.cursor)
+}
+
+// clampPos moves a position that's off the screen to the nearest one on
+// it.
+func (s *State) clampPos(p Pos) Pos {
+	switch {
+	case p.Row < 0:
+		p.Row = 0
+	case p.Row >= s.rows:
+		p.Row = s.rows - 1
+	}
+
+	switch {
+	case p.Col < 0:
+		p.Col = 0
+	case p.Col >= s.cols:
+		p.Col = s.cols - 1
+	}
+
+	return p
+}
+
+// clipRect clips a rectangle to the screen, returning false if nothing of
+// it is left.
+func (s *State) clipRect(r Rect) (Rect, bool) {
+	if r.Start.Row > r.End.Row || r.Start.Col > r.End.Col ||
+		r.End.Row < 0 || r.End.Col < 0 || r.Start.Row >= s.rows || r.Start.Col >= s.cols {
+		return r, false
+	}
+
+	return Rect{Start: s.clampPos(r.Start), End: s.clampPos(r.End)}, true
+}
+
+// clearRect clears the part of a rectangle that's on the screen.
+func (s *State) clearRect(r Rect) error {
+	r, ok := s.clipRect(r)
+	if !ok {
+		return nil
+	}
+
+	return s.output.ClearRect(r)
 }
 
 func (s *State) HandleEvent(gev parser.Event) error {
+	return s.handleEvent(gev)
+}
+
+func (s *State) handleEvent(gev parser.Event) error {
 	if s.deferNewline {
 		s.cursor = s.lineFeed(s.cursor, false)
 	}
@@ -273,6 +430,12 @@ func (s *State) HandleEvent(gev parser.Event) error {
 		return s.handleString(ev)
 	case *parser.OSCEvent:
 		return s.handleOSC(ev)
+	case *parser.DCSEvent:
+		return s.handleDCS(ev)
+	case *parser.StringChunkEvent:
+		return s.handleStringChunk(ev)
+	case *parser.StringOverflowEvent:
+		return s.handleStringOverflow(ev)
 	case parser.ResizeEvent:
 		err := s.Resize(ev.Rows, ev.Cols)
 		if ev.Confirm != nil {
@@ -308,7 +471,7 @@ func (s *State) lineFeed(p Pos, canDefer bool) Pos {
 
 		s.deferNewline = false
 
-		s.output.ScrollRect(ScrollRect{
+		s.scrollRect(ScrollRect{
 			Rect: Rect{
 				Start: Pos{0, 0},
 				End:   Pos{s.rows - 1, s.cols - 1},
@@ -326,11 +489,13 @@ func (s *State) lineFeed(p Pos, canDefer bool) Pos {
 
 func (s *State) setCursor(p Pos) {
 	if s.modes.origin {
+		top, bottom := s.scrollBounds()
+
 		switch {
-		case p.Row < s.scrollregion.top:
-			p.Row = s.scrollregion.top
-		case p.Row >= s.scrollregion.bottom:
-			p.Row = s.scrollregion.bottom
+		case p.Row < top:
+			p.Row = top
+		case p.Row > bottom:
+			p.Row = bottom
 		}
 	} else {
 		switch {
@@ -344,8 +509,8 @@ func (s *State) setCursor(p Pos) {
 	switch {
 	case p.Col < 0:
 		p.Col = 0
-	case p.Col >= s.cols:
-		p.Col = s.cols - 1
+	case p.Col >= s.lineCols(p.Row):
+		p.Col = s.lineCols(p.Row) - 1
 	}
 
 	s.updateCursor(p, true)
@@ -367,11 +532,50 @@ func (s *State) updateCursor(p Pos, cancelPhantom bool) {
 
 func (s *State) writeData(ev *parser.TextEvent) error {
 	defer ev.Recycle()
-	data := ev.Text
 
-	tx := s.output.BeginTx()
+	tx, err := s.putText(s.output.BeginTx(), ev.Text)
+
+	// The Tx is closed even when writing fails part way, so the damage
+	// so far is reported and the Output isn't left waiting for it.
+	cerr := tx.Close()
+	if err == nil {
+		err = cerr
+	}
 
+	if err != nil {
+		return err
+	}
+
+	return s.output.MoveCursor(s.cursor)
+}
+
+// putText writes +data+ at the cursor. Wrapping closes +tx+ and begins a
+// new one, and the one that's open at the end is returned.
+func (s *State) putText(tx ModifyTx, data []byte) (ModifyTx, error) {
 	for len(data) > 0 {
+		if data[0] < utf8.RuneSelf {
+			// Runs of ASCII are written a line at a time. The parser
+			// only passes on printable characters, so there are no
+			// controls or combining characters among them.
+			n := 1
+			for n < len(data) && data[n] < utf8.RuneSelf {
+				n++
+			}
+
+			var err error
+
+			tx, err = s.putASCII(tx, data[:n])
+			if err != nil {
+				return tx, err
+			}
+
+			data = data[n:]
+
+			continue
+		}
+
+		// The parser only passes on valid UTF-8, so RuneError is an
+		// actual U+FFFD the decoder put in place of bad input.
 		r, sz := utf8.DecodeRune(data)
 
 		data = data[sz:]
@@ -379,59 +583,214 @@ func (s *State) writeData(ev *parser.TextEvent) error {
 		if unicode.In(r, unicode.Diacritic) {
 			err := tx.AppendCell(s.lastPos, r)
 			if err != nil {
-				return err
+				return tx, err
+			}
+
+			if s.lastChar.valid {
+				s.lastChar.extra = append(s.lastChar.extra, r)
 			}
 
 			continue
 		}
 
-		pos := s.cursor
-		width := 1 // TODO find the real width and use it.
+		var err error
 
-		if s.atPhantom || pos.Col+width > s.cols {
-			tx.Close()
+		tx, err = s.putRune(tx, r)
+		if err != nil {
+			return tx, err
+		}
 
-			pos = s.lineFeed(pos, false)
-			pos.Col = 0
-			s.atPhantom = false
-			if pos.Row < len(s.lineInfo) {
-				s.lineInfo[pos.Row].Continuation = true
-			}
+		s.lastChar.valid = true
+		s.lastChar.r = r
+		s.lastChar.extra = s.lastChar.extra[:0]
+		s.lastChar.pen = s.pen
+	}
 
-			tx = s.output.BeginTx()
+	return tx, nil
+}
+
+// putRune writes +r+ at the cursor and advances it, wrapping onto the
+// next line if needed. Wrapping closes +tx+ and begins a new one, which is
+// returned.
+func (s *State) putRune(tx ModifyTx, r rune) (ModifyTx, error) {
+	width := 1 // TODO find the real width and use it.
+
+	tx, pos := s.wrapFor(tx, width)
+
+	s.lastPos = pos
+
+	err := tx.SetCell(pos, CellRune{r, 1})
+	if err != nil {
+		return tx, err
+	}
+
+	if pos.Col+width >= s.lineCols(pos.Row) {
+		if s.modes.autowrap {
+			s.atPhantom = true
 		}
+	} else {
+		pos.Col += width
+	}
 
-		s.lastPos = pos
+	s.cursor = pos
 
-		err := tx.SetCell(pos, CellRune{r, 1})
-		if err != nil {
-			return err
+	return tx, nil
+}
+
+// wrapFor returns where a character +width+ columns wide goes, wrapping
+// onto the next line first if it doesn't fit. Wrapping closes +tx+ and
+// begins a new one, which is returned.
+func (s *State) wrapFor(tx ModifyTx, width int) (ModifyTx, Pos) {
+	pos := s.cursor
+
+	if s.atPhantom || pos.Col+width > s.lineCols(pos.Row) {
+		tx.Close()
+
+		pos = s.lineFeed(pos, false)
+		pos.Col = 0
+		s.atPhantom = false
+		if pos.Row < len(s.lineInfo) {
+			s.lineInfo[pos.Row].Continuation = true
+		}
+
+		tx = s.output.BeginTx()
+	}
+
+	return tx, pos
+}
+
+// RunSetter can optionally be implemented by a ModifyTx to set a run of
+// cells on one row in a single call. The text is printable ASCII, one cell
+// per byte, written with the current pen.
+type RunSetter interface {
+	SetRun(pos Pos, text []byte) error
+}
+
+// putASCII writes a run of ASCII text at the cursor like putRune, but as
+// much of it at a time as fits on the line.
+func (s *State) putASCII(tx ModifyTx, text []byte) (ModifyTx, error) {
+	for len(text) > 0 {
+		var pos Pos
+
+		tx, pos = s.wrapFor(tx, 1)
+
+		cols := s.lineCols(pos.Row)
+
+		n := cols - pos.Col
+		if n > len(text) {
+			n = len(text)
 		}
 
-		if pos.Col+width >= s.cols {
+		if n <= 0 {
+			// Nowhere to put it
+			return tx, nil
+		}
+
+		if rs, ok := tx.(RunSetter); ok {
+			err := rs.SetRun(pos, text[:n])
+			if err != nil {
+				return tx, err
+			}
+		} else {
+			for i, b := range text[:n] {
+				err := tx.SetCell(Pos{Row: pos.Row, Col: pos.Col + i}, CellRune{rune(b), 1})
+				if err != nil {
+					return tx, err
+				}
+			}
+		}
+
+		pos.Col += n - 1
+		s.lastPos = pos
+
+		if pos.Col+1 >= cols {
 			if s.modes.autowrap {
 				s.atPhantom = true
 			}
 		} else {
-			pos.Col += width
+			pos.Col++
 		}
 
 		s.cursor = pos
-	}
 
-	tx.Close()
+		s.lastChar.valid = true
+		s.lastChar.r = rune(text[n-1])
+		s.lastChar.extra = s.lastChar.extra[:0]
+		s.lastChar.pen = s.pen
 
-	return s.output.MoveCursor(s.cursor)
+		text = text[n:]
+	}
+
+	return tx, nil
 }
 
-func (s *State) emitBell() error {
-	return nil
+// repeatChar handles REP, writing the last printed character again with
+// the pen it was printed with.
+func (s *State) repeatChar(ev *parser.CSIEvent) error {
+	if !s.lastChar.valid {
+		return nil
+	}
+
+	count := 1
+	if len(ev.Args) > 0 && ev.Args[0] > 0 {
+		count = ev.Args[0]
+	}
+
+	// Anything past a full screen would just be overwritten again.
+	if max := s.rows * s.cols; count > max {
+		count = max
+	}
+
+	pen := s.pen
+
+	if pen != s.lastChar.pen {
+		err := s.switchPen(pen, s.lastChar.pen)
+		if err != nil {
+			return err
+		}
+	}
+
+	tx := s.output.BeginTx()
+
+	for i := 0; i < count; i++ {
+		var err error
+
+		tx, err = s.putRune(tx, s.lastChar.r)
+		if err != nil {
+			tx.Close()
+			return err
+		}
+
+		for _, r := range s.lastChar.extra {
+			err = tx.AppendCell(s.lastPos, r)
+			if err != nil {
+				tx.Close()
+				return err
+			}
+		}
+	}
+
+	err := tx.Close()
+	if err != nil {
+		return err
+	}
+
+	if pen != s.lastChar.pen {
+		err := s.switchPen(s.lastChar.pen, pen)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.output.MoveCursor(s.cursor)
 }
 
 func (s *State) handleControl(control byte) error {
 	pos := s.cursor
 
 	switch control {
+	case 0x5: // ENQ
+		return s.emitAnswerback()
 	case 0x7: // BEL
 		return s.emitBell()
 	case 0x8: // BS
@@ -440,13 +799,14 @@ func (s *State) handleControl(control byte) error {
 		}
 
 	case 0x9: // HT
-		pos.Col++
-		for pos.Col < s.cols {
+		cols := s.lineCols(pos.Row)
+
+		for pos.Col < cols-1 {
+			pos.Col++
+
 			if s.tabStops[pos.Col] {
 				break
 			}
-
-			pos.Col++
 		}
 	case 0xa, 0xb, 0xc:
 		pos = s.lineFeed(pos, true)
@@ -466,13 +826,17 @@ func (s *State) handleControl(control byte) error {
 		return nil
 
 	case 0x8d: // synthesized by the parser when handling esc'd 7-bit sequences
-		if pos.Row > s.scrollregion.top {
-			pos.Row--
+		top, bottom := s.scrollBounds()
+
+		if pos.Row != top {
+			if pos.Row > 0 {
+				pos.Row--
+			}
 		} else {
-			start := Pos{s.scrollregion.top, 0}
-			end := Pos{s.scrollregion.bottom, s.cols - 1}
+			start := Pos{top, 0}
+			end := Pos{bottom, s.cols - 1}
 
-			return s.output.ScrollRect(Rect{start, end}.ScrollDown(1))
+			return s.scrollRect(Rect{start, end}.ScrollDown(1))
 		}
 	}
 
@@ -508,9 +872,13 @@ var csiHandlers = map[parser.CSICommand]func(*State, *parser.CSIEvent) error{
 	parser.SU:  (*State).scrollUp,
 	parser.SD:  (*State).scrollDown,
 	parser.ECH: (*State).eraseChars,
+	parser.REP: (*State).repeatChar,
 
 	parser.DA:    (*State).emitDeviceAttributes,
 	parser.DA_LT: (*State).emitDeviceAttributes2,
+	parser.DA_EQ: (*State).emitDeviceAttributes3,
+
+	parser.XTVERSION: (*State).emitVersion,
 
 	parser.TBC: (*State).clearTabStop,
 
@@ -527,8 +895,12 @@ var csiHandlers = map[parser.CSICommand]func(*State, *parser.CSIEvent) error{
 
 	parser.DECSTR: (*State).softReset,
 
+	parser.DECSCUSR: (*State).setCursorShape,
+
 	parser.DECSTBM: (*State).setTopBottomMargin,
 
+	parser.XTWINOPS: (*State).windowOps,
+
 	parser.MOUSE: (*State).mouseEvent,
 }
 
@@ -544,6 +916,16 @@ func (s *State) handleCSI(ev *parser.CSIEvent) error {
 	return f(s, ev)
 }
 
+// countArg returns the first argument of a sequence that takes a count,
+// where a missing or zero argument means 1.
+func countArg(ev *parser.CSIEvent) int {
+	if len(ev.Args) == 0 || ev.Args[0] < 1 {
+		return 1
+	}
+
+	return ev.Args[0]
+}
+
 func (s *State) cursorMove(ev *parser.CSIEvent) error {
 	var pos Pos
 
@@ -623,11 +1005,7 @@ func (s *State) cursorMoveRow(ev *parser.CSIEvent) error {
 func (s *State) cursorForward(ev *parser.CSIEvent) error {
 	pos := s.cursor
 
-	inc := 1
-
-	if len(ev.Args) > 0 && ev.Args[0] != 0 {
-		inc = ev.Args[0]
-	}
+	inc := countArg(ev)
 
 	pos.Col += inc
 
@@ -642,11 +1020,7 @@ func (s *State) cursorForward(ev *parser.CSIEvent) error {
 func (s *State) cursorBackward(ev *parser.CSIEvent) error {
 	pos := s.cursor
 
-	inc := 1
-
-	if len(ev.Args) > 0 && ev.Args[0] != 0 {
-		inc = ev.Args[0]
-	}
+	inc := countArg(ev)
 
 	pos.Col -= inc
 
@@ -661,14 +1035,10 @@ func (s *State) cursorBackward(ev *parser.CSIEvent) error {
 func (s *State) cursorTabForward(ev *parser.CSIEvent) error {
 	pos := s.cursor
 
-	inc := 1
-
-	if len(ev.Args) > 0 && ev.Args[0] != 0 {
-		inc = ev.Args[0]
-	}
+	inc := countArg(ev)
 
 	for i := 0; i < inc; i++ {
-		for pos.Col < s.cols {
+		for pos.Col < s.lineCols(pos.Row)-1 {
 			pos.Col++
 
 			if s.tabStops[pos.Col] {
@@ -684,11 +1054,7 @@ func (s *State) cursorTabForward(ev *parser.CSIEvent) error {
 func (s *State) cursorTabBackward(ev *parser.CSIEvent) error {
 	pos := s.cursor
 
-	inc := 1
-
-	if len(ev.Args) > 0 && ev.Args[0] != 0 {
-		inc = ev.Args[0]
-	}
+	inc := countArg(ev)
 
 	for i := 0; i < inc; i++ {
 		for pos.Col > 0 {
@@ -707,11 +1073,7 @@ func (s *State) cursorTabBackward(ev *parser.CSIEvent) error {
 func (s *State) cursorUp(ev *parser.CSIEvent) error {
 	pos := s.cursor
 
-	inc := 1
-
-	if len(ev.Args) > 0 && ev.Args[0] != 0 {
-		inc = ev.Args[0]
-	}
+	inc := countArg(ev)
 
 	pos.Row -= inc
 
@@ -726,11 +1088,7 @@ func (s *State) cursorUp(ev *parser.CSIEvent) error {
 func (s *State) cursorDown(ev *parser.CSIEvent) error {
 	pos := s.cursor
 
-	inc := 1
-
-	if len(ev.Args) > 0 && ev.Args[0] != 0 {
-		inc = ev.Args[0]
-	}
+	inc := countArg(ev)
 
 	pos.Row += inc
 
@@ -745,11 +1103,7 @@ func (s *State) cursorDown(ev *parser.CSIEvent) error {
 func (s *State) cursorNextLine(ev *parser.CSIEvent) error {
 	pos := s.cursor
 
-	inc := 1
-
-	if len(ev.Args) > 0 && ev.Args[0] != 0 {
-		inc = ev.Args[0]
-	}
+	inc := countArg(ev)
 
 	pos.Row += inc
 
@@ -766,11 +1120,7 @@ func (s *State) cursorNextLine(ev *parser.CSIEvent) error {
 func (s *State) cursorPrevLine(ev *parser.CSIEvent) error {
 	pos := s.cursor
 
-	inc := 1
-
-	if len(ev.Args) > 0 && ev.Args[0] != 0 {
-		inc = ev.Args[0]
-	}
+	inc := countArg(ev)
 
 	pos.Row -= inc
 
@@ -791,12 +1141,9 @@ func (s *State) insertBlankChars(ev *parser.CSIEvent) error {
 
 	end.Col = s.cols - 1
 
-	dist := 1
-	if len(ev.Args) > 0 {
-		dist = ev.Args[0]
-	}
+	dist := countArg(ev)
 
-	return s.output.ScrollRect(Rect{start, end}.ScrollRight(dist))
+	return s.scrollRect(Rect{start, end}.ScrollRight(dist))
 }
 
 func (s *State) eraseDisplay(ev *parser.CSIEvent) error {
@@ -817,7 +1164,7 @@ func (s *State) eraseDisplay(ev *parser.CSIEvent) error {
 		end.Col = s.cols - 1
 
 		if start.Col > 0 {
-			err := s.output.ClearRect(Rect{start, end})
+			err := s.clearRect(Rect{start, end})
 			if err != nil {
 				return err
 			}
@@ -828,7 +1175,12 @@ func (s *State) eraseDisplay(ev *parser.CSIEvent) error {
 
 		end.Row = s.rows - 1
 
-		return s.output.ClearRect(Rect{start, end})
+		err := s.resetLineSizes(start.Row, end.Row)
+		if err != nil {
+			return err
+		}
+
+		return s.clearRect(Rect{start, end})
 	case 1: // from start to cursor
 		start := Pos{0, 0}
 
@@ -837,7 +1189,12 @@ func (s *State) eraseDisplay(ev *parser.CSIEvent) error {
 		end.Row--
 		end.Col = s.cols - 1
 
-		err := s.output.ClearRect(Rect{start, end})
+		err := s.resetLineSizes(start.Row, end.Row)
+		if err != nil {
+			return err
+		}
+
+		err = s.clearRect(Rect{start, end})
 		if err != nil {
 			return err
 		}
@@ -847,11 +1204,17 @@ func (s *State) eraseDisplay(ev *parser.CSIEvent) error {
 
 		start.Col = 0
 
-		return s.output.ClearRect(Rect{start, end})
+		return s.clearRect(Rect{start, end})
 	case 2: // the whole display
 		start := Pos{0, 0}
 		end := Pos{s.rows - 1, s.cols - 1}
-		return s.output.ClearRect(Rect{start, end})
+
+		err := s.resetLineSizes(start.Row, end.Row)
+		if err != nil {
+			return err
+		}
+
+		return s.clearRect(Rect{start, end})
 	}
 
 	return nil
@@ -882,19 +1245,11 @@ func (s *State) eraseLine(ev *parser.CSIEvent) error {
 		return nil
 	}
 
-	return s.output.ClearRect(Rect{start, end})
+	return s.clearRect(Rect{start, end})
 }
 
 func (s *State) insertLines(ev *parser.CSIEvent) error {
-	var dist int
-
-	if len(ev.Args) > 0 {
-		dist = ev.Args[0]
-	}
-
-	if dist == 0 {
-		dist = 1
-	}
+	dist := countArg(ev)
 
 	start := s.cursor
 	start.Col = 0
@@ -903,19 +1258,11 @@ func (s *State) insertLines(ev *parser.CSIEvent) error {
 
 	end := Pos{bottom, s.cols - 1}
 
-	return s.output.ScrollRect(Rect{start, end}.ScrollDown(dist))
+	return s.scrollRect(Rect{start, end}.ScrollDown(dist))
 }
 
 func (s *State) deleteLines(ev *parser.CSIEvent) error {
-	var dist int
-
-	if len(ev.Args) > 0 {
-		dist = ev.Args[0]
-	}
-
-	if dist == 0 {
-		dist = 1
-	}
+	dist := countArg(ev)
 
 	start := s.cursor
 	start.Col = 0
@@ -924,7 +1271,7 @@ func (s *State) deleteLines(ev *parser.CSIEvent) error {
 
 	end := Pos{bottom, s.cols - 1}
 
-	return s.output.ScrollRect(Rect{start, end}.ScrollUp(dist))
+	return s.scrollRect(Rect{start, end}.ScrollUp(dist))
 }
 
 func (s *State) deleteChars(ev *parser.CSIEvent) error {
@@ -934,12 +1281,9 @@ func (s *State) deleteChars(ev *parser.CSIEvent) error {
 
 	end.Col = s.cols - 1
 
-	dist := 1
-	if len(ev.Args) > 0 {
-		dist = ev.Args[0]
-	}
+	dist := countArg(ev)
 
-	return s.output.ScrollRect(Rect{start, end}.ScrollLeft(dist))
+	return s.scrollRect(Rect{start, end}.ScrollLeft(dist))
 }
 
 func (s *State) scrollUp(ev *parser.CSIEvent) error {
@@ -948,16 +1292,9 @@ func (s *State) scrollUp(ev *parser.CSIEvent) error {
 	start := Pos{top, 0}
 	end := Pos{bottom, s.cols - 1}
 
-	var dist int
-	if len(ev.Args) > 0 {
-		dist = ev.Args[0]
-	}
-
-	if dist == 0 {
-		dist = 1
-	}
+	dist := countArg(ev)
 
-	return s.output.ScrollRect(Rect{start, end}.ScrollUp(dist))
+	return s.scrollRect(Rect{start, end}.ScrollUp(dist))
 }
 
 func (s *State) scrollDown(ev *parser.CSIEvent) error {
@@ -966,43 +1303,20 @@ func (s *State) scrollDown(ev *parser.CSIEvent) error {
 	start := Pos{top, 0}
 	end := Pos{bottom, s.cols - 1}
 
-	var dist int
-	if len(ev.Args) > 0 {
-		dist = ev.Args[0]
-	}
-
-	if dist == 0 {
-		dist = 1
-	}
+	dist := countArg(ev)
 
-	return s.output.ScrollRect(Rect{start, end}.ScrollDown(dist))
+	return s.scrollRect(Rect{start, end}.ScrollDown(dist))
 }
 
 func (s *State) eraseChars(ev *parser.CSIEvent) error {
 	start := s.cursor
 
-	var dist int
-
-	if len(ev.Args) > 0 {
-		dist = ev.Args[0]
-	}
-
-	if dist == 0 {
-		dist = 1
-	}
+	dist := countArg(ev)
 
 	end := start
 	end.Col += (dist - 1)
 
-	return s.output.ClearRect(Rect{start, end})
-}
-
-func (s *State) emitDeviceAttributes(ev *parser.CSIEvent) error {
-	return s.output.Output([]byte("\x9b?1;2c"))
-}
-
-func (s *State) emitDeviceAttributes2(ev *parser.CSIEvent) error {
-	return s.output.Output([]byte("\x9b>0;100;0c"))
+	return s.clearRect(Rect{start, end})
 }
 
 func (s *State) clearTabStop(ev *parser.CSIEvent) error {
@@ -1050,24 +1364,25 @@ func (s *State) setDecMode(ev *parser.CSIEvent) error {
 	case 1:
 		s.modes.cursor = true
 	case 5:
-		return s.output.SetTermProp(TermAttrReverse, true)
+		s.modes.reverse = true
+		return s.setTermReverse(true)
 	case 6:
 		s.modes.origin = true
 		s.updateCursor(Pos{0, 0}, true)
 	case 7:
 		s.modes.autowrap = true
 	case 12:
-		return s.output.SetTermProp(TermAttrBlink, true)
+		return s.setCursorBlink(true)
 	case 25:
-		return s.output.SetTermProp(TermAttrVisible, true)
+		return s.setCursorVisible(true)
 	case 69:
 		s.modes.leftrightmargin = true
 	case 1000:
-		return s.output.SetTermProp(TermAttrMouse, MouseClick)
+		return s.setMouseMode(MouseClick)
 	case 1002:
-		return s.output.SetTermProp(TermAttrMouse, MouseDrag)
+		return s.setMouseMode(MouseDrag)
 	case 1003:
-		return s.output.SetTermProp(TermAttrMouse, MouseMove)
+		return s.setMouseMode(MouseMove)
 	case 1004:
 		s.modes.report_focus = true
 	case 1005:
@@ -1077,14 +1392,19 @@ func (s *State) setDecMode(ev *parser.CSIEvent) error {
 	case 1015:
 		s.mouseProtocol = MouseRXVT
 	case 1047:
-		return s.output.SetTermProp(TermAttrAltScreen, true)
+		s.modes.altscreen = true
+		return s.setTermAltScreen(true)
 	case 1048:
 		s.savedCursor = s.cursor
 	case 1049:
 		s.savedCursor = s.cursor
-		return s.output.SetTermProp(TermAttrAltScreen, true)
+		s.modes.altscreen = true
+		return s.setTermAltScreen(true)
 	case 2004:
 		s.modes.bracketpaste = true
+	case 2026:
+		s.modes.syncupdate = true
+		return s.setTermSyncUpdate(true)
 	}
 
 	return nil
@@ -1118,23 +1438,24 @@ func (s *State) removeDecMode(ev *parser.CSIEvent) error {
 	case 1:
 		s.modes.cursor = false
 	case 5:
-		return s.output.SetTermProp(TermAttrReverse, false)
+		s.modes.reverse = false
+		return s.setTermReverse(false)
 	case 6:
 		s.modes.origin = false
 	case 7:
 		s.modes.autowrap = false
 	case 12:
-		return s.output.SetTermProp(TermAttrBlink, false)
+		return s.setCursorBlink(false)
 	case 25:
-		return s.output.SetTermProp(TermAttrVisible, false)
+		return s.setCursorVisible(false)
 	case 69:
 		s.modes.leftrightmargin = false
 	case 1000:
-		return s.output.SetTermProp(TermAttrMouse, MouseNone)
+		return s.setMouseMode(MouseNone)
 	case 1002:
-		return s.output.SetTermProp(TermAttrMouse, MouseNone)
+		return s.setMouseMode(MouseNone)
 	case 1003:
-		return s.output.SetTermProp(TermAttrMouse, MouseNone)
+		return s.setMouseMode(MouseNone)
 	case 1004:
 		s.modes.report_focus = false
 	case 1005:
@@ -1144,19 +1465,29 @@ func (s *State) removeDecMode(ev *parser.CSIEvent) error {
 	case 1015:
 		s.mouseProtocol = MouseX10
 	case 1047:
-		return s.output.SetTermProp(TermAttrAltScreen, false)
+		s.modes.altscreen = false
+		return s.setTermAltScreen(false)
 	case 1048:
 		s.updateCursor(s.savedCursor, true)
 	case 1049:
 		s.updateCursor(s.savedCursor, true)
-		return s.output.SetTermProp(TermAttrAltScreen, false)
+		s.modes.altscreen = false
+		return s.setTermAltScreen(false)
 	case 2004:
 		s.modes.bracketpaste = false
+	case 2026:
+		s.modes.syncupdate = false
+		return s.setTermSyncUpdate(false)
 	}
 
 	return nil
 }
 
+func (s *State) setMouseMode(mode int) error {
+	s.mouseMode = mode
+	return s.setTermMouse(mode)
+}
+
 func (s *State) statusReport(ev *parser.CSIEvent) error {
 	var which int
 
@@ -1166,9 +1497,9 @@ func (s *State) statusReport(ev *parser.CSIEvent) error {
 
 	switch which {
 	case 5:
-		return s.output.Output([]byte("\x9b0n"))
+		return s.output.Output([]byte(s.csi() + "0n"))
 	case 6:
-		return s.output.Output([]byte(fmt.Sprintf("\x9b%d;%dR", s.cursor.Row+1, s.cursor.Col+1)))
+		return s.output.Output([]byte(fmt.Sprintf("%s%d;%dR", s.csi(), s.cursor.Row+1, s.cursor.Col+1)))
 	}
 
 	return nil
@@ -1183,18 +1514,14 @@ func (s *State) statusReportDec(ev *parser.CSIEvent) error {
 
 	switch which {
 	case 5:
-		return s.output.Output([]byte("\x9b?0n"))
+		return s.output.Output([]byte(s.csi() + "?0n"))
 	case 6:
-		return s.output.Output([]byte(fmt.Sprintf("\x9b?%d;%dR", s.cursor.Row+1, s.cursor.Col+1)))
+		return s.output.Output([]byte(fmt.Sprintf("%s?%d;%dR", s.csi(), s.cursor.Row+1, s.cursor.Col+1)))
 	}
 
 	return nil
 }
 
-func (s *State) softReset(ev *parser.CSIEvent) error {
-	return s.Reset()
-}
-
 func (s *State) setTopBottomMargin(ev *parser.CSIEvent) error {
 	var (
 		top    = 1
@@ -1217,8 +1544,18 @@ func (s *State) setTopBottomMargin(ev *parser.CSIEvent) error {
 		top = s.rows
 	}
 
-	if bottom > s.rows {
-		bottom = s.rows
+	if bottom >= s.rows {
+		bottom = s.rows - 1
+	}
+
+	last := bottom
+	if last < 0 {
+		last = s.rows - 1
+	}
+
+	// A region needs at least 2 lines, otherwise it's ignored
+	if top-1 >= last {
+		return nil
 	}
 
 	s.scrollregion.top = top - 1
@@ -1228,16 +1565,30 @@ func (s *State) setTopBottomMargin(ev *parser.CSIEvent) error {
 }
 
 func (s *State) handleEsc(ev *parser.EscapeEvent) error {
-	if len(ev.Data) == 1 {
-		switch ev.Data[0] {
-		case 'M':
-			pos := s.cursor
-			if pos.Row > s.scrollregion.top {
-				pos.Row--
-			}
-
-			s.updateCursor(pos, true)
+	switch string(ev.Data) {
+	case "M":
+		pos := s.cursor
+		if pos.Row > s.scrollregion.top {
+			pos.Row--
 		}
+
+		s.updateCursor(pos, true)
+	case "#3": // DECDHL top half
+		return s.setLineSize(LineSizeDoubleHeightTop)
+	case "#4": // DECDHL bottom half
+		return s.setLineSize(LineSizeDoubleHeightBottom)
+	case "#5": // DECSWL
+		return s.setLineSize(LineSizeSingle)
+	case "#6": // DECDWL
+		return s.setLineSize(LineSizeDoubleWidth)
+	case "#8": // DECALN
+		return s.alignmentTest()
+	case "c": // RIS
+		return s.hardReset()
+	case " F": // S7C1T
+		s.setControlWidth(false)
+	case " G": // S8C1T
+		s.setControlWidth(true)
 	}
 
 	return nil
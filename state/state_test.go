@@ -1,6 +1,7 @@
 package state
 
 import (
+	"io"
 	"strings"
 	"testing"
 
@@ -21,9 +22,20 @@ type opSink struct {
 	clearRects []Rect
 	scrollRect []ScrollRect
 	outputs    [][]byte
+	strs       []prop
+	chunks     []StringChunk
+	overflows  []prop
 	termProps  []prop
 	penProps   []prop
 
+	bells         int
+	notifications []Notification
+
+	cellWidth, cellHeight int
+	allowResize           bool
+
+	lineSizes map[int]LineSize
+
 	resize struct {
 		rows, cols int
 		lines      []LineInfo
@@ -81,6 +93,44 @@ func (o *opSink) SetPenProp(p PenAttr, val interface{}, ps PenState) error {
 }
 
 func (o *opSink) StringEvent(kind string, data []byte) error {
+	o.strs = append(o.strs, prop{kind, string(data)})
+	return nil
+}
+
+func (o *opSink) StringChunk(chunk StringChunk) error {
+	o.chunks = append(o.chunks, chunk)
+	return nil
+}
+
+func (o *opSink) StringOverflow(kind string, limit int) error {
+	o.overflows = append(o.overflows, prop{kind, limit})
+	return nil
+}
+
+func (o *opSink) Bell() error {
+	o.bells++
+	return nil
+}
+
+func (o *opSink) Notify(n Notification) error {
+	o.notifications = append(o.notifications, n)
+	return nil
+}
+
+func (o *opSink) CellSize() (int, int) {
+	return o.cellWidth, o.cellHeight
+}
+
+func (o *opSink) RequestResize(rows, cols int) bool {
+	return o.allowResize
+}
+
+func (o *opSink) SetLineSize(row int, size LineSize) error {
+	if o.lineSizes == nil {
+		o.lineSizes = make(map[int]LineSize)
+	}
+
+	o.lineSizes[row] = size
 	return nil
 }
 
@@ -104,6 +154,31 @@ func (o *opSink) Close() error {
 	return nil
 }
 
+// failCells fails SetCell and AppendCell, and counts the transactions
+// that are open.
+type failCells struct {
+	opSink
+	open int
+}
+
+func (f *failCells) BeginTx() ModifyTx {
+	f.open++
+	return f
+}
+
+func (f *failCells) Close() error {
+	f.open--
+	return nil
+}
+
+func (f *failCells) SetCell(pos Pos, val CellRune) error {
+	return io.ErrShortWrite
+}
+
+func (f *failCells) AppendCell(pos Pos, r rune) error {
+	return io.ErrShortWrite
+}
+
 func TestState(t *testing.T) {
 	n := neko.Modern(t)
 
@@ -203,8 +278,9 @@ func TestState(t *testing.T) {
 			{'\t', Pos{1, 8 * 7}},
 			{'\t', Pos{1, 8 * 8}},
 			{'\t', Pos{1, 8 * 9}},
-			{'\t', Pos{1, 80}},
-			{'\t', Pos{1, 81}},
+			// Tabs stop at the last column
+			{'\t', Pos{1, 79}},
+			{'\t', Pos{1, 79}},
 		}
 
 		var sink opSink
@@ -620,7 +696,7 @@ func TestState(t *testing.T) {
 
 		require.Equal(t, 1, len(sink.outputs))
 
-		assert.Equal(t, []byte("\x9b?1;2c"), sink.outputs[0])
+		assert.Equal(t, []byte("\x1b[?1;2c"), sink.outputs[0])
 	})
 
 	n.It("can emit a sequence for device attributes, dec style", func(t *testing.T) {
@@ -636,7 +712,103 @@ func TestState(t *testing.T) {
 
 		require.Equal(t, 1, len(sink.outputs))
 
-		assert.Equal(t, []byte("\x9b>0;100;0c"), sink.outputs[0])
+		assert.Equal(t, []byte("\x1b[>0;100;0c"), sink.outputs[0])
+	})
+
+	n.It("reports device attributes for the configured identity", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(25, 80, &sink)
+		require.NoError(t, err)
+
+		state.SetIdentity(Identity{
+			Model:      ModelVT420,
+			Version:    20,
+			UnitID:     "7e57ab1e",
+			Name:       "vterm(1.0)",
+			Answerback: "hello",
+		})
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'c'})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'c', Leader: []byte{'>'}})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'c', Leader: []byte{'='}})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'q', Leader: []byte{'>'}})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(parser.ControlEvent(0x5))
+		require.NoError(t, err)
+
+		require.Equal(t, 5, len(sink.outputs))
+
+		assert.Equal(t, []byte("\x1b[?64;1;22c"), sink.outputs[0])
+		assert.Equal(t, []byte("\x1b[>41;20;0c"), sink.outputs[1])
+		assert.Equal(t, []byte("\x1bP!|7e57ab1e\x1b\\"), sink.outputs[2])
+		assert.Equal(t, []byte("\x1bP>|vterm(1.0)\x1b\\"), sink.outputs[3])
+		assert.Equal(t, []byte("hello"), sink.outputs[4])
+	})
+
+	n.It("uses 8-bit controls in replies after S8C1T", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(25, 80, &sink)
+		require.NoError(t, err)
+
+		// A VT100 doesn't know about 8-bit controls
+		err = state.HandleEvent(&parser.EscapeEvent{Data: []byte(" G")})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'n', Args: []int{5}})
+		require.NoError(t, err)
+
+		state.SetIdentity(Identity{Model: ModelVT220, Version: 10})
+
+		err = state.HandleEvent(&parser.EscapeEvent{Data: []byte(" G")})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'c', Leader: []byte{'>'}})
+		require.NoError(t, err)
+
+		// No unit ID on a VT220
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'c', Leader: []byte{'='}})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.EscapeEvent{Data: []byte(" F")})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'c'})
+		require.NoError(t, err)
+
+		require.Equal(t, 3, len(sink.outputs))
+
+		assert.Equal(t, []byte("\x1b[0n"), sink.outputs[0])
+		assert.Equal(t, []byte("\xc2\x9b>1;10;0c"), sink.outputs[1])
+		assert.Equal(t, []byte("\x1b[?62;1;22c"), sink.outputs[2])
+	})
+
+	n.It("sends raw 8-bit controls when not using UTF-8", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(25, 80, &sink)
+		require.NoError(t, err)
+
+		state.SetIdentity(Identity{Model: ModelXTerm, Name: "vterm"})
+		state.SetUTF8(false)
+
+		err = state.HandleEvent(&parser.EscapeEvent{Data: []byte(" G")})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'q', Leader: []byte{'>'}})
+		require.NoError(t, err)
+
+		require.Equal(t, 1, len(sink.outputs))
+
+		assert.Equal(t, []byte("\x90>|vterm\x9c"), sink.outputs[0])
 	})
 
 	n.It("can position the cursor to an absolute row", func(t *testing.T) {
@@ -884,7 +1056,7 @@ func TestState(t *testing.T) {
 
 		require.Equal(t, 1, len(sink.outputs))
 
-		assert.Equal(t, []byte("\x9b0n"), sink.outputs[0])
+		assert.Equal(t, []byte("\x1b[0n"), sink.outputs[0])
 
 		state.cursor = Pos{10, 20}
 
@@ -895,7 +1067,7 @@ func TestState(t *testing.T) {
 
 		require.Equal(t, 1, len(sink.outputs))
 
-		assert.Equal(t, []byte("\x9b11;21R"), sink.outputs[0])
+		assert.Equal(t, []byte("\x1b[11;21R"), sink.outputs[0])
 	})
 
 	n.It("can emit a sequence for device status, dec style", func(t *testing.T) {
@@ -911,7 +1083,7 @@ func TestState(t *testing.T) {
 
 		require.Equal(t, 1, len(sink.outputs))
 
-		assert.Equal(t, []byte("\x9b?0n"), sink.outputs[0])
+		assert.Equal(t, []byte("\x1b[?0n"), sink.outputs[0])
 
 		state.cursor = Pos{10, 20}
 
@@ -922,7 +1094,7 @@ func TestState(t *testing.T) {
 
 		require.Equal(t, 1, len(sink.outputs))
 
-		assert.Equal(t, []byte("\x9b?11;21R"), sink.outputs[0])
+		assert.Equal(t, []byte("\x1b[?11;21R"), sink.outputs[0])
 	})
 
 	n.It("can reset the state", func(t *testing.T) {
@@ -943,7 +1115,16 @@ func TestState(t *testing.T) {
 		state.modes.bracketpaste = true
 		state.modes.report_focus = true
 
-		err = state.HandleEvent(&parser.CSIEvent{Command: 'p', Leader: []byte("!")})
+		state.scrollregion.top = 2
+		state.scrollregion.bottom = 10
+		state.savedCursor = Pos{4, 4}
+		state.pen.attrs = PenBold
+		state.cursorStyle.Visible = false
+
+		p, err := parser.NewParser(nil, state)
+		require.NoError(t, err)
+
+		_, err = p.Write([]byte("\x1b[!p"))
 		require.NoError(t, err)
 
 		assert.Equal(t, Pos{1, 3}, state.cursor)
@@ -951,12 +1132,107 @@ func TestState(t *testing.T) {
 		assert.True(t, state.modes.autowrap)
 		assert.False(t, state.modes.cursor)
 		assert.False(t, state.modes.insert)
+		assert.False(t, state.modes.origin)
+
+		// A soft reset leaves these alone
 		assert.True(t, state.modes.newline)
+		assert.True(t, state.modes.altscreen)
+		assert.True(t, state.modes.leftrightmargin)
+		assert.True(t, state.modes.bracketpaste)
+		assert.True(t, state.modes.report_focus)
+
+		assert.Equal(t, 0, state.scrollregion.top)
+		assert.Equal(t, -1, state.scrollregion.bottom)
+		assert.Equal(t, Pos{0, 0}, state.savedCursor)
+		assert.Equal(t, PenNormal, state.pen.attrs)
+		assert.True(t, state.cursorStyle.Visible)
+
+		assert.Equal(t, 0, len(sink.clearRects))
+	})
+
+	n.It("can hard reset the state", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(5, 10, &sink)
+		require.NoError(t, err)
+
+		state.cursor = Pos{1, 3}
+		state.modes.altscreen = true
+		state.modes.autowrap = false
+		state.tabStops[3] = true
+		state.scrollregion.top = 2
+		state.pen.attrs = PenBold
+		state.titleStack = []string{"a"}
+		state.lineInfo[2].Size = LineSizeDoubleWidth
+
+		err = state.HandleEvent(&parser.EscapeEvent{Data: []byte("c")})
+		require.NoError(t, err)
+
+		assert.Equal(t, Pos{0, 0}, state.cursor)
 		assert.False(t, state.modes.altscreen)
-		assert.False(t, state.modes.origin)
-		assert.False(t, state.modes.leftrightmargin)
-		assert.False(t, state.modes.bracketpaste)
-		assert.False(t, state.modes.report_focus)
+		assert.True(t, state.modes.autowrap)
+		assert.False(t, state.tabStops[3])
+		assert.True(t, state.tabStops[8])
+		assert.Equal(t, 0, state.scrollregion.top)
+		assert.Equal(t, PenNormal, state.pen.attrs)
+		assert.Nil(t, state.titleStack)
+		assert.Equal(t, LineSizeSingle, state.LineSize(2))
+
+		assert.Equal(t, prop{"altscreen", false}, sink.termProps[0])
+		assert.Contains(t, sink.termProps, prop{"title", ""})
+
+		require.Equal(t, 1, len(sink.clearRects))
+		assert.Equal(t, Rect{Pos{0, 0}, Pos{4, 9}}, sink.clearRects[0])
+	})
+
+	n.It("fills the screen for the alignment test", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(5, 10, &sink)
+		require.NoError(t, err)
+
+		state.cursor = Pos{1, 3}
+		state.scrollregion.top = 2
+		state.scrollregion.bottom = 3
+
+		err = state.HandleEvent(&parser.EscapeEvent{Data: []byte("#8")})
+		require.NoError(t, err)
+
+		assert.Equal(t, 50, len(sink.cellOps))
+		assert.Equal(t, CellRune{'E', 1}, sink.cellOps[Pos{4, 9}])
+		assert.Equal(t, Pos{0, 0}, state.cursor)
+		assert.Equal(t, 0, state.scrollregion.top)
+		assert.Equal(t, -1, state.scrollregion.bottom)
+	})
+
+	n.It("keeps the cursor and margins on the screen when it shrinks", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(25, 80, &sink)
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'r', Args: []int{5, 20}})
+		require.NoError(t, err)
+
+		state.cursor = Pos{22, 70}
+
+		err = state.HandleEvent(&parser.TextEvent{Text: []byte("a")})
+		require.NoError(t, err)
+
+		err = state.Resize(10, 40)
+		require.NoError(t, err)
+
+		assert.Equal(t, Pos{9, 39}, state.cursor)
+
+		top, bottom := state.scrollBounds()
+		assert.Equal(t, 0, top)
+		assert.Equal(t, 9, bottom)
+
+		// A combining character goes on a cell that's still there
+		err = state.HandleEvent(&parser.TextEvent{Text: []byte("\u0310")})
+		require.NoError(t, err)
+
+		assert.Equal(t, []rune{0x310}, sink.appendOps[Pos{9, 39}])
 	})
 
 	n.It("can set top and bottom margins", func(t *testing.T) {
@@ -977,12 +1253,815 @@ func TestState(t *testing.T) {
 		assert.Equal(t, state.scrollregion.top, 9)
 		assert.Equal(t, state.scrollregion.bottom, -1)
 
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'r', Args: []int{5, 15}})
+		require.NoError(t, err)
+
+		assert.Equal(t, state.scrollregion.top, 4)
+		assert.Equal(t, state.scrollregion.bottom, 14)
+
+		// A bottom above the top is ignored
 		err = state.HandleEvent(&parser.CSIEvent{Command: 'r', Args: []int{20, 15}})
 		require.NoError(t, err)
 
-		assert.Equal(t, state.scrollregion.top, 19)
+		assert.Equal(t, state.scrollregion.top, 4)
 		assert.Equal(t, state.scrollregion.bottom, 14)
 	})
 
+	n.It("tracks the working directory reported via OSC 7", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(25, 80, &sink)
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.OSCEvent{Command: 7, Data: "file://box.local/home/evan/My%20Code"})
+		require.NoError(t, err)
+
+		host, path := state.WorkingDirectory()
+		assert.Equal(t, "box.local", host)
+		assert.Equal(t, "/home/evan/My Code", path)
+
+		require.Equal(t, 1, len(sink.termProps))
+
+		assert.Equal(t, "workingdirectory", sink.termProps[0].prop)
+		assert.Equal(t, WorkingDirectoryChange{Host: "box.local", Path: "/home/evan/My Code"}, sink.termProps[0].val)
+
+		sink.termProps = nil
+
+		err = state.HandleEvent(&parser.OSCEvent{Command: 7, Data: "http://example.com/nope"})
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, len(sink.termProps))
+
+		_, path = state.WorkingDirectory()
+		assert.Equal(t, "/home/evan/My Code", path)
+
+		for data, want := range map[string][2]string{
+			"file:///tmp/a#b?c%20d":        {"", "/tmp/a#b?c d"},
+			"file://box/tmp/100%":          {"box", "/tmp/100%"},
+			"FILE://box/tmp/%C3%A9t%C3%A9": {"box", "/tmp/\u00e9t\u00e9"},
+		} {
+			err = state.HandleEvent(&parser.OSCEvent{Command: 7, Data: data})
+			require.NoError(t, err)
+
+			host, path = state.WorkingDirectory()
+			assert.Equal(t, want[0], host, data)
+			assert.Equal(t, want[1], path, data)
+		}
+	})
+
+	n.It("emits bell events", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(25, 80, &sink)
+		require.NoError(t, err)
+
+		err = state.HandleEvent(parser.ControlEvent(0x7))
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, sink.bells)
+	})
+
+	n.It("emits notifications for the notification OSCs", func(t *testing.T) {
+		tests := []struct {
+			events []*parser.OSCEvent
+			note   Notification
+		}{
+			{
+				[]*parser.OSCEvent{{Command: 9, Data: "build done"}},
+				Notification{Body: "build done"},
+			},
+			{
+				[]*parser.OSCEvent{{Command: 777, Data: "notify;make;build done"}},
+				Notification{Title: "make", Body: "build done"},
+			},
+			{
+				[]*parser.OSCEvent{{Command: 99, Data: ";build done"}},
+				Notification{Title: "build done"},
+			},
+			{
+				[]*parser.OSCEvent{
+					{Command: 99, Data: "i=1:d=0:u=2;make"},
+					{Command: 99, Data: "i=1:p=body:e=1;YnVpbGQgZG9uZQ=="},
+				},
+				Notification{Title: "make", Body: "build done", Urgency: UrgencyCritical},
+			},
+		}
+
+		for _, test := range tests {
+			var sink opSink
+
+			state, err := NewState(25, 80, &sink)
+			require.NoError(t, err)
+
+			for _, ev := range test.events {
+				err = state.HandleEvent(ev)
+				require.NoError(t, err)
+			}
+
+			require.Equal(t, 1, len(sink.notifications))
+
+			assert.Equal(t, test.note, sink.notifications[0])
+		}
+	})
+
+	n.It("limits the size of a notification sent in chunks", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(25, 80, &sink)
+		require.NoError(t, err)
+
+		chunk := strings.Repeat("x", 4096)
+
+		for i := 0; i < 100; i++ {
+			err = state.HandleEvent(&parser.OSCEvent{Command: 99, Data: "i=1:d=0:p=body;" + chunk})
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, maxNotification, len(state.pendingNotify.Body))
+
+		err = state.HandleEvent(&parser.OSCEvent{Command: 99, Data: "i=1;make"})
+		require.NoError(t, err)
+
+		require.Equal(t, 1, len(sink.notifications))
+
+		assert.Equal(t, "", sink.notifications[0].Title)
+		assert.Equal(t, maxNotification, len(sink.notifications[0].Body))
+	})
+
+	n.It("does not treat ConEmu OSC 9 commands as notifications", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(25, 80, &sink)
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.OSCEvent{Command: 9, Data: "4;1;50"})
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, len(sink.notifications))
+
+		require.Equal(t, 1, len(sink.termProps))
+
+		assert.Equal(t, "osc", sink.termProps[0].prop)
+		assert.Equal(t, "9;4;1;50", sink.termProps[0].val)
+	})
+
+	n.It("tracks the cursor style", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(25, 80, &sink)
+		require.NoError(t, err)
+
+		assert.Equal(t, CursorStyle{Visible: true, Color: DefaultColor{}}, state.CursorStyle())
+
+		tests := []struct {
+			arg   int
+			shape CursorShape
+			blink bool
+		}{
+			{1, CursorShapeBlock, true},
+			{2, CursorShapeBlock, false},
+			{3, CursorShapeUnderline, true},
+			{4, CursorShapeUnderline, false},
+			{5, CursorShapeBar, true},
+			{6, CursorShapeBar, false},
+			{0, CursorShapeDefault, false},
+		}
+
+		for _, test := range tests {
+			sink.termProps = nil
+
+			err = state.HandleEvent(&parser.CSIEvent{Command: 'q', Intermed: []byte{' '}, Args: []int{test.arg}})
+			require.NoError(t, err)
+
+			assert.Equal(t, test.shape, state.CursorStyle().Shape)
+			assert.Equal(t, test.blink, state.CursorStyle().Blink)
+
+			require.Equal(t, 2, len(sink.termProps))
+
+			assert.Equal(t, "cursorshape", sink.termProps[0].prop)
+			assert.Equal(t, test.shape, sink.termProps[0].val)
+			assert.Equal(t, "blink", sink.termProps[1].prop)
+			assert.Equal(t, test.blink, sink.termProps[1].val)
+		}
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'l', Leader: []byte{'?'}, Args: []int{25}})
+		require.NoError(t, err)
+
+		assert.False(t, state.CursorStyle().Visible)
+	})
+
+	n.It("can set, query and reset the cursor color", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(25, 80, &sink)
+		require.NoError(t, err)
+
+		// The default is the text color
+		err = state.HandleEvent(&parser.OSCEvent{Command: 12, Data: "?"})
+		require.NoError(t, err)
+
+		require.Equal(t, 1, len(sink.outputs))
+		assert.Equal(t, []byte("\x1b]12;rgb:e5e5/e5e5/e5e5\x1b\\"), sink.outputs[0])
+
+		sink.outputs = nil
+
+		err = state.HandleEvent(&parser.OSCEvent{Command: 12, Data: "rgb:ff/80/0"})
+		require.NoError(t, err)
+
+		assert.Equal(t, RGBColor{0xff, 0x80, 0}, state.CursorStyle().Color)
+
+		require.Equal(t, 1, len(sink.termProps))
+
+		assert.Equal(t, "cursorcolor", sink.termProps[0].prop)
+		assert.Equal(t, RGBColor{0xff, 0x80, 0}, sink.termProps[0].val)
+
+		err = state.HandleEvent(&parser.OSCEvent{Command: 12, Data: "?"})
+		require.NoError(t, err)
+
+		require.Equal(t, 1, len(sink.outputs))
+
+		assert.Equal(t, []byte("\x1b]12;rgb:ffff/8080/0000\x1b\\"), sink.outputs[0])
+
+		err = state.HandleEvent(&parser.OSCEvent{Command: 12, Data: "#102030"})
+		require.NoError(t, err)
+
+		assert.Equal(t, RGBColor{0x10, 0x20, 0x30}, state.CursorStyle().Color)
+
+		err = state.HandleEvent(&parser.OSCEvent{Command: 112})
+		require.NoError(t, err)
+
+		assert.Equal(t, DefaultColor{}, state.CursorStyle().Color)
+	})
+
+	n.It("answers color queries from the palette", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(25, 80, &sink)
+		require.NoError(t, err)
+
+		p := DefaultPalette
+		p.Colors[1] = RGBColor{Red: 0x12, Green: 0x34, Blue: 0x56}
+		p.Background = RGBColor{Red: 0xff, Green: 0xff, Blue: 0xff}
+
+		state.SetPalette(p)
+
+		for _, ev := range []*parser.OSCEvent{
+			{Command: 4, Data: "1;?;100;?"},
+			{Command: 10, Data: "?"},
+			{Command: 11, Data: "?"},
+		} {
+			require.NoError(t, state.HandleEvent(ev))
+		}
+
+		require.Equal(t, 4, len(sink.outputs))
+
+		assert.Equal(t, []byte("\x1b]4;1;rgb:1212/3434/5656\x1b\\"), sink.outputs[0])
+		assert.Equal(t, []byte("\x1b]4;100;rgb:8787/8787/0000\x1b\\"), sink.outputs[1])
+		assert.Equal(t, []byte("\x1b]10;rgb:e5e5/e5e5/e5e5\x1b\\"), sink.outputs[2])
+		assert.Equal(t, []byte("\x1b]11;rgb:ffff/ffff/ffff\x1b\\"), sink.outputs[3])
+
+		// Setting colors is left to the Output
+		require.NoError(t, state.HandleEvent(&parser.OSCEvent{Command: 4, Data: "1;#ff0000"}))
+
+		assert.Equal(t, 4, len(sink.outputs))
+		assert.Equal(t, prop{"osc", "4;1;#ff0000"}, sink.termProps[len(sink.termProps)-1])
+		assert.Equal(t, p, state.Palette())
+	})
+
+	n.It("puts the palette back on a hard reset", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(5, 10, &sink)
+		require.NoError(t, err)
+
+		pr, err := parser.NewParser(nil, state)
+		require.NoError(t, err)
+
+		_, err = pr.Write([]byte("\x1b]4;1;#ff0000\x07"))
+		require.NoError(t, err)
+
+		// Applied by the Output and passed back
+		p := state.Palette()
+		require.True(t, p.SetColors("1;#ff0000"))
+		state.SetPalette(p)
+
+		_, err = pr.Write([]byte("\x1bc"))
+		require.NoError(t, err)
+
+		assert.Equal(t, DefaultPalette, state.Palette())
+		assert.Equal(t, DefaultPalette, *state.Info().Palette)
+		assert.Contains(t, sink.termProps, prop{"osc", "104;"})
+	})
+
+	n.It("can report window sizes", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(25, 80, &sink)
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 't', Args: []int{14}})
+		require.NoError(t, err)
+
+		// No cell size known, so no pixel based replies
+		assert.Equal(t, 0, len(sink.outputs))
+
+		sink.cellWidth = 9
+		sink.cellHeight = 18
+
+		for _, arg := range []int{14, 15, 16, 18, 19} {
+			err = state.HandleEvent(&parser.CSIEvent{Command: 't', Args: []int{arg}})
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, 5, len(sink.outputs))
+
+		assert.Equal(t, []byte("\x1b[4;450;720t"), sink.outputs[0])
+		assert.Equal(t, []byte("\x1b[5;450;720t"), sink.outputs[1])
+		assert.Equal(t, []byte("\x1b[6;18;9t"), sink.outputs[2])
+		assert.Equal(t, []byte("\x1b[8;25;80t"), sink.outputs[3])
+		assert.Equal(t, []byte("\x1b[9;25;80t"), sink.outputs[4])
+	})
+
+	n.It("lets the embedder accept or deny resize requests", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(25, 80, &sink)
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 't', Args: []int{8, 30, 100}})
+		require.NoError(t, err)
+
+		assert.Equal(t, 25, state.rows)
+		assert.Equal(t, 80, state.cols)
+
+		sink.allowResize = true
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 't', Args: []int{8, 30, 100}})
+		require.NoError(t, err)
+
+		assert.Equal(t, 30, state.rows)
+		assert.Equal(t, 100, state.cols)
+
+		assert.Equal(t, 30, sink.resize.rows)
+		assert.Equal(t, 100, sink.resize.cols)
+
+		sink.cellWidth = 10
+		sink.cellHeight = 20
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 't', Args: []int{4, 400, 500}})
+		require.NoError(t, err)
+
+		assert.Equal(t, 20, state.rows)
+		assert.Equal(t, 50, state.cols)
+	})
+
+	n.It("can push and pop the title and icon name", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(25, 80, &sink)
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.OSCEvent{Command: 0, Data: "shell"})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 't', Args: []int{22, 0}})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.OSCEvent{Command: 2, Data: "vim"})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 't', Args: []int{22, 2}})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.OSCEvent{Command: 2, Data: "help"})
+		require.NoError(t, err)
+
+		sink.termProps = nil
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 't', Args: []int{23, 2}})
+		require.NoError(t, err)
+
+		require.Equal(t, 1, len(sink.termProps))
+		assert.Equal(t, prop{"title", "vim"}, sink.termProps[0])
+
+		sink.termProps = nil
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 't', Args: []int{23}})
+		require.NoError(t, err)
+
+		require.Equal(t, 2, len(sink.termProps))
+		assert.Equal(t, prop{"iconname", "shell"}, sink.termProps[0])
+		assert.Equal(t, prop{"title", "shell"}, sink.termProps[1])
+
+		sink.termProps = nil
+
+		// Popping an empty stack does nothing
+		err = state.HandleEvent(&parser.CSIEvent{Command: 't', Args: []int{23}})
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, len(sink.termProps))
+	})
+
+	n.It("reports synchronized updates", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(25, 80, &sink)
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'h', Leader: []byte{'?'}, Args: []int{2026}})
+		require.NoError(t, err)
+
+		assert.True(t, state.modes.syncupdate)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'l', Leader: []byte{'?'}, Args: []int{2026}})
+		require.NoError(t, err)
+
+		assert.False(t, state.modes.syncupdate)
+
+		require.Equal(t, 2, len(sink.termProps))
+		assert.Equal(t, prop{"syncupdate", true}, sink.termProps[0])
+		assert.Equal(t, prop{"syncupdate", false}, sink.termProps[1])
+	})
+
+	n.It("handles double width lines", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(5, 10, &sink)
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'H', Args: []int{2, 9}})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.EscapeEvent{Data: []byte("#6")})
+		require.NoError(t, err)
+
+		assert.Equal(t, LineSizeDoubleWidth, state.LineSize(1))
+		assert.Equal(t, LineSizeDoubleWidth, sink.lineSizes[1])
+
+		// The cursor is pulled back onto the narrower line
+		assert.Equal(t, Pos{1, 4}, state.cursor)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'C', Args: []int{10}})
+		require.NoError(t, err)
+
+		assert.Equal(t, Pos{1, 4}, state.cursor)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'G', Args: []int{4}})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.TextEvent{Text: []byte("abc")})
+		require.NoError(t, err)
+
+		assert.Equal(t, CellRune{'b', 1}, sink.cellOps[Pos{1, 4}])
+		assert.Equal(t, CellRune{'c', 1}, sink.cellOps[Pos{2, 0}])
+
+		err = state.HandleEvent(&parser.EscapeEvent{Data: []byte("#5")})
+		require.NoError(t, err)
+
+		assert.Equal(t, LineSizeSingle, state.LineSize(2))
+	})
+
+	n.It("leaves the last column of an odd width double width line unused", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(5, 11, &sink)
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.EscapeEvent{Data: []byte("#6")})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'C', Args: []int{20}})
+		require.NoError(t, err)
+
+		assert.Equal(t, Pos{0, 4}, state.cursor)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'G', Args: []int{1}})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.TextEvent{Text: []byte("abcdef")})
+		require.NoError(t, err)
+
+		assert.Equal(t, CellRune{'e', 1}, sink.cellOps[Pos{0, 4}])
+		assert.Equal(t, CellRune{'f', 1}, sink.cellOps[Pos{1, 0}])
+		assert.NotContains(t, sink.cellOps, Pos{0, 5})
+	})
+
+	n.It("tracks double height lines", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(5, 10, &sink)
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.EscapeEvent{Data: []byte("#3")})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(parser.ControlEvent(0xa))
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.EscapeEvent{Data: []byte("#4")})
+		require.NoError(t, err)
+
+		assert.Equal(t, LineSizeDoubleHeightTop, state.LineSize(0))
+		assert.Equal(t, LineSizeDoubleHeightBottom, state.LineSize(1))
+
+		// Scrolling carries the size along with the row
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'S', Args: []int{1}})
+		require.NoError(t, err)
+
+		assert.Equal(t, LineSizeDoubleHeightBottom, state.LineSize(0))
+		assert.Equal(t, LineSizeSingle, state.LineSize(1))
+
+		// Erasing the display resets them
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'J', Args: []int{2}})
+		require.NoError(t, err)
+
+		assert.Equal(t, LineSizeSingle, state.LineSize(0))
+		assert.Equal(t, LineSizeSingle, sink.lineSizes[0])
+	})
+
+	n.It("repeats the last printed character", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(5, 10, &sink)
+		require.NoError(t, err)
+
+		// Nothing printed yet, so nothing to repeat
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'b', Args: []int{3}})
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, len(sink.cellOps))
+
+		err = state.HandleEvent(&parser.TextEvent{Text: []byte("xa\u0301")})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'b', Args: []int{9}})
+		require.NoError(t, err)
+
+		for col := 1; col < 10; col++ {
+			assert.Equal(t, CellRune{'a', 1}, sink.cellOps[Pos{0, col}])
+			assert.Equal(t, []rune{0x301}, sink.appendOps[Pos{0, col}])
+		}
+
+		// Wraps just like printing does
+		assert.Equal(t, CellRune{'a', 1}, sink.cellOps[Pos{1, 0}])
+		assert.Equal(t, Pos{1, 1}, state.cursor)
+	})
+
+	n.It("repeats with the pen the character was printed with", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(5, 10, &sink)
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'm', Args: []int{1}})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.TextEvent{Text: []byte("a")})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'm', Args: []int{0}})
+		require.NoError(t, err)
+
+		sink.penProps = nil
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'b', Args: []int{2}})
+		require.NoError(t, err)
+
+		assert.Equal(t, CellRune{'a', 1}, sink.cellOps[Pos{0, 2}])
+
+		assert.Equal(t, []prop{
+			{"intensity", PenBold},
+			{"intensity", PenNormal},
+		}, sink.penProps)
+
+		assert.Equal(t, PenNormal, state.pen.attrs)
+	})
+
+	n.It("sets bright background colors", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(5, 10, &sink)
+		require.NoError(t, err)
+
+		sink.penProps = nil
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'm', Args: []int{103}})
+		require.NoError(t, err)
+
+		assert.Equal(t, IndexColor{Index: 11}, state.pen.BGColor())
+		assert.Equal(t, DefaultColor{}, state.pen.FGColor())
+
+		assert.Equal(t, []prop{
+			{"bgcolor", IndexColor{Index: 11}},
+		}, sink.penProps)
+	})
+
+	n.It("tracks the underline style and color", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(5, 10, &sink)
+		require.NoError(t, err)
+
+		p, err := parser.NewParser(nil, state)
+		require.NoError(t, err)
+
+		_, err = p.Write([]byte("\x1b[4:3m\x1b[58:2::10:20:30m"))
+		require.NoError(t, err)
+		require.NoError(t, p.Flush())
+
+		assert.Equal(t, UnderlineCurly, state.pen.Underline())
+		assert.Equal(t, PenUnderlineSingle, state.pen.Attrs()&PenUnderline)
+		assert.Equal(t, RGBColor{10, 20, 30}, state.pen.UnderlineColor())
+
+		_, err = p.Write([]byte("\x1b[21m\x1b[58;5;9m"))
+		require.NoError(t, err)
+		require.NoError(t, p.Flush())
+
+		assert.Equal(t, UnderlineDouble, state.pen.Underline())
+		assert.Equal(t, PenUnderlineDouble, state.pen.Attrs()&PenUnderline)
+		assert.Equal(t, IndexColor{Index: 9}, state.pen.UnderlineColor())
+
+		sink.penProps = nil
+
+		_, err = p.Write([]byte("\x1b[m"))
+		require.NoError(t, err)
+		require.NoError(t, p.Flush())
+
+		assert.Equal(t, UnderlineNone, state.pen.Underline())
+		assert.Equal(t, DefaultColor{}, state.pen.UnderlineColor())
+
+		assert.Equal(t, []prop{
+			{"underline", PenNormal},
+			{"underlinecolor", DefaultColor{}},
+		}, sink.penProps)
+	})
+
+	n.It("only takes sub-parameters after a ':'", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(5, 10, &sink)
+		require.NoError(t, err)
+
+		p, err := parser.NewParser(nil, state)
+		require.NoError(t, err)
+
+		_, err = p.Write([]byte("\x1b[4;3m"))
+		require.NoError(t, err)
+
+		assert.Equal(t, UnderlineSingle, state.pen.Underline())
+		assert.Equal(t, PenItalic, state.pen.Attrs()&PenStyle)
+
+		_, err = p.Write([]byte("\x1b[m\x1b[1;38;5;9;4:2;58;2;1;2;3;9m"))
+		require.NoError(t, err)
+
+		assert.Equal(t, PenBold, state.pen.Attrs()&PenIntensity)
+		assert.Equal(t, IndexColor{Index: 9}, state.pen.FGColor())
+		assert.Equal(t, UnderlineDouble, state.pen.Underline())
+		assert.Equal(t, RGBColor{1, 2, 3}, state.pen.UnderlineColor())
+		assert.Equal(t, PenStrikeThrough, state.pen.Attrs()&PenStrikeThrough)
+
+		_, err = p.Write([]byte("\x1b[;38:2:4:5:6;48:5:1m"))
+		require.NoError(t, err)
+
+		assert.Equal(t, PenNormal, state.pen.Attrs()&PenIntensity)
+		assert.Equal(t, RGBColor{4, 5, 6}, state.pen.FGColor())
+		assert.Equal(t, IndexColor{Index: 1}, state.pen.BGColor())
+	})
+
+	n.It("reads empty color components as 0 and clamps large ones", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(5, 10, &sink)
+		require.NoError(t, err)
+
+		p, err := parser.NewParser(nil, state)
+		require.NoError(t, err)
+
+		for _, test := range []struct {
+			sgr   string
+			color Color
+		}{
+			{"38:2::1:2:3", RGBColor{1, 2, 3}},
+			{"38;2;;;", RGBColor{0, 0, 0}},
+			{"38;2;300;0;0", RGBColor{255, 0, 0}},
+			{"38;2;7", RGBColor{7, 0, 0}},
+			{"38:2:1::70000", RGBColor{1, 0, 255}},
+			{"38;5;", IndexColor{Index: 0}},
+			{"38;5;256", IndexColor{Index: 255}},
+		} {
+			_, err = p.Write([]byte("\x1b[" + test.sgr + "m"))
+			require.NoError(t, err)
+
+			assert.Equal(t, test.color, state.pen.FGColor(), test.sgr)
+		}
+	})
+
+	n.It("closes the transaction when writing text fails", func(t *testing.T) {
+		for _, text := range []string{"abc", "\u00e9", "\u0301"} {
+			var out failCells
+
+			state, err := NewState(5, 10, &out)
+			require.NoError(t, err)
+
+			err = state.HandleEvent(&parser.TextEvent{Text: []byte(text)})
+			assert.Equal(t, io.ErrShortWrite, err, text)
+			assert.Equal(t, 0, out.open, text)
+		}
+	})
+
+	n.It("keeps parsing after a CSI it doesn't handle", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(5, 20, &sink)
+		require.NoError(t, err)
+
+		p, err := parser.NewParser(nil, state)
+		require.NoError(t, err)
+
+		_, err = p.Write([]byte("\x1b[79999["))
+		assert.Error(t, err)
+
+		_, err = p.Write([]byte("38;2;200;100;0m\x1b[1mok"))
+		require.NoError(t, err)
+		require.NoError(t, p.Flush())
+
+		var text []rune
+		for col := 0; col < 17; col++ {
+			text = append(text, sink.cellOps[Pos{Row: 0, Col: col}].Rune)
+		}
+
+		assert.Equal(t, "38;2;200;100;0mok", string(text))
+		assert.Equal(t, PenBold, state.pen.Attrs())
+	})
+
+	n.It("answers DECRQSS", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(25, 80, &sink)
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'r', Args: []int{2, 10}})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.CSIEvent{Command: 'q', Args: []int{6}, Intermed: []byte{' '}})
+		require.NoError(t, err)
+
+		for _, req := range []string{"r", " q", "m"} {
+			err = state.HandleEvent(&parser.DCSEvent{Command: 'q', Intermed: []byte("$"), Data: []byte(req)})
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, 3, len(sink.outputs))
+
+		assert.Equal(t, []byte("\x1bP1$r2;10r\x1b\\"), sink.outputs[0])
+		assert.Equal(t, []byte("\x1bP1$r6 q\x1b\\"), sink.outputs[1])
+		assert.Equal(t, []byte("\x1bP0$r\x1b\\"), sink.outputs[2])
+	})
+
+	n.It("passes on control strings it doesn't handle", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(25, 80, &sink)
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.DCSEvent{Command: 'q', Args: []int{0, -1, 8}, Data: []byte("#0")})
+		require.NoError(t, err)
+
+		err = state.HandleEvent(&parser.StringEvent{Kind: "APC", Data: []byte("Gf=24")})
+		require.NoError(t, err)
+
+		assert.Equal(t, []prop{
+			{"DCS", "0;;8q#0"},
+			{"APC", "Gf=24"},
+		}, sink.strs)
+	})
+
+	n.It("passes on streamed and discarded control strings", func(t *testing.T) {
+		var sink opSink
+
+		state, err := NewState(25, 80, &sink)
+		require.NoError(t, err)
+
+		pr, err := parser.NewParser(nil, state)
+		require.NoError(t, err)
+
+		pr.SetStringStreaming("DCS", true)
+		pr.SetStringLimit("OSC", 4)
+
+		_, err = pr.Write([]byte("\x1bP0;1q#0"))
+		require.NoError(t, err)
+
+		_, err = pr.Write([]byte("~~\x1b\\\x1b]2;title\x07"))
+		require.NoError(t, err)
+
+		assert.Equal(t, []StringChunk{
+			{Kind: "DCS", Data: []byte("0;1q#0"), First: true},
+			{Kind: "DCS", Data: []byte("~~"), Last: true},
+		}, sink.chunks)
+
+		assert.Equal(t, []prop{{"OSC", 4}}, sink.overflows)
+		assert.Equal(t, "", state.title)
+	})
+
 	n.Meow()
 }
@@ -2,30 +2,175 @@ package state
 
 import (
 	"fmt"
+	"net/url"
+	"strings"
 
 	"github.com/lab47/vterm/parser"
 )
 
+// StringChunk is part of a control string the Parser is streaming, see
+// parser.StringChunkEvent. The first chunk of a DCS starts with its
+// parameters and final byte, the same as the data passed to StringEvent.
+type StringChunk struct {
+	Kind        string
+	Data        []byte
+	First, Last bool
+	Canceled    bool
+}
+
+// StringReceiver can optionally be implemented by an Output to be passed
+// control strings that are streamed rather than passed to StringEvent, and
+// to be told about ones that were too long and were thrown away.
+type StringReceiver interface {
+	StringChunk(chunk StringChunk) error
+	StringOverflow(kind string, limit int) error
+}
+
 func (s *State) handleString(ev *parser.StringEvent) error {
 	return s.output.StringEvent(ev.Kind, ev.Data)
 }
 
+var dcsHandlers = map[parser.DCSCommand]func(*State, *parser.DCSEvent) error{
+	parser.DECRQSS: (*State).requestSetting,
+}
+
+func (s *State) handleStringChunk(ev *parser.StringChunkEvent) error {
+	if s.stringReceiver == nil {
+		return nil
+	}
+
+	chunk := StringChunk{
+		Kind:     ev.Kind,
+		Data:     ev.Data,
+		First:    ev.First,
+		Last:     ev.Last,
+		Canceled: ev.Canceled,
+	}
+
+	if ev.DCS != nil && ev.First && !ev.Canceled {
+		chunk.Data = append(ev.DCS.Body(), ev.Data...)
+	}
+
+	return s.stringReceiver.StringChunk(chunk)
+}
+
+func (s *State) handleStringOverflow(ev *parser.StringOverflowEvent) error {
+	if s.stringReceiver == nil {
+		return nil
+	}
+
+	return s.stringReceiver.StringOverflow(ev.Kind, ev.Limit)
+}
+
+// handleDCS runs the handler for the DCS, passing it on to the output as a
+// string if there isn't one.
+func (s *State) handleDCS(ev *parser.DCSEvent) error {
+	f, ok := dcsHandlers[ev.DCSCommand()]
+	if !ok {
+		return s.output.StringEvent("DCS", ev.Body())
+	}
+
+	return f(s, ev)
+}
+
+// requestSetting handles DECRQSS, replying with the control function that
+// would restore the requested setting.
+func (s *State) requestSetting(ev *parser.DCSEvent) error {
+	var setting string
+
+	switch string(ev.Data) {
+	case "r":
+		top, bottom := s.scrollBounds()
+		setting = fmt.Sprintf("%d;%dr", top+1, bottom+1)
+	case " q":
+		setting = fmt.Sprintf("%d q", s.cursorStyle.decscusr())
+	default:
+		// Not a setting we can report
+		return s.output.Output([]byte(s.dcs() + "0$r" + s.st()))
+	}
+
+	return s.output.Output([]byte(s.dcs() + "1$r" + setting + s.st()))
+}
+
 func (s *State) handleOSC(ev *parser.OSCEvent) error {
 	switch ev.Command {
 	case 0:
-		err := s.output.SetTermProp(TermAttrTitle, ev.Data)
+		err := s.setTitle(ev.Data)
 		if err != nil {
 			return err
 		}
 
-		return s.output.SetTermProp(TermAttrIconName, ev.Data)
+		return s.setIconName(ev.Data)
 	case 1:
-		return s.output.SetTermProp(TermAttrIconName, ev.Data)
+		return s.setIconName(ev.Data)
 	case 2:
-		return s.output.SetTermProp(TermAttrTitle, ev.Data)
-	default:
-		return s.output.SetTermProp(TermAttrOSC, fmt.Sprintf("%d;%s", ev.Command, ev.Data))
+		return s.setTitle(ev.Data)
+	case 4:
+		if ok, err := s.queryPalette(ev.Data); ok || err != nil {
+			return err
+		}
+	case 7:
+		return s.setWorkingDirectory(ev.Data)
+	case 10, 11:
+		if ok, err := s.queryDefaultColor(ev.Command, ev.Data); ok || err != nil {
+			return err
+		}
+	case 12:
+		return s.setCursorColor(ev.Data)
+	case 112:
+		return s.resetCursorColor()
+	case 9:
+		if ok, err := s.notifyITerm(ev.Data); ok || err != nil {
+			return err
+		}
+	case 99:
+		if ok, err := s.notifyKitty(ev.Data); ok || err != nil {
+			return err
+		}
+	case 777:
+		if ok, err := s.notifyRXVT(ev.Data); ok || err != nil {
+			return err
+		}
+	}
+
+	return s.setTermOSC(ev.Command, ev.Data)
+}
+
+// setWorkingDirectory handles OSC 7, which shells use to report their
+// current directory as a file:// URI (eg. file://host/home/user). It's
+// split up by hand rather than with url.Parse, since shells only escape
+// the path as far as they need to and a '#' or '?' in it is part of the
+// directory's name, not a fragment or query.
+func (s *State) setWorkingDirectory(data string) error {
+	const scheme = "file://"
+
+	if len(data) < len(scheme) || !strings.EqualFold(data[:len(scheme)], scheme) {
+		// Not a location we understand, so keep whatever we had before.
+		return nil
+	}
+
+	rest := data[len(scheme):]
+
+	slash := strings.IndexByte(rest, '/')
+	if slash == -1 {
+		return nil
 	}
 
-	return nil
+	host, path := rest[:slash], rest[slash:]
+
+	// A '%' that doesn't start an escape is just part of the name
+	if p, err := url.PathUnescape(path); err == nil {
+		path = p
+	}
+
+	s.cwd.host = host
+	s.cwd.path = path
+
+	return s.setTermWorkingDirectory(s.cwd.host, s.cwd.path)
+}
+
+// WorkingDirectory returns the host and path most recently reported by
+// the application via OSC 7. Both are empty if nothing has been reported.
+func (s *State) WorkingDirectory() (host, path string) {
+	return s.cwd.host, s.cwd.path
 }
@@ -11,6 +11,10 @@ const (
 	TermAttrMouse
 	TermAttrAltScreen
 	TermAttrOSC
+	TermAttrWorkingDirectory
+	TermAttrCursorShape
+	TermAttrCursorColor
+	TermAttrSyncUpdate
 )
 
 //go:generate stringer -type=TermAttr
@@ -16,11 +16,15 @@ func _() {
 	_ = x[TermAttrMouse-5]
 	_ = x[TermAttrAltScreen-6]
 	_ = x[TermAttrOSC-7]
+	_ = x[TermAttrWorkingDirectory-8]
+	_ = x[TermAttrCursorShape-9]
+	_ = x[TermAttrCursorColor-10]
+	_ = x[TermAttrSyncUpdate-11]
 }
 
-const _TermAttr_name = "TermAttrTitleTermAttrIconNameTermAttrReverseTermAttrBlinkTermAttrVisibleTermAttrMouseTermAttrAltScreenTermAttrOSC"
+const _TermAttr_name = "TermAttrTitleTermAttrIconNameTermAttrReverseTermAttrBlinkTermAttrVisibleTermAttrMouseTermAttrAltScreenTermAttrOSCTermAttrWorkingDirectoryTermAttrCursorShapeTermAttrCursorColorTermAttrSyncUpdate"
 
-var _TermAttr_index = [...]uint8{0, 13, 29, 44, 57, 72, 85, 102, 113}
+var _TermAttr_index = [...]uint8{0, 13, 29, 44, 57, 72, 85, 102, 113, 137, 156, 175, 193}
 
 func (i TermAttr) String() string {
 	if i < 0 || i >= TermAttr(len(_TermAttr_index)-1) {
@@ -0,0 +1,179 @@
+package state
+
+import (
+	"fmt"
+
+	"github.com/lab47/vterm/parser"
+)
+
+// Window can optionally be implemented by an Output to provide what is
+// needed to answer XTWINOPS (CSI t) queries and requests.
+type Window interface {
+	// CellSize returns the size of a character cell in pixels, or zeros if
+	// it isn't known.
+	CellSize() (width, height int)
+
+	// RequestResize is called when the application asks for the text area
+	// to be resized. If it returns true, the state and its output are
+	// resized, otherwise the request is ignored. The embedder is expected
+	// to carry the accepted size to anything else that cares about it,
+	// such as a pty.
+	RequestResize(rows, cols int) bool
+}
+
+// The max number of entries kept on the title and icon name stacks,
+// same as xterm.
+const maxTitleStack = 10
+
+func (s *State) windowOps(ev *parser.CSIEvent) error {
+	if len(ev.Args) == 0 {
+		return nil
+	}
+
+	arg := func(i int) int {
+		if len(ev.Args) > i && ev.Args[i] > 0 {
+			return ev.Args[i]
+		}
+
+		return 0
+	}
+
+	switch ev.Args[0] {
+	case 4: // resize in pixels
+		if s.window == nil {
+			return nil
+		}
+
+		width, height := s.window.CellSize()
+		if width == 0 || height == 0 {
+			return nil
+		}
+
+		rows, cols := s.rows, s.cols
+
+		if h := arg(1); h > 0 {
+			rows = h / height
+		}
+
+		if w := arg(2); w > 0 {
+			cols = w / width
+		}
+
+		return s.requestResize(rows, cols)
+	case 8: // resize in characters
+		rows, cols := s.rows, s.cols
+
+		if r := arg(1); r > 0 {
+			rows = r
+		}
+
+		if c := arg(2); c > 0 {
+			cols = c
+		}
+
+		return s.requestResize(rows, cols)
+	case 14: // text area size in pixels
+		width, height := s.cellSize()
+		if width == 0 || height == 0 {
+			return nil
+		}
+
+		return s.output.Output([]byte(fmt.Sprintf("%s4;%d;%dt", s.csi(), s.rows*height, s.cols*width)))
+	case 15: // screen size in pixels, which is all the text area as with 19
+		width, height := s.cellSize()
+		if width == 0 || height == 0 {
+			return nil
+		}
+
+		return s.output.Output([]byte(fmt.Sprintf("%s5;%d;%dt", s.csi(), s.rows*height, s.cols*width)))
+	case 16: // cell size in pixels
+		width, height := s.cellSize()
+		if width == 0 || height == 0 {
+			return nil
+		}
+
+		return s.output.Output([]byte(fmt.Sprintf("%s6;%d;%dt", s.csi(), height, width)))
+	case 18: // text area size in characters
+		return s.output.Output([]byte(fmt.Sprintf("%s8;%d;%dt", s.csi(), s.rows, s.cols)))
+	case 19: // screen size in characters
+		return s.output.Output([]byte(fmt.Sprintf("%s9;%d;%dt", s.csi(), s.rows, s.cols)))
+	case 22:
+		s.pushTitle(arg(1))
+	case 23:
+		return s.popTitle(arg(1))
+	}
+
+	return nil
+}
+
+func (s *State) cellSize() (int, int) {
+	if s.window == nil {
+		return 0, 0
+	}
+
+	return s.window.CellSize()
+}
+
+func (s *State) requestResize(rows, cols int) error {
+	if rows < 1 || cols < 1 || (rows == s.rows && cols == s.cols) {
+		return nil
+	}
+
+	if s.window == nil || !s.window.RequestResize(rows, cols) {
+		return nil
+	}
+
+	return s.Resize(rows, cols)
+}
+
+// pushTitle saves the title and/or icon name. +which+ is 0 for both,
+// 1 for the icon name and 2 for the title.
+func (s *State) pushTitle(which int) {
+	push := func(stack []string, val string) []string {
+		if len(stack) >= maxTitleStack {
+			stack = stack[1:]
+		}
+
+		return append(stack, val)
+	}
+
+	if which == 0 || which == 1 {
+		s.iconStack = push(s.iconStack, s.iconName)
+	}
+
+	if which == 0 || which == 2 {
+		s.titleStack = push(s.titleStack, s.title)
+	}
+}
+
+// popTitle restores the title and/or icon name saved by pushTitle
+func (s *State) popTitle(which int) error {
+	if (which == 0 || which == 1) && len(s.iconStack) > 0 {
+		name := s.iconStack[len(s.iconStack)-1]
+		s.iconStack = s.iconStack[:len(s.iconStack)-1]
+
+		err := s.setIconName(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	if (which == 0 || which == 2) && len(s.titleStack) > 0 {
+		title := s.titleStack[len(s.titleStack)-1]
+		s.titleStack = s.titleStack[:len(s.titleStack)-1]
+
+		return s.setTitle(title)
+	}
+
+	return nil
+}
+
+func (s *State) setTitle(title string) error {
+	s.title = title
+	return s.setTermTitle(title)
+}
+
+func (s *State) setIconName(name string) error {
+	s.iconName = name
+	return s.setTermIconName(name)
+}
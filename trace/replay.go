@@ -0,0 +1,194 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/lab47/vterm/parser"
+	"github.com/lab47/vterm/screen"
+	"github.com/lab47/vterm/state"
+)
+
+// Player replays a trace into a State and Screen.
+type Player struct {
+	Screen *screen.Screen
+	State  *state.State
+
+	reader  *Reader
+	parser  *parser.Parser
+	tracker cursorTracker
+}
+
+// NewPlayer reads the header of the trace in +r+ and sets up a State and
+// Screen of its size to replay it into.
+func NewPlayer(r io.Reader) (*Player, error) {
+	tr, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Player{
+		reader: tr,
+	}
+
+	p.Screen, err = screen.NewScreen(tr.Header.Rows, tr.Header.Cols, &p.tracker)
+	if err != nil {
+		return nil, err
+	}
+
+	p.State, err = state.NewState(tr.Header.Rows, tr.Header.Cols, p.Screen)
+	if err != nil {
+		return nil, err
+	}
+
+	p.parser, err = parser.NewParser(nil, p.State)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Header returns the header of the trace.
+func (p *Player) Header() Header {
+	return p.reader.Header
+}
+
+// Cursor returns where the cursor is.
+func (p *Player) Cursor() state.Pos {
+	return p.tracker.cursor
+}
+
+// Step replays the next record and returns it, or returns io.EOF at the
+// end of the trace. For a Screen record, the screen is checked against it
+// and a *MismatchError returned if it's different.
+func (p *Player) Step() (Record, error) {
+	rec, err := p.reader.Next()
+	if err != nil {
+		return rec, err
+	}
+
+	switch rec.Kind {
+	case Output:
+		_, err = p.parser.Write(rec.Data)
+	case Resize:
+		err = p.parser.Resize(context.Background(), rec.Rows, rec.Cols)
+	case Screen:
+		err = p.check(rec)
+	}
+
+	return rec, err
+}
+
+// Run replays the rest of the trace, stopping at the first error.
+func (p *Player) Run() error {
+	for {
+		_, err := p.Step()
+		if err != nil {
+			if err == io.EOF {
+				return p.parser.Flush()
+			}
+
+			return err
+		}
+	}
+}
+
+func (p *Player) check(rec Record) error {
+	lines := p.Screen.Text()
+
+	same := len(lines) == len(rec.Lines)
+
+	for i := 0; same && i < len(lines); i++ {
+		same = lines[i] == rec.Lines[i]
+	}
+
+	if same && (rec.Cursor == nil || *rec.Cursor == p.tracker.cursor) {
+		return nil
+	}
+
+	return &MismatchError{
+		Time:       rec.Time,
+		Want:       rec.Lines,
+		Got:        lines,
+		WantCursor: rec.Cursor,
+		GotCursor:  p.tracker.cursor,
+	}
+}
+
+// MismatchError is returned when the screen isn't what a Screen record
+// says it should be.
+type MismatchError struct {
+	// The time of the Screen record.
+	Time time.Duration
+
+	Want, Got []string
+
+	// WantCursor is nil if the record didn't include the cursor.
+	WantCursor *state.Pos
+	GotCursor  state.Pos
+}
+
+func (e *MismatchError) Error() string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "screen mismatch at %s", e.Time)
+
+	if e.WantCursor != nil && *e.WantCursor != e.GotCursor {
+		fmt.Fprintf(&sb, "\ncursor: want %d,%d got %d,%d",
+			e.WantCursor.Row, e.WantCursor.Col, e.GotCursor.Row, e.GotCursor.Col)
+	}
+
+	rows := len(e.Want)
+	if len(e.Got) > rows {
+		rows = len(e.Got)
+	}
+
+	for row := 0; row < rows; row++ {
+		var want, got string
+
+		if row < len(e.Want) {
+			want = e.Want[row]
+		}
+
+		if row < len(e.Got) {
+			got = e.Got[row]
+		}
+
+		if want != got {
+			fmt.Fprintf(&sb, "\nrow %d:\n  want %q\n  got  %q", row, want, got)
+		}
+	}
+
+	return sb.String()
+}
+
+// cursorTracker is the Updates for the Player's Screen. It keeps track of
+// the cursor and ignores the rest.
+type cursorTracker struct {
+	cursor state.Pos
+}
+
+func (c *cursorTracker) DamageDone(r state.Rect, cr screen.CellReader) error {
+	return nil
+}
+
+func (c *cursorTracker) MoveCursor(pos state.Pos) error {
+	c.cursor = pos
+	return nil
+}
+
+func (c *cursorTracker) SetTermProp(attr state.TermAttr, val interface{}) error {
+	return nil
+}
+
+func (c *cursorTracker) Output(data []byte) error {
+	return nil
+}
+
+func (c *cursorTracker) StringEvent(kind string, data []byte) error {
+	return nil
+}
@@ -0,0 +1,209 @@
+// Package trace records what passes between a program and the terminal
+// it runs in, so the session can be replayed later. A trace can be
+// attached to a bug report, and then replayed in a test to check the
+// screen ends up right.
+//
+// A trace is JSON lines. The first line is a Header giving the version of
+// the format and the starting size, and each line after it is a Record.
+// Byte data is base64 encoded, as encoding/json does for []byte.
+package trace
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/lab47/vterm/state"
+)
+
+// Version is the version of the format written by Writer. Reader accepts
+// this version and anything older.
+const Version = 1
+
+// ErrNotTrace is returned by NewReader when the input doesn't start with a
+// trace header.
+var ErrNotTrace = errors.New("not a trace")
+
+// Header is the first line of a trace.
+type Header struct {
+	Version int `json:"version"`
+
+	// The size of the terminal when the trace started.
+	Rows int `json:"rows"`
+	Cols int `json:"cols"`
+
+	// When the trace started.
+	Time time.Time `json:"time"`
+
+	// The value of TERM and the command that was run, if known.
+	Term    string `json:"term,omitempty"`
+	Command string `json:"command,omitempty"`
+}
+
+// Kind is the type of a Record.
+type Kind string
+
+const (
+	// Output is bytes sent by the program to the terminal.
+	Output Kind = "output"
+
+	// Input is bytes sent by the terminal to the program, such as keys
+	// typed. Replaying doesn't use it, but it shows what led to the
+	// output.
+	Input Kind = "input"
+
+	// Resize is the terminal changing size.
+	Resize Kind = "resize"
+
+	// Screen is what the screen is expected to show at that point, for
+	// checking a replay.
+	Screen Kind = "screen"
+)
+
+// Record is one line of a trace after the header.
+type Record struct {
+	// The time since the trace started.
+	Time time.Duration `json:"t"`
+
+	Kind Kind `json:"kind"`
+
+	// The bytes of an Output or Input record.
+	Data []byte `json:"data,omitempty"`
+
+	// The new size of a Resize record.
+	Rows int `json:"rows,omitempty"`
+	Cols int `json:"cols,omitempty"`
+
+	// The text of each row for a Screen record, as returned by
+	// screen.Screen.Text, and where the cursor is.
+	Lines  []string   `json:"lines,omitempty"`
+	Cursor *state.Pos `json:"cursor,omitempty"`
+}
+
+// Writer writes a trace. It's safe to use from multiple goroutines, such
+// as one copying output and another copying input.
+type Writer struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	start time.Time
+}
+
+// NewWriter writes the header to +w+ and returns a Writer for the records
+// after it. The version is filled in, as is the time if it's zero.
+func NewWriter(w io.Writer, h Header) (*Writer, error) {
+	h.Version = Version
+
+	if h.Time.IsZero() {
+		h.Time = time.Now()
+	}
+
+	tw := &Writer{
+		enc:   json.NewEncoder(w),
+		start: time.Now(),
+	}
+
+	err := tw.enc.Encode(h)
+	if err != nil {
+		return nil, err
+	}
+
+	return tw, nil
+}
+
+// Write records +p+ as output from the program, so that a Writer can sit
+// in an io.MultiWriter next to the real terminal.
+func (w *Writer) Write(p []byte) (int, error) {
+	err := w.Record(Record{Kind: Output, Data: p})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Input records +data+ as input sent to the program.
+func (w *Writer) Input(data []byte) error {
+	return w.Record(Record{Kind: Input, Data: data})
+}
+
+// Resize records the terminal changing size.
+func (w *Writer) Resize(rows, cols int) error {
+	return w.Record(Record{Kind: Resize, Rows: rows, Cols: cols})
+}
+
+// Screen records what the screen is expected to show.
+func (w *Writer) Screen(lines []string, cursor state.Pos) error {
+	return w.Record(Record{Kind: Screen, Lines: lines, Cursor: &cursor})
+}
+
+// Record writes +rec+ with its time set to now.
+func (w *Writer) Record(rec Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	rec.Time = time.Since(w.start)
+
+	return w.enc.Encode(rec)
+}
+
+// Reader reads a trace.
+type Reader struct {
+	Header Header
+
+	dec *json.Decoder
+}
+
+// NewReader reads the header from +r+ and returns a Reader for the
+// records after it.
+func NewReader(r io.Reader) (*Reader, error) {
+	tr := &Reader{
+		dec: json.NewDecoder(r),
+	}
+
+	err := tr.dec.Decode(&tr.Header)
+	if err != nil {
+		if err == io.EOF {
+			return nil, ErrNotTrace
+		}
+
+		return nil, fmt.Errorf("%w: %s", ErrNotTrace, err)
+	}
+
+	switch {
+	case tr.Header.Version < 1:
+		return nil, ErrNotTrace
+	case tr.Header.Version > Version:
+		return nil, fmt.Errorf("unsupported trace version: %d", tr.Header.Version)
+	case tr.Header.Rows < 1 || tr.Header.Cols < 1:
+		return nil, fmt.Errorf("invalid trace size: %dx%d", tr.Header.Rows, tr.Header.Cols)
+	}
+
+	return tr, nil
+}
+
+// Next returns the next record, or io.EOF at the end of the trace.
+// Records of a kind this version doesn't know are skipped.
+func (r *Reader) Next() (Record, error) {
+	for {
+		var rec Record
+
+		err := r.dec.Decode(&rec)
+		if err != nil {
+			return rec, err
+		}
+
+		switch rec.Kind {
+		case Output, Input, Screen:
+			return rec, nil
+		case Resize:
+			if rec.Rows < 1 || rec.Cols < 1 {
+				return rec, fmt.Errorf("invalid resize at %s: %dx%d", rec.Time, rec.Rows, rec.Cols)
+			}
+
+			return rec, nil
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package trace
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lab47/vterm/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestTrace(t *testing.T) {
+	n := neko.Modern(t)
+
+	n.It("reads back what was written", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		tw, err := NewWriter(&buf, Header{Rows: 5, Cols: 20, Term: "xterm"})
+		require.NoError(t, err)
+
+		_, err = tw.Write([]byte("hello\x1b[1m\xff"))
+		require.NoError(t, err)
+
+		require.NoError(t, tw.Input([]byte("q")))
+		require.NoError(t, tw.Resize(10, 40))
+		require.NoError(t, tw.Screen([]string{"hello"}, state.Pos{Row: 0, Col: 5}))
+
+		tr, err := NewReader(&buf)
+		require.NoError(t, err)
+
+		assert.Equal(t, Version, tr.Header.Version)
+		assert.Equal(t, 5, tr.Header.Rows)
+		assert.Equal(t, 20, tr.Header.Cols)
+		assert.Equal(t, "xterm", tr.Header.Term)
+		assert.False(t, tr.Header.Time.IsZero())
+
+		rec, err := tr.Next()
+		require.NoError(t, err)
+		assert.Equal(t, Output, rec.Kind)
+		assert.Equal(t, []byte("hello\x1b[1m\xff"), rec.Data)
+
+		rec, err = tr.Next()
+		require.NoError(t, err)
+		assert.Equal(t, Input, rec.Kind)
+		assert.Equal(t, []byte("q"), rec.Data)
+
+		rec, err = tr.Next()
+		require.NoError(t, err)
+		assert.Equal(t, Resize, rec.Kind)
+		assert.Equal(t, 10, rec.Rows)
+		assert.Equal(t, 40, rec.Cols)
+
+		rec, err = tr.Next()
+		require.NoError(t, err)
+		assert.Equal(t, Screen, rec.Kind)
+		assert.Equal(t, []string{"hello"}, rec.Lines)
+		assert.Equal(t, &state.Pos{Row: 0, Col: 5}, rec.Cursor)
+
+		_, err = tr.Next()
+		assert.Equal(t, io.EOF, err)
+	})
+
+	n.It("rejects input that isn't a trace it can read", func(t *testing.T) {
+		_, err := NewReader(strings.NewReader(""))
+		assert.Equal(t, ErrNotTrace, err)
+
+		_, err = NewReader(strings.NewReader("hello\n"))
+		assert.True(t, errors.Is(err, ErrNotTrace))
+
+		_, err = NewReader(strings.NewReader(`{"rows":5,"cols":5}`))
+		assert.Equal(t, ErrNotTrace, err)
+
+		_, err = NewReader(strings.NewReader(`{"version":99,"rows":5,"cols":5}`))
+		assert.Error(t, err)
+	})
+
+	n.It("skips records of kinds it doesn't know", func(t *testing.T) {
+		tr, err := NewReader(strings.NewReader(`{"version":1,"rows":5,"cols":5}
+{"t":1,"kind":"mouse"}
+{"t":2,"kind":"resize","rows":3,"cols":4}
+`))
+		require.NoError(t, err)
+
+		rec, err := tr.Next()
+		require.NoError(t, err)
+		assert.Equal(t, Resize, rec.Kind)
+	})
+
+	n.It("replays a trace step by step", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		tw, err := NewWriter(&buf, Header{Rows: 3, Cols: 10})
+		require.NoError(t, err)
+
+		tw.Write([]byte("$ ls\r\n"))
+		tw.Write([]byte("a b\r\n$ "))
+		tw.Resize(2, 8)
+
+		p, err := NewPlayer(&buf)
+		require.NoError(t, err)
+
+		_, err = p.Step()
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"$ ls", "", ""}, p.Screen.Text())
+		assert.Equal(t, state.Pos{Row: 1, Col: 0}, p.Cursor())
+
+		_, err = p.Step()
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"$ ls", "a b", "$"}, p.Screen.Text())
+		assert.Equal(t, state.Pos{Row: 2, Col: 2}, p.Cursor())
+
+		rec, err := p.Step()
+		require.NoError(t, err)
+		assert.Equal(t, Resize, rec.Kind)
+
+		rows, cols := p.Screen.Size()
+		assert.Equal(t, 2, rows)
+		assert.Equal(t, 8, cols)
+
+		_, err = p.Step()
+		assert.Equal(t, io.EOF, err)
+	})
+
+	n.It("reports where the screen differs from what was recorded", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		tw, err := NewWriter(&buf, Header{Rows: 2, Cols: 10})
+		require.NoError(t, err)
+
+		tw.Write([]byte("hello"))
+		tw.Screen([]string{"hello", ""}, state.Pos{Row: 0, Col: 5})
+		tw.Screen([]string{"help", ""}, state.Pos{Row: 0, Col: 4})
+
+		p, err := NewPlayer(&buf)
+		require.NoError(t, err)
+
+		_, err = p.Step()
+		require.NoError(t, err)
+
+		_, err = p.Step()
+		require.NoError(t, err)
+
+		_, err = p.Step()
+		require.Error(t, err)
+
+		merr, ok := err.(*MismatchError)
+		require.True(t, ok)
+
+		assert.Equal(t, []string{"hello", ""}, merr.Got)
+		assert.Contains(t, merr.Error(), `want "help"`)
+		assert.Contains(t, merr.Error(), "cursor: want 0,4 got 0,5")
+	})
+
+	n.Meow()
+}
+
+// Every trace in testdata is replayed, checking the screen at each Screen
+// record in it. A trace attached to a bug report can be dropped in with
+// the screen it should show added at the end.
+func TestTraceFiles(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.trace"))
+	require.NoError(t, err)
+
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			f, err := os.Open(path)
+			require.NoError(t, err)
+
+			defer f.Close()
+
+			p, err := NewPlayer(f)
+			require.NoError(t, err)
+
+			require.NoError(t, p.Run())
+		})
+	}
+}